@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	common "github.com/ncabatoff/process-exporter"
+	"github.com/ncabatoff/process-exporter/config"
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+// checkingNamer wraps a config's matchers, remembering any errors that
+// occur while naming procs, e.g. due to bad templates.
+type checkingNamer struct {
+	config.FirstMatcher
+	errs []error
+}
+
+func (cn *checkingNamer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
+	matched, name, _ := cn.MatchAndNameRule(nacl)
+	return matched, name
+}
+
+// MatchAndNameRule implements common.RuleMatchNamer, which the tracker
+// prefers, so it must check for errors too.
+func (cn *checkingNamer) MatchAndNameRule(nacl common.ProcAttributes) (bool, string, int) {
+	matched, name, rule, err := cn.CheckMatchAndNameRule(nacl)
+	if err != nil {
+		cn.errs = append(cn.errs, fmt.Errorf("pid %d: %v", nacl.PID, err))
+	}
+	return matched, name, rule
+}
+
+// checkConfig reads all procs under procfsPath once and writes to w a table
+// describing which group each would be assigned to by namer and
+// childrenPolicy, which may be nil.  It returns an
+// error if the procs couldn't be read or if naming any of them failed.
+func checkConfig(w io.Writer, namer common.MatchNamer, childrenPolicy proc.ChildrenPolicy, catchAll, procfsPath string, children, debug bool) error {
+	fs, err := proc.NewFS(procfsPath, debug)
+	if err != nil {
+		return err
+	}
+	return checkProcs(w, fs.AllProcs(), namer, childrenPolicy, catchAll, children, debug)
+}
+
+// checkProcs is checkConfig for the procs of iter.
+func checkProcs(w io.Writer, iter proc.Iter, namer common.MatchNamer, childrenPolicy proc.ChildrenPolicy, catchAll string, children, debug bool) error {
+	var infos []proc.IDInfo
+	for iter.Next() {
+		id, err := iter.GetProcID()
+		if err != nil {
+			continue
+		}
+		static, err := iter.GetStatic()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, proc.IDInfo{ID: id, Static: static})
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	if cfgNamer, ok := namer.(config.FirstMatcher); ok {
+		namer = &checkingNamer{FirstMatcher: cfgNamer}
+	}
+
+	tracker := proc.NewTracker(namer, children, false, debug)
+	tracker.SetCatchAll(catchAll)
+	if childrenPolicy != nil {
+		tracker.SetChildrenPolicy(childrenPolicy)
+	}
+	if _, _, err := tracker.Update(proc.NewIDInfoIter(infos...)); err != nil {
+		return err
+	}
+
+	tracked := make(map[proc.ID]proc.TrackedProc)
+	for _, tp := range tracker.Tracked() {
+		tracked[tp.ID] = tp
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Pid < infos[j].Pid })
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tCOMM\tGROUP\tCMDLINE")
+	for _, info := range infos {
+		group := "ignored"
+		if tp, ok := tracked[info.ID]; ok {
+			group = tp.GroupName
+			if tp.InheritedFrom != 0 {
+				group = fmt.Sprintf("%s (inherited from parent pid %d)", tp.GroupName, tp.InheritedFrom)
+			} else if tp.Unmatched {
+				group = fmt.Sprintf("%s (unmatched)", tp.GroupName)
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", info.Pid, info.Name, group, strings.Join(info.Cmdline, " "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if cn, ok := namer.(*checkingNamer); ok && len(cn.errs) > 0 {
+		for _, err := range cn.errs {
+			fmt.Fprintln(w, err)
+		}
+		return fmt.Errorf("%d procs could not be named", len(cn.errs))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ncabatoff/process-exporter/config"
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+// TestCheckProcs verifies that the table written by checkProcs shows the
+// procs named directly, inherited from their parent, unmatched and ignored,
+// and that name template errors are listed after it and returned.
+func TestCheckProcs(t *testing.T) {
+	newProc := func(pid, ppid int, name string, cmdline ...string) proc.IDInfo {
+		return proc.IDInfo{
+			ID:     proc.ID{Pid: pid, StartTimeRel: 1},
+			Static: proc.Static{Name: name, Cmdline: cmdline, ParentPid: ppid},
+		}
+	}
+	procs := []proc.IDInfo{
+		newProc(3, 0, "cron", "/usr/sbin/cron"),
+		newProc(2, 1, "sleep", "sleep", "10"),
+		newProc(1, 0, "bash", "/bin/bash"),
+	}
+
+	for i, tc := range []struct {
+		template string
+		catchAll string
+		want     string
+		// wantErrs holds the prefixes of the errors listed after the table.
+		wantErrs []string
+	}{
+		{
+			template: "{{.Comm}}",
+			want: "" +
+				"PID  COMM   GROUP                               CMDLINE\n" +
+				"1    bash   bash                                /bin/bash\n" +
+				"2    sleep  bash (inherited from parent pid 1)  sleep 10\n" +
+				"3    cron   ignored                             /usr/sbin/cron\n",
+		},
+		{
+			template: "{{.Comm}}",
+			catchAll: "other",
+			want: "" +
+				"PID  COMM   GROUP                               CMDLINE\n" +
+				"1    bash   bash                                /bin/bash\n" +
+				"2    sleep  bash (inherited from parent pid 1)  sleep 10\n" +
+				"3    cron   other (unmatched)                   /usr/sbin/cron\n",
+		},
+		{
+			template: "{{.Comm.Foo}}",
+			want: "" +
+				"PID  COMM   GROUP                           CMDLINE\n" +
+				"1    bash                                   /bin/bash\n" +
+				"2    sleep   (inherited from parent pid 1)  sleep 10\n" +
+				"3    cron   ignored                         /usr/sbin/cron\n",
+			wantErrs: []string{`pid 1: error executing name template for "bash"`},
+		},
+	} {
+		cfg, err := config.GetConfig("process_names:\n- comm: [bash]\n  name: '"+tc.template+"'\n", false)
+		noerr(t, err)
+		var buf bytes.Buffer
+		err = checkProcs(&buf, proc.NewIDInfoIter(procs...), cfg.MatchNamers, nil, tc.catchAll, true, false)
+		if gotErr := err != nil; gotErr != (len(tc.wantErrs) > 0) {
+			t.Errorf("%d: got error %v, want %d naming errors", i, err, len(tc.wantErrs))
+		}
+
+		got := buf.String()
+		if !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%d: got output\n%s\nwant\n%s", i, got, tc.want)
+			continue
+		}
+		var errs []string
+		if rest := strings.TrimPrefix(got, tc.want); rest != "" {
+			errs = strings.Split(strings.TrimSuffix(rest, "\n"), "\n")
+		}
+		if len(errs) != len(tc.wantErrs) {
+			t.Errorf("%d: got errors %q, want %q", i, errs, tc.wantErrs)
+			continue
+		}
+		for j, prefix := range tc.wantErrs {
+			if !strings.HasPrefix(errs[j], prefix) {
+				t.Errorf("%d: got error %q, want %q", i, errs[j], prefix)
+			}
+		}
+	}
+}
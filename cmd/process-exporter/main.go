@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -15,6 +16,8 @@ import (
 	common "github.com/ncabatoff/process-exporter"
 	"github.com/ncabatoff/process-exporter/collector"
 	"github.com/ncabatoff/process-exporter/config"
+	"github.com/ncabatoff/process-exporter/proc"
+	"github.com/ncabatoff/process-exporter/push"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/promlog"
@@ -33,6 +36,18 @@ or
 
   process-exporter [options] -procnames name1,...,nameN [-namemapping k1,v1,...,kN,vN]
 
+or
+
+  process-exporter check-config [options] -config.path filename.yml
+
+or
+
+  process-exporter record [options] -record.output snapshots.tar.gz
+
+or
+
+  process-exporter replay [options] -config.path filename.yml -replay.input snapshots.tar.gz
+
 The recommended option is to use a config file, but for convenience and
 backwards compatibility the -procnames/-namemapping options exist as an
 alternative.
@@ -41,7 +56,8 @@ The -children option (default:true) makes it so that any process that otherwise
 isn't part of its own group becomes part of the first group found (if any) when
 walking the process tree upwards.  In other words, resource usage of
 subprocesses is added to their parent's usage unless the subprocess identifies
-as a different group name.
+as a different group name.  Groups in a config file can override this with
+their own children settings.
 
 Command-line process selection (procnames/namemapping):
 
@@ -70,6 +86,24 @@ Command-line process selection (procnames/namemapping):
 Config file process selection (filename.yml):
 
   See README.md.
+
+Checking a configuration:
+
+  The check-config subcommand (or equivalently the -dry-run option) reads every
+  process once, prints a table showing the group each would be assigned to
+  (or "ignored"), and exits.  The exit status is non-zero if the config is
+  invalid or if naming any process failed, e.g. due to a bad name template.
+
+Recording and replaying:
+
+  The record subcommand archives the /proc files process-exporter reads, for
+  all processes, every -record.interval into a gzipped tarball.  The replay
+  subcommand reads such a tarball back, groups the recorded processes using
+  the given config or procnames, and prints the usage of each group between
+  successive snapshots, as the top subcommand does.  Without the subcommand,
+  -replay.input makes the exporter print the metrics of the last snapshot
+  once, in the format -once-to-stdout-format selects, with counters covering
+  the usage since the first.
 ` + "\n")
 
 }
@@ -139,6 +173,16 @@ func (nmr *nameMapperRegex) MatchAndName(nacl common.ProcAttributes) (bool, stri
 	return false, ""
 }
 
+// contains returns true if s is in list.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
 	promVersion.Version = version
 	prometheus.MustRegister(promVersion.NewCollector("process_exporter"))
@@ -147,15 +191,22 @@ func init() {
 func main() {
 	var (
 		listenAddress = flag.String("web.listen-address", ":9256",
-			"Address on which to expose metrics and web interface.")
+			"Address on which to expose metrics and web interface; if empty, only push or export metrics.")
 		metricsPath = flag.String("web.telemetry-path", "/metrics",
 			"Path under which to expose metrics.")
 		onceToStdoutDelay = flag.Duration("once-to-stdout-delay", 0,
 			"Don't bind, just wait this much time, print the metrics once to stdout, and exit")
+		onceToStdoutFormat = flag.String("once-to-stdout-format", "prometheus",
+			"with -once-to-stdout-delay, the format to print the metrics in: prometheus, or one of "+
+				strings.Join(collector.SnapshotFormats, ", ")+" to print only group metrics")
+		onceToStdoutTree = flag.String("once-to-stdout-tree", "",
+			"with -once-to-stdout-delay, print the tracked process tree instead of the metrics, as text or json")
 		procNames = flag.String("procnames", "",
 			"comma-separated list of process names to monitor")
 		procfsPath = flag.String("procfs", "/proc",
 			"path to read proc data from")
+		sysfsPath = flag.String("sysfs", "/sys",
+			"path to read the NUMA topology from")
 		nameMapping = flag.String("namemapping", "",
 			"comma-separated list, alternating process name and capturing regex to apply to cmdline")
 		children = flag.Bool("children", true,
@@ -164,6 +215,8 @@ func main() {
 			"report on per-threadname metrics as well")
 		smaps = flag.Bool("gather-smaps", true,
 			"gather metrics from smaps file, which contains proportional resident memory size")
+		numaMaps = flag.Bool("gather-numa-maps", false,
+			"gather metrics from numa_maps file, which contains memory per NUMA node; expensive for procs with many mappings")
 		man = flag.Bool("man", false,
 			"print manual")
 		configPath = flag.String("config.path", "",
@@ -176,8 +229,82 @@ func main() {
 			"log debugging information to stdout")
 		showVersion = flag.Bool("version", false,
 			"print version information and exit")
+		dryRun = flag.Bool("dry-run", false,
+			"print the group each process would be assigned to and exit")
+		topInterval = flag.Duration("top.interval", 2*time.Second,
+			"with the top subcommand, the time between refreshes")
+		topSortBy = flag.String("top.sort", "cpu",
+			"with the top and replay subcommands, the column to sort on: cpu, rss, read, write, fds, threads, procs or name")
+		topIterations = flag.Int("top.iterations", 0,
+			"with the top subcommand, exit after this many refreshes; 0 means no limit")
+		recordOutput = flag.String("record.output", "",
+			"with the record subcommand, the file to write the snapshots to")
+		recordInterval = flag.Duration("record.interval", 10*time.Second,
+			"with the record subcommand, the time between snapshots")
+		recordCount = flag.Int("record.count", 6,
+			"with the record subcommand, the number of snapshots to take; 0 means until interrupted")
+		replayInput = flag.String("replay.input", "",
+			"the file written by the record subcommand to read snapshots from, with the replay subcommand; "+
+				"otherwise, print the metrics of its last snapshot once, as -once-to-stdout-delay does, instead of reading procs")
+		pushURL = flag.String("push.url", "",
+			"if set, also push the metrics to this Pushgateway base URL or remote-write endpoint")
+		pushMode = flag.String("push.mode", push.ModePushgateway,
+			"how to push to -push.url: "+strings.Join(push.Modes, " or "))
+		pushJob = flag.String("push.job", "process-exporter",
+			"value of the job label of pushed metrics")
+		pushInstance = flag.String("push.instance", "",
+			"value of the instance label of pushed metrics; defaults to the hostname")
+		pushInterval = flag.Duration("push.interval", 15*time.Second,
+			"time between pushes")
+		pushTimeout = flag.Duration("push.timeout", 10*time.Second,
+			"timeout of each push request")
+		pushRetries = flag.Int("push.retries", 3,
+			"number of times a failed push or OTLP export request is retried, with exponential backoff")
+		pushBatchSize = flag.Int("push.batch-size", 1000,
+			"with -push.mode=remote-write, the maximum number of series per request; 0 means no limit")
+		pushBufferDir = flag.String("push.buffer-dir", "",
+			"with -push.mode=remote-write, directory in which to keep requests that couldn't be sent, to retry later")
+		pushBufferMaxBytes = flag.Int64("push.buffer-max-bytes", 64<<20,
+			"with -push.buffer-dir, the size beyond which the oldest buffered requests are dropped; 0 means no limit")
+		otlpEndpoint = flag.String("otlp.endpoint", "",
+			"if set, also export group metrics to this OpenTelemetry collector OTLP/HTTP URL, e.g. http://localhost:4318")
+		otlpHeaders = flag.String("otlp.headers", "",
+			"comma-separated list of key=value headers to add to OTLP requests")
+		otlpInterval = flag.Duration("otlp.interval", 15*time.Second,
+			"time between OTLP exports")
+		otlpTimeout = flag.Duration("otlp.timeout", 10*time.Second,
+			"timeout of each OTLP export request")
+		statsdAddress = flag.String("statsd.address", "",
+			"if set, also send group metrics to this StatsD host:port over UDP")
+		statsdPrefix = flag.String("statsd.prefix", "namedprocess",
+			"prefix of the StatsD metric names")
+		statsdDogStatsD = flag.Bool("statsd.dogstatsd", false,
+			"send the group name and labels as DogStatsD tags instead of as parts of the metric names")
+		graphiteAddress = flag.String("graphite.address", "",
+			"if set, also send group metrics to this Graphite plaintext host:port")
+		graphitePrefix = flag.String("graphite.prefix", "namedprocess",
+			"prefix of the Graphite metric paths")
+		sinkInterval = flag.Duration("sink.interval", 10*time.Second,
+			"time between sends to -statsd.address and -graphite.address")
+		topMode    bool
+		recordMode bool
+		replayMode bool
 	)
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		*dryRun = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == "top" {
+		topMode = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == "record" {
+		recordMode = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayMode = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
 	promlogConfig := &promlog.Config{}
 	logger := promlog.New(promlogConfig)
@@ -192,7 +319,45 @@ func main() {
 		return
 	}
 
-	var matchnamer common.MatchNamer
+	if *onceToStdoutTree != "" && *onceToStdoutTree != "text" && *onceToStdoutTree != "json" {
+		log.Fatalf("-once-to-stdout-tree must be text or json, not %q", *onceToStdoutTree)
+	}
+	if *onceToStdoutFormat != "prometheus" && !contains(collector.SnapshotFormats, *onceToStdoutFormat) {
+		log.Fatalf("-once-to-stdout-format must be prometheus or one of %s, not %q",
+			strings.Join(collector.SnapshotFormats, ", "), *onceToStdoutFormat)
+	}
+	if *onceToStdoutTree != "" && *onceToStdoutFormat != "prometheus" {
+		log.Fatalf("-once-to-stdout-tree cannot be used with -once-to-stdout-format")
+	}
+
+	if recordMode {
+		if *recordOutput == "" {
+			log.Fatalf("the record subcommand requires -record.output")
+		}
+		err := runRecord(*procfsPath, *recordOutput, *recordInterval, *recordCount, *smaps, *numaMaps)
+		if err != nil {
+			log.Fatalf("Error recording snapshots: %v", err)
+		}
+		return
+	}
+	if replayMode && *replayInput == "" {
+		log.Fatalf("the replay subcommand requires -replay.input")
+	}
+
+	readFrom := *procfsPath
+	if *replayInput != "" {
+		readFrom = *replayInput
+	}
+
+	var (
+		matchnamer   common.MatchNamer
+		metrics      collector.MetricFilter
+		threadPolicy proc.ThreadPolicy
+		childPolicy  proc.ChildrenPolicy
+		topK         proc.TopKPolicy
+		catchAll     string
+		catchAllTopK int
+	)
 
 	if *configPath != "" {
 		if *nameMapping != "" || *procNames != "" {
@@ -203,8 +368,17 @@ func main() {
 		if err != nil {
 			log.Fatalf("error reading config file %q: %v", *configPath, err)
 		}
-		log.Printf("Reading metrics from %s based on %q", *procfsPath, *configPath)
+		if !topMode && !replayMode {
+			log.Printf("Reading metrics from %s based on %q", readFrom, *configPath)
+		}
 		matchnamer = cfg.MatchNamers
+		metrics = cfg
+		threadPolicy = cfg
+		childPolicy = cfg
+		topK = cfg
+		if cfg.Unmatched != nil {
+			catchAll, catchAllTopK = cfg.Unmatched.Name, cfg.Unmatched.TopK
+		}
 		if *debug {
 			log.Printf("using config matchnamer: %v", cfg.MatchNamers)
 		}
@@ -224,22 +398,114 @@ func main() {
 			}
 		}
 
-		log.Printf("Reading metrics from %s for procnames: %v", *procfsPath, names)
+		if !topMode && !replayMode {
+			log.Printf("Reading metrics from %s for procnames: %v", readFrom, names)
+		}
 		if *debug {
 			log.Printf("using cmdline matchnamer: %v", namemapper)
 		}
 		matchnamer = namemapper
 	}
 
+	if topMode || replayMode {
+		opts := topOptions{
+			namer:          matchnamer,
+			childrenPolicy: childPolicy,
+			catchAll:       catchAll,
+			procfsPath:     *procfsPath,
+			children:       *children,
+			recheck:        *recheck,
+			debug:          *debug,
+			interval:       *topInterval,
+			sortBy:         *topSortBy,
+			iterations:     *topIterations,
+		}
+		if replayMode {
+			if err := runReplay(*replayInput, opts); err != nil {
+				log.Fatalf("Error replaying snapshots: %v", err)
+			}
+			return
+		}
+		if err := runTop(opts); err != nil {
+			log.Fatalf("Error running top: %v", err)
+		}
+		return
+	}
+
+	if *dryRun {
+		if err := checkConfig(os.Stdout, matchnamer, childPolicy, catchAll, *procfsPath, *children, *debug); err != nil {
+			log.Fatalf("Error checking config: %v", err)
+		}
+		return
+	}
+
+	var sinks []collector.Sink
+	if *statsdAddress != "" {
+		sink, err := push.NewStatsDSink(push.StatsDOption{
+			Address:   *statsdAddress,
+			Prefix:    *statsdPrefix,
+			DogStatsD: *statsdDogStatsD,
+		})
+		if err != nil {
+			log.Fatalf("Error initializing StatsD: %v", err)
+		}
+		log.Printf("Sending metrics to StatsD at %s every %s", *statsdAddress, *sinkInterval)
+		sinks = append(sinks, sink)
+	}
+	if *graphiteAddress != "" {
+		sink, err := push.NewGraphiteSink(push.GraphiteOption{
+			Address: *graphiteAddress,
+			Prefix:  *graphitePrefix,
+			Timeout: *sinkInterval,
+		})
+		if err != nil {
+			log.Fatalf("Error initializing Graphite: %v", err)
+		}
+		log.Printf("Sending metrics to Graphite at %s every %s", *graphiteAddress, *sinkInterval)
+		sinks = append(sinks, sink)
+	}
+
+	// With -replay.input the collector reads the recorded snapshots rather
+	// than the live procs.
+	var (
+		source proc.Source
+		replay *proc.Replay
+	)
+	if *replayInput != "" {
+		f, err := os.Open(*replayInput)
+		if err != nil {
+			log.Fatalf("Error opening %s: %v", *replayInput, err)
+		}
+		replay, err = proc.NewReplay(f, *debug)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", *replayInput, err)
+		}
+		defer replay.Close()
+		replay.GatherSMaps, replay.GatherNUMAMaps = *smaps, *numaMaps
+		source = replay
+	}
+
 	pc, err := collector.NewProcessCollector(
 		collector.ProcessCollectorOption{
-			ProcFSPath:  *procfsPath,
-			Children:    *children,
-			Threads:     *threads,
-			GatherSMaps: *smaps,
-			Namer:       matchnamer,
-			Recheck:     *recheck,
-			Debug:       *debug,
+			Source:         source,
+			ProcFSPath:     *procfsPath,
+			Children:       *children,
+			Threads:        *threads,
+			GatherSMaps:    *smaps,
+			SysFSPath:      *sysfsPath,
+			NUMAMaps:       *numaMaps,
+			Namer:          matchnamer,
+			Recheck:        *recheck,
+			Debug:          *debug,
+			Metrics:        metrics,
+			ThreadPolicy:   threadPolicy,
+			ChildrenPolicy: childPolicy,
+			TopK:           topK,
+			CatchAll:       catchAll,
+			CatchAllTopK:   catchAllTopK,
+			Sinks:          sinks,
+			SinkInterval:   *sinkInterval,
 		},
 	)
 	if err != nil {
@@ -248,18 +514,114 @@ func main() {
 
 	prometheus.MustRegister(pc)
 
-	if *onceToStdoutDelay != 0 {
-		// We throw away the first result because that first collection primes the pump, and
-		// otherwise we won't see our counter metrics.  This is specific to the implementation
-		// of NamedProcessCollector.Collect().
+	if *onceToStdoutDelay != 0 || replay != nil {
 		fscraper := fakescraper.NewFakeScraper()
-		fscraper.Scrape()
-		time.Sleep(*onceToStdoutDelay)
+		if replay != nil {
+			// The collector read the first snapshot when created; every
+			// other one but the last is read to get the counters to where
+			// they were when it was taken.
+			if replay.Len() < 2 {
+				log.Fatalf("%s holds a single snapshot, need at least two", *replayInput)
+			}
+			for i := 1; i < replay.Len()-1; i++ {
+				if _, err := pc.Snapshot(); err != nil {
+					log.Fatalf("Error replaying snapshot %d: %v", i+1, err)
+				}
+			}
+		} else {
+			// We throw away the first result because that first collection primes the pump, and
+			// otherwise we won't see our counter metrics.  This is specific to the implementation
+			// of NamedProcessCollector.Collect().
+			fscraper.Scrape()
+			time.Sleep(*onceToStdoutDelay)
+		}
+		if *onceToStdoutTree != "" {
+			fscraper.Scrape()
+			if err := pc.WriteTree(os.Stdout, *onceToStdoutTree); err != nil {
+				log.Fatalf("error writing tree: %v", err)
+			}
+			return
+		}
+		if *onceToStdoutFormat != "prometheus" {
+			if err := pc.WriteSnapshot(os.Stdout, *onceToStdoutFormat); err != nil {
+				log.Fatalf("error writing metrics: %v", err)
+			}
+			return
+		}
 		fmt.Print(fscraper.Scrape())
 		return
 	}
 
+	if *pushURL != "" {
+		// -push.batch-size has a default for remote-write, which doesn't
+		// apply to the Pushgateway, so it's only passed on if given.
+		batchSize := *pushBatchSize
+		if *pushMode == push.ModePushgateway {
+			batchSize = 0
+			flag.Visit(func(f *flag.Flag) {
+				if f.Name == "push.batch-size" {
+					batchSize = *pushBatchSize
+				}
+			})
+		}
+		pusher, err := push.NewPusher(push.PusherOption{
+			URL:            *pushURL,
+			Mode:           *pushMode,
+			Job:            *pushJob,
+			Instance:       *pushInstance,
+			Interval:       *pushInterval,
+			Timeout:        *pushTimeout,
+			Retries:        *pushRetries,
+			RetryBackoff:   time.Second,
+			BatchSize:      batchSize,
+			BufferDir:      *pushBufferDir,
+			BufferMaxBytes: *pushBufferMaxBytes,
+		}, prometheus.DefaultGatherer)
+		if err != nil {
+			log.Fatalf("Error initializing push: %v", err)
+		}
+		log.Printf("Pushing metrics to %s every %s", *pushURL, *pushInterval)
+		go pusher.Run(context.Background())
+	}
+
+	if *otlpEndpoint != "" {
+		headers := make(map[string]string)
+		for _, kv := range strings.Split(*otlpHeaders, ",") {
+			if kv == "" {
+				continue
+			}
+			toks := strings.SplitN(kv, "=", 2)
+			if len(toks) != 2 {
+				log.Fatalf("bad -otlp.headers entry %q: want key=value", kv)
+			}
+			headers[strings.TrimSpace(toks[0])] = strings.TrimSpace(toks[1])
+		}
+		exporter, err := push.NewOTLPExporter(push.OTLPOption{
+			Endpoint:     *otlpEndpoint,
+			Headers:      headers,
+			Interval:     *otlpInterval,
+			Timeout:      *otlpTimeout,
+			Retries:      *pushRetries,
+			RetryBackoff: time.Second,
+			ServiceName:  "process-exporter",
+		}, pc)
+		if err != nil {
+			log.Fatalf("Error initializing OTLP export: %v", err)
+		}
+		log.Printf("Exporting metrics to %s every %s", *otlpEndpoint, *otlpInterval)
+		go exporter.Run(context.Background())
+	}
+
+	if *listenAddress == "" {
+		if *pushURL == "" && *otlpEndpoint == "" && len(sinks) == 0 {
+			log.Fatalf("-web.listen-address is empty but none of -push.url, -otlp.endpoint, -statsd.address or -graphite.address is set")
+		}
+		select {}
+	}
+
 	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle("/debug/tracked", pc.TrackedHandler())
+	http.Handle("/debug/tree", pc.TreeHandler())
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
@@ -267,6 +629,8 @@ func main() {
 			<body>
 			<h1>Named Process Exporter</h1>
 			<p><a href="` + *metricsPath + `">Metrics</a></p>
+			<p><a href="/debug/tracked">Tracked processes</a></p>
+			<p><a href="/debug/tree">Process tree</a></p>
 			</body>
 			</html>`))
 	})
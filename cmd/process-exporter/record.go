@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+// runRecord archives a snapshot of the procfs mounted under procfsPath to
+// the file output every interval, until count snapshots are taken or the
+// process is interrupted.  A count of 0 means no limit.
+func runRecord(procfsPath, output string, interval time.Duration, count int, smaps, numaMaps bool) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	rec := proc.NewRecorder(f, procfsPath)
+	rec.GatherSMaps = smaps
+	rec.GatherNUMAMaps = numaMaps
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+record:
+	for i := 0; count == 0 || i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-interrupt:
+				break record
+			}
+		}
+		if err = rec.Snapshot(); err != nil {
+			break
+		}
+		log.Printf("Recorded snapshot %d to %s", i+1, output)
+	}
+
+	if cerr := rec.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runReplay replays the snapshots archived by runRecord in the file input,
+// grouping procs as configured by opts, and writes the top table for each
+// snapshot but the first, which only serves as a baseline for rates.  The
+// procfsPath, interval and iterations of opts are ignored.
+func runReplay(input string, opts topOptions) error {
+	ts, ok := findTopSort(opts.sortBy)
+	if !ok {
+		return fmt.Errorf("unknown sort column %q", opts.sortBy)
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rp, err := proc.NewReplay(f, opts.debug)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", input, err)
+	}
+	defer rp.Close()
+
+	grouper := opts.grouper()
+	var (
+		prev proc.GroupByName
+		last time.Time
+	)
+	for i := 0; i < rp.Len(); i++ {
+		_, cur, err := grouper.Update(rp.AllProcs())
+		if err != nil {
+			return fmt.Errorf("error replaying snapshot %d: %v", i+1, err)
+		}
+		now := rp.Time()
+		if i > 0 {
+			fmt.Printf("%s  snapshot %d/%d  sorted by %s\n\n",
+				now.Format(time.RFC3339), i+1, rp.Len(), ts.name)
+			if err := writeTop(os.Stdout, topRows(prev, cur, now.Sub(last)), ts, 0); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+		prev, last = cur, now
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// isTerminal returns true if fd is a terminal.  It's only implemented on
+// Linux.
+func isTerminal(fd int) bool {
+	return false
+}
+
+// rawTerminal isn't implemented outside Linux.
+func rawTerminal(fd int) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode not supported on this platform")
+}
+
+// terminalRows returns 0, i.e. unknown, outside Linux.
+func terminalRows(fd int) int {
+	return 0
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	common "github.com/ncabatoff/process-exporter"
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+type (
+	// topOptions configures runTop.
+	topOptions struct {
+		namer          common.MatchNamer
+		childrenPolicy proc.ChildrenPolicy
+		catchAll       string
+		procfsPath     string
+		children       bool
+		recheck        bool
+		debug          bool
+		// interval is the time between refreshes.
+		interval time.Duration
+		// sortBy is the initial sort column, one of the topSorts names.
+		sortBy string
+		// iterations is the number of refreshes after which to exit, 0 for
+		// no limit.
+		iterations int
+	}
+
+	// topRow is a line of the top table: the usage of a group since the
+	// previous update.
+	topRow struct {
+		name    string
+		procs   int
+		threads uint64
+		// cpu is the CPU time (user+system) used, in percent of one CPU.
+		cpu float64
+		rss uint64
+		// read and write are the bytes read and written per second.
+		read, write float64
+		fds         uint64
+	}
+
+	// topSort is a column of the top table rows may be sorted on.
+	topSort struct {
+		name string
+		// key is the key to press to sort on this column.
+		key byte
+		// less orders rows, the busiest first.
+		less func(a, b topRow) bool
+	}
+)
+
+// topSorts lists the columns the top table may be sorted on.
+var topSorts = []topSort{
+	{"cpu", 'c', func(a, b topRow) bool { return a.cpu > b.cpu }},
+	{"rss", 'm', func(a, b topRow) bool { return a.rss > b.rss }},
+	{"read", 'r', func(a, b topRow) bool { return a.read > b.read }},
+	{"write", 'w', func(a, b topRow) bool { return a.write > b.write }},
+	{"fds", 'f', func(a, b topRow) bool { return a.fds > b.fds }},
+	{"threads", 't', func(a, b topRow) bool { return a.threads > b.threads }},
+	{"procs", 'p', func(a, b topRow) bool { return a.procs > b.procs }},
+	{"name", 'n', func(a, b topRow) bool { return a.name < b.name }},
+}
+
+// findTopSort returns the column named name, or false if there isn't one.
+func findTopSort(name string) (topSort, bool) {
+	for _, ts := range topSorts {
+		if ts.name == name {
+			return ts, true
+		}
+	}
+	return topSort{}, false
+}
+
+// topRows computes the usage of each group with running procs between two
+// successive updates elapsed apart.
+func topRows(prev, cur proc.GroupByName, elapsed time.Duration) []topRow {
+	secs := elapsed.Seconds()
+	var rows []topRow
+	for gname, g := range cur {
+		if g.Procs == 0 {
+			continue
+		}
+		row := topRow{
+			name:    gname,
+			procs:   g.Procs,
+			threads: g.NumThreads,
+			rss:     g.ResidentBytes,
+			fds:     g.OpenFDs,
+		}
+		if p, ok := prev[gname]; ok && secs > 0 {
+			row.cpu = 100 * (g.CPUUserTime + g.CPUSystemTime - p.CPUUserTime - p.CPUSystemTime) / secs
+			row.read = float64(g.ReadBytes-p.ReadBytes) / secs
+			row.write = float64(g.WriteBytes-p.WriteBytes) / secs
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// writeTop writes rows to w as a table, sorted by ts, keeping at most
+// maxRows rows if maxRows is positive.
+func writeTop(w io.Writer, rows []topRow, ts topSort, maxRows int) error {
+	sort.Slice(rows, func(i, j int) bool {
+		if ts.less(rows[i], rows[j]) != ts.less(rows[j], rows[i]) {
+			return ts.less(rows[i], rows[j])
+		}
+		return rows[i].name < rows[j].name
+	})
+	if maxRows > 0 && len(rows) > maxRows {
+		rows = rows[:maxRows]
+	}
+
+	// The group name is last and not followed by a tab, so it isn't
+	// right-aligned like the numbers.
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "PROCS\tTHREADS\tCPU%\tRSS\tREAD/s\tWRITE/s\tFDS\t  GROUP")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%d\t%d\t%.1f\t%s\t%s\t%s\t%d\t  %s\n", r.procs, r.threads, r.cpu,
+			humanBytes(r.rss), humanBytes(uint64(r.read)), humanBytes(uint64(r.write)), r.fds, r.name)
+	}
+	return tw.Flush()
+}
+
+// humanBytes formats n bytes using binary unit prefixes, e.g. "1.5M".
+func humanBytes(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%c", v, units[i])
+}
+
+// readKeys sends the bytes read from r to keys until reading fails or done
+// is closed.
+func readKeys(r io.Reader, keys chan<- byte, done <-chan struct{}) {
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		if n == 1 {
+			select {
+			case keys <- buf[0]:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// grouper returns a Grouper naming procs as configured by opts.
+func (opts topOptions) grouper() *proc.Grouper {
+	grouper := proc.NewGrouper(opts.namer, opts.children, false, opts.recheck, opts.debug)
+	if opts.childrenPolicy != nil {
+		grouper.SetChildrenPolicy(opts.childrenPolicy)
+	}
+	if opts.catchAll != "" {
+		grouper.SetCatchAll(opts.catchAll, 0)
+	}
+	return grouper
+}
+
+// runTop shows a table of the groups of procs under opts.procfsPath and
+// their usage, refreshed every opts.interval, until q is pressed, the process
+// is interrupted or opts.iterations refreshes are done.  If stdout isn't a
+// terminal the tables are written one after the other, as by top -b.
+func runTop(opts topOptions) error {
+	ts, ok := findTopSort(opts.sortBy)
+	if !ok {
+		return fmt.Errorf("unknown sort column %q", opts.sortBy)
+	}
+
+	fs, err := proc.NewFS(opts.procfsPath, opts.debug)
+	if err != nil {
+		return err
+	}
+	fs.GatherWchan = false
+	grouper := opts.grouper()
+
+	_, prev, err := grouper.Update(fs.AllProcs())
+	if err != nil {
+		return err
+	}
+	last := time.Now()
+
+	interactive := isTerminal(int(os.Stdout.Fd()))
+	// The key reader stops once done is closed, rather than blocking on
+	// keys forever after runTop returns.
+	keys, done := make(chan byte, 16), make(chan struct{})
+	defer close(done)
+	if interactive {
+		if restore, err := rawTerminal(int(os.Stdin.Fd())); err == nil {
+			defer restore()
+			go readKeys(os.Stdin, keys, done)
+		}
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	var keyHelp []string
+	for _, s := range topSorts {
+		keyHelp = append(keyHelp, fmt.Sprintf("%c=%s", s.key, s.name))
+	}
+
+	var rows []topRow
+	render := func() error {
+		maxRows := 0
+		if interactive {
+			// Clear the screen, and leave room for the header lines.
+			fmt.Print("\x1b[H\x1b[2J")
+			if h := terminalRows(int(os.Stdout.Fd())); h > 4 {
+				maxRows = h - 4
+			}
+		}
+		fmt.Printf("%s  every %s  sorted by %s  (sort: %s, q=quit)\n\n",
+			time.Now().Format("15:04:05"), opts.interval, ts.name, strings.Join(keyHelp, " "))
+		err := writeTop(os.Stdout, rows, ts, maxRows)
+		if !interactive {
+			fmt.Println()
+		}
+		return err
+	}
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	for i := 0; opts.iterations == 0 || i < opts.iterations; {
+		select {
+		case <-ticker.C:
+			_, cur, err := grouper.Update(fs.AllProcs())
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			rows = topRows(prev, cur, now.Sub(last))
+			prev, last = cur, now
+			i++
+		case key := <-keys:
+			if key == 'q' {
+				return nil
+			}
+			for _, s := range topSorts {
+				if s.key == key {
+					ts = s
+				}
+			}
+			if rows == nil {
+				continue
+			}
+		case <-interrupt:
+			return nil
+		}
+		if err := render(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
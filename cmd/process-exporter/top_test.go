@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+func TestTopRows(t *testing.T) {
+	prev := proc.GroupByName{
+		"g1": proc.Group{Counts: proc.Counts{CPUUserTime: 1, CPUSystemTime: 1, ReadBytes: 1000}, Procs: 1},
+	}
+	cur := proc.GroupByName{
+		"g1": proc.Group{Counts: proc.Counts{CPUUserTime: 2, CPUSystemTime: 1.5, ReadBytes: 5000, WriteBytes: 10},
+			Procs: 2, NumThreads: 3, OpenFDs: 4, Memory: proc.Memory{ResidentBytes: 2048}},
+		// New groups have no rates yet, and groups without procs are left out.
+		"g2": proc.Group{Counts: proc.Counts{CPUUserTime: 5}, Procs: 1},
+		"g3": proc.Group{Counts: proc.Counts{CPUUserTime: 5}},
+	}
+	got := topRows(prev, cur, 2*time.Second)
+	want := []topRow{
+		{name: "g1", procs: 2, threads: 3, cpu: 75, rss: 2048, read: 2000, write: 5, fds: 4},
+		{name: "g2", procs: 1},
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].name < got[j].name })
+	if diff := cmp.Diff(got, want, cmp.AllowUnexported(topRow{})); diff != "" {
+		t.Errorf("rows differ: (-got +want)\n%s", diff)
+	}
+}
+
+func TestWriteTop(t *testing.T) {
+	rows := []topRow{
+		{name: "idle", procs: 1, threads: 1, rss: 512, fds: 3},
+		{name: "db", procs: 4, threads: 40, cpu: 150, rss: 3 << 30, read: 1536, write: 10 << 20, fds: 200},
+		{name: "web", procs: 2, threads: 8, cpu: 12.5, rss: 300 << 20, fds: 20},
+		{name: "cron", procs: 1, threads: 1, rss: 1024, fds: 3},
+	}
+
+	for _, tc := range []struct {
+		sortBy  string
+		maxRows int
+		want    []string
+	}{
+		// Ties are broken by name.
+		{"cpu", 0, []string{"db", "web", "cron", "idle"}},
+		{"rss", 2, []string{"db", "web"}},
+		{"fds", 0, []string{"db", "web", "cron", "idle"}},
+		{"name", 3, []string{"cron", "db", "idle"}},
+	} {
+		ts, ok := findTopSort(tc.sortBy)
+		if !ok {
+			t.Fatalf("no sort column %q", tc.sortBy)
+		}
+		var buf bytes.Buffer
+		noerr(t, writeTop(&buf, append([]topRow(nil), rows...), ts, tc.maxRows))
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		var got []string
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			got = append(got, fields[len(fields)-1])
+		}
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("%s: groups differ: (-got +want)\n%s", tc.sortBy, diff)
+		}
+	}
+
+	ts, _ := findTopSort("cpu")
+	var buf bytes.Buffer
+	noerr(t, writeTop(&buf, rows[1:3], ts, 0))
+	want := `  PROCS  THREADS   CPU%     RSS  READ/s  WRITE/s  FDS  GROUP
+      4       40  150.0    3.0G    1.5K    10.0M  200  db
+      2        8   12.5  300.0M      0B       0B   20  web
+`
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("table differs: (-got +want)\n%s", diff)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for n, want := range map[uint64]string{
+		0:         "0B",
+		1023:      "1023B",
+		1024:      "1.0K",
+		1536:      "1.5K",
+		5 << 20:   "5.0M",
+		1 << 60:   "1.0E",
+		1<<63 + 1: "8.0E",
+	} {
+		if got := humanBytes(n); got != want {
+			t.Errorf("humanBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+// TestReadKeys verifies that the key reader stops once done is closed, even
+// when no one receives its keys.
+func TestReadKeys(t *testing.T) {
+	keys, done := make(chan byte), make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		readKeys(strings.NewReader("cq"), keys, done)
+		close(stopped)
+	}()
+	if key := <-keys; key != 'c' {
+		t.Errorf("got key %q, want 'c'", key)
+	}
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("key reader didn't stop")
+	}
+}
+
+func noerr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+}
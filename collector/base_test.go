@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	common "github.com/ncabatoff/process-exporter"
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+// procSource is a proc.Source returning copies of procs, which tests may
+// change between reads.
+type procSource struct {
+	sync.Mutex
+	procs []proc.IDInfo
+}
+
+func (s *procSource) AllProcs() proc.Iter {
+	s.Lock()
+	defer s.Unlock()
+	return proc.NewIDInfoIter(append([]proc.IDInfo(nil), s.procs...)...)
+}
+
+func (s *procSource) set(procs ...proc.IDInfo) {
+	s.Lock()
+	defer s.Unlock()
+	s.procs = procs
+}
+
+// newProc returns a proc named name, started long ago, that used cpu seconds
+// of user CPU time.
+func newProc(pid, ppid int, name string, cpu float64) proc.IDInfo {
+	return proc.IDInfo{
+		ID: proc.ID{Pid: pid, StartTimeRel: uint64(pid)},
+		Static: proc.Static{
+			Name:      name,
+			Cmdline:   []string{name},
+			ParentPid: ppid,
+			StartTime: time.Unix(int64(pid), 0).UTC(),
+		},
+		Metrics: proc.Metrics{
+			Counts:   proc.Counts{CPUUserTime: cpu},
+			Filedesc: proc.Filedesc{Open: 4, Limit: 16},
+		},
+	}
+}
+
+// namer names procs whose comm is one of its keys after their comm.
+type namer map[string]struct{}
+
+func newNamer(names ...string) namer {
+	nr := make(namer, len(names))
+	for _, name := range names {
+		nr[name] = struct{}{}
+	}
+	return nr
+}
+
+func (n namer) String() string {
+	return fmt.Sprintf("%d names", len(n))
+}
+
+func (n namer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
+	if _, ok := n[nacl.Name]; ok {
+		return true, nacl.Name
+	}
+	return false, ""
+}
+
+func noerr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+type (
+	// trackedProcJSON is the JSON representation of a tracked proc.
+	trackedProcJSON struct {
+		Pid          int       `json:"pid"`
+		StartTimeRel uint64    `json:"start_time_rel"`
+		Name         string    `json:"name"`
+		Cmdline      []string  `json:"cmdline"`
+		Cgroups      []string  `json:"cgroups"`
+		ParentPid    int       `json:"parent_pid"`
+		StartTime    time.Time `json:"start_time"`
+		EffectiveUID int       `json:"effective_uid"`
+		GroupName    string    `json:"groupname"`
+		// Match is "direct" if the namer matched the proc, "ancestry" if
+		// it was tracked because of a tracked parent, or "unmatched" if it's
+		// in the catch-all group.
+		Match         string `json:"match"`
+		InheritedFrom int    `json:"inherited_from,omitempty"`
+	}
+
+	// trackedState is the response to a /debug/tracked request.
+	trackedState struct {
+		Tracked []trackedProcJSON `json:"tracked"`
+		Ignored []int             `json:"ignored"`
+	}
+)
+
+// trackedState must only be called from the collector goroutine.
+func (p *NamedProcessCollector) trackedState() trackedState {
+	state := trackedState{Tracked: []trackedProcJSON{}, Ignored: []int{}}
+	for _, tp := range p.Tracked() {
+		match := "direct"
+		if tp.InheritedFrom != 0 {
+			match = "ancestry"
+		} else if tp.Unmatched {
+			match = "unmatched"
+		}
+		state.Tracked = append(state.Tracked, trackedProcJSON{
+			Pid:           tp.Pid,
+			StartTimeRel:  tp.StartTimeRel,
+			Name:          tp.Name,
+			Cmdline:       tp.Cmdline,
+			Cgroups:       tp.Cgroups,
+			ParentPid:     tp.ParentPid,
+			StartTime:     tp.StartTime,
+			EffectiveUID:  tp.EffectiveUID,
+			GroupName:     tp.GroupName,
+			Match:         match,
+			InheritedFrom: tp.InheritedFrom,
+		})
+	}
+	for _, id := range p.Ignored() {
+		state.Ignored = append(state.Ignored, id.Pid)
+	}
+	sort.Slice(state.Tracked, func(i, j int) bool { return state.Tracked[i].Pid < state.Tracked[j].Pid })
+	sort.Ints(state.Ignored)
+	return state
+}
+
+// TrackedHandler returns an http.Handler that reports, as JSON, the procs
+// currently tracked and ignored.  The state is read from the collector
+// goroutine, so it is consistent with the last scrape.
+func (p *NamedProcessCollector) TrackedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var state trackedState
+		if err := p.onCollector(func() { state = p.trackedState() }); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// TreeHandler returns an http.Handler that reports the tracked procs
+// arranged by parentage, with the resident memory and CPU time of each proc
+// and of its subtree.  The format query parameter selects "text" (the
+// default) or "json".
+func (p *NamedProcessCollector) TreeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "text"
+		}
+		switch format {
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+		}
+		if err := p.WriteTree(w, format); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// WriteTree writes to w the tracked procs arranged by parentage as of the
+// last scrape, in the given format, "text" or "json".
+func (p *NamedProcessCollector) WriteTree(w io.Writer, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown tree format %q, want text or json", format)
+	}
+
+	var tree []*proc.TreeNode
+	if err := p.onCollector(func() { tree = p.Tree() }); err != nil {
+		return err
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tree)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tCOMM\tGROUP\tRSS\tCPU\tTREE_RSS\tTREE_CPU")
+	var writeNodes func(nodes []*proc.TreeNode, depth int)
+	writeNodes = func(nodes []*proc.TreeNode, depth int) {
+		for _, n := range nodes {
+			fmt.Fprintf(tw, "%s%d\t%s\t%s\t%d\t%.2f\t%d\t%.2f\n",
+				strings.Repeat("  ", depth), n.Pid, n.Comm, n.GroupName,
+				n.ResidentBytes, n.CPUSeconds, n.TreeResidentBytes, n.TreeCPUSeconds)
+			writeNodes(n.Children, depth+1)
+		}
+	}
+	writeNodes(tree, 0)
+	return tw.Flush()
+}
+
+// onCollector runs f on the collector goroutine, so that it doesn't race
+// with scrapes, and waits for it to finish.  It returns ErrStopped without
+// running f if the collector is stopped.
+func (p *NamedProcessCollector) onCollector(f func()) error {
+	done := make(chan struct{})
+	select {
+	case p.debugChan <- func() {
+		f()
+		close(done)
+	}:
+	case <-p.done:
+		return ErrStopped
+	}
+	<-done
+	return nil
+}
@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTrackedHandler(t *testing.T) {
+	src := &procSource{}
+	src.set(
+		newProc(1, 0, "bash", 1),
+		newProc(2, 1, "cat", 1),
+		newProc(3, 0, "sshd", 1),
+	)
+	p, err := NewProcessCollector(ProcessCollectorOption{
+		Source:   src,
+		Namer:    newNamer("bash"),
+		Children: true,
+	})
+	noerr(t, err)
+
+	rec := httptest.NewRecorder()
+	p.TrackedHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/tracked", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", ct)
+	}
+	var state trackedState
+	noerr(t, json.Unmarshal(rec.Body.Bytes(), &state))
+
+	type tracked struct {
+		Pid           int
+		Name, Group   string
+		Match         string
+		InheritedFrom int
+	}
+	var got []tracked
+	for _, tp := range state.Tracked {
+		got = append(got, tracked{tp.Pid, tp.Name, tp.GroupName, tp.Match, tp.InheritedFrom})
+	}
+	want := []tracked{
+		{1, "bash", "bash", "direct", 0},
+		{2, "cat", "bash", "ancestry", 1},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("tracked procs differ: (-got +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(state.Ignored, []int{3}); diff != "" {
+		t.Errorf("ignored pids differ: (-got +want)\n%s", diff)
+	}
+}
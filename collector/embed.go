@@ -0,0 +1,42 @@
+// Package collector groups procs and reports the metrics of each group, as a
+// prometheus.Collector or directly for other uses.
+//
+// The API for embedding process grouping in other programs is New, which
+// accepts any proc.Source and common.MatchNamer through
+// ProcessCollectorOption; Snapshot and Groups, which read procs and return
+// the groups without going through Prometheus; StartTime, which their
+// counters count from; and the context given to New, which stops the
+// collector.  These are kept stable: new options are added as fields whose
+// zero value keeps the current behaviour.
+//
+// All reads of procs are serialized on a goroutine started by New, so the
+// methods of a NamedProcessCollector may be called concurrently.  Every read
+// updates the counters of the groups, which are cumulative since the
+// collector was created, whether it's made by a scrape, Snapshot or Groups.
+package collector
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+// ErrStopped is returned by methods reading procs once the context given to
+// New is done.
+var ErrStopped = errors.New("collector stopped")
+
+// Snapshot reads all procs, updating the groups as a scrape would, and
+// returns the groups.  The GroupByName is not used by the collector after
+// Snapshot returns.  Errors are counted in the scrape error metrics.
+func (p *NamedProcessCollector) Snapshot() (proc.GroupByName, error) {
+	groups, _, err := p.Groups()
+	return groups, err
+}
+
+// StartTime returns when New first read procs, which the cumulative counters
+// of all groups count from: a group first seen later is taken to have been
+// at zero until then.
+func (p *NamedProcessCollector) StartTime() time.Time {
+	return p.started
+}
@@ -0,0 +1,166 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ncabatoff/process-exporter/proc"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// countingSource is a procSource that counts how often procs are read.
+type countingSource struct {
+	procSource
+	reads int
+}
+
+func (s *countingSource) AllProcs() proc.Iter {
+	s.Lock()
+	s.reads++
+	s.Unlock()
+	return s.procSource.AllProcs()
+}
+
+func (s *countingSource) readCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.reads
+}
+
+// TestEmbed verifies that a collector reads its procs from a custom Source,
+// and that Snapshot and Groups return the groups with their counts
+// accumulated since it was created.
+func TestEmbed(t *testing.T) {
+	if _, err := New(context.Background(), ProcessCollectorOption{Source: &procSource{}}); err == nil {
+		t.Errorf("got no error without a namer")
+	}
+
+	src := &countingSource{}
+	src.set(newProc(1, 0, "bash", 1), newProc(2, 1, "bash", 2), newProc(3, 0, "sshd", 5))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := time.Now()
+	p, err := New(ctx, ProcessCollectorOption{Source: src, Namer: newNamer("bash")})
+	noerr(t, err)
+	if n := src.readCount(); n != 1 {
+		t.Errorf("got %d reads after New, want 1", n)
+	}
+	if start := p.StartTime(); start.Before(before) || start.After(time.Now()) {
+		t.Errorf("got start time %v, want when New was called", start)
+	}
+
+	type counts struct {
+		Procs int
+		CPU   float64
+	}
+	src.set(newProc(1, 0, "bash", 1.5), newProc(2, 1, "bash", 3), newProc(3, 0, "sshd", 6))
+	groups, err := p.Snapshot()
+	noerr(t, err)
+	got := map[string]counts{}
+	for gname, g := range groups {
+		got[gname] = counts{g.Procs, g.CPUUserTime}
+	}
+	if diff := cmp.Diff(got, map[string]counts{"bash": {2, 1.5}}); diff != "" {
+		t.Errorf("snapshot groups differ: (-got +want)\n%s", diff)
+	}
+
+	src.set(newProc(1, 0, "bash", 2), newProc(3, 0, "sshd", 6))
+	groups, attrs, err := p.Groups()
+	noerr(t, err)
+	if g := groups["bash"]; g.Procs != 1 || g.CPUUserTime != 2 {
+		t.Errorf("got group %+v, want 1 proc and 2s of user CPU", g)
+	}
+	if a := attrs["bash"]; a.ExecutableName != "bash" {
+		t.Errorf("got attributes %+v, want executable bash", a)
+	}
+	if n := src.readCount(); n != 3 {
+		t.Errorf("got %d reads, want 3", n)
+	}
+}
+
+// TestEmbedStop verifies that once the context given to New is done, the
+// collector goroutine exits, procs are no longer read, and methods reading
+// them return ErrStopped.
+func TestEmbedStop(t *testing.T) {
+	src := &countingSource{}
+	src.set(newProc(1, 0, "bash", 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := New(ctx, ProcessCollectorOption{Source: src, Namer: newNamer("bash")})
+	noerr(t, err)
+	_, err = p.Snapshot()
+	noerr(t, err)
+
+	cancel()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := p.Snapshot(); err == ErrStopped {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("got error %v after cancelling, want ErrStopped", err)
+		}
+	}
+	reads := src.readCount()
+
+	if _, _, err := p.Groups(); err != ErrStopped {
+		t.Errorf("Groups: got error %v, want ErrStopped", err)
+	}
+	if err := p.WriteSnapshot(&bytes.Buffer{}, "csv"); err != ErrStopped {
+		t.Errorf("WriteSnapshot: got error %v, want ErrStopped", err)
+	}
+	ch := make(chan prometheus.Metric, 1000)
+	p.Collect(ch)
+	if len(ch) != 0 {
+		t.Errorf("got %d metrics from Collect, want none", len(ch))
+	}
+	if n := src.readCount(); n != reads {
+		t.Errorf("got %d reads after stopping, want %d", n, reads)
+	}
+
+	// Only the collector goroutine receives functions to run.
+	select {
+	case p.debugChan <- func() {}:
+		t.Errorf("collector goroutine still running")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestStageDurations verifies that the ancestry stage is only observed when
+// children are tracked.
+func TestStageDurations(t *testing.T) {
+	for _, children := range []bool{false, true} {
+		src := &procSource{}
+		src.set(newProc(1, 0, "bash", 1), newProc(2, 1, "cat", 1))
+		ctx, cancel := context.WithCancel(context.Background())
+		p, err := New(ctx, ProcessCollectorOption{Source: src, Namer: newNamer("bash"), Children: children})
+		noerr(t, err)
+
+		ch := make(chan prometheus.Metric, 1000)
+		p.Collect(ch)
+		close(ch)
+		cancel()
+		var stages []string
+		for m := range ch {
+			if !strings.Contains(m.Desc().String(), "scrape_stage_duration_seconds") {
+				continue
+			}
+			var pb dto.Metric
+			noerr(t, m.Write(&pb))
+			for _, l := range pb.GetLabel() {
+				stages = append(stages, l.GetValue())
+			}
+		}
+		sort.Strings(stages)
+		want := []string{"emit", "threads", "update"}
+		if children {
+			want = []string{"ancestry", "emit", "threads", "update"}
+		}
+		if diff := cmp.Diff(stages, want); diff != "" {
+			t.Errorf("children=%t: stages differ: (-got +want)\n%s", children, diff)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package collector
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	common "github.com/ncabatoff/process-exporter"
 	"github.com/ncabatoff/process-exporter/proc"
@@ -33,6 +37,48 @@ var (
 		[]string{"groupname"},
 		nil)
 
+	readCharsDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_read_chars_total",
+		"number of bytes read by this group using read syscalls, including those served from the page cache",
+		[]string{"groupname"},
+		nil)
+
+	writeCharsDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_write_chars_total",
+		"number of bytes written by this group using write syscalls, including those never flushed to storage",
+		[]string{"groupname"},
+		nil)
+
+	readSyscallsDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_read_syscalls_total",
+		"number of read syscalls made by this group",
+		[]string{"groupname"},
+		nil)
+
+	writeSyscallsDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_write_syscalls_total",
+		"number of write syscalls made by this group",
+		[]string{"groupname"},
+		nil)
+
+	cancelledWriteBytesDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_cancelled_write_bytes_total",
+		"number of bytes this group caused not to be written to storage, e.g. by truncating dirty files",
+		[]string{"groupname"},
+		nil)
+
+	guestCPUSecsDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_guest_cpu_seconds_total",
+		"CPU seconds spent running virtual CPUs of guest operating systems, included in user CPU time",
+		[]string{"groupname"},
+		nil)
+
+	blkioDelayDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_blkio_delay_seconds_total",
+		"seconds spent waiting for block I/O to complete",
+		[]string{"groupname"},
+		nil)
+
 	majorPageFaultsDesc = prometheus.NewDesc(
 		"namedprocess_namegroup_major_page_faults_total",
 		"Major page faults",
@@ -83,7 +129,7 @@ var (
 
 	statesDesc = prometheus.NewDesc(
 		"namedprocess_namegroup_states",
-		"Number of processes in states Running, Sleeping, Waiting, Zombie, or Other",
+		"Number of threads in states Running, Sleeping, Waiting, Zombie, Stopped, Traced, Idle, Dead, Parked, or Other",
 		[]string{"groupname", "state"},
 		nil)
 
@@ -105,6 +151,138 @@ var (
 		nil,
 		nil)
 
+	trackerEntriesDesc = prometheus.NewDesc(
+		"namedprocess_tracker_entries",
+		"number of procs the tracker is remembering, by whether they're tracked or ignored",
+		[]string{"state"},
+		nil)
+
+	procsScannedDesc = prometheus.NewDesc(
+		"namedprocess_exporter_procs_scanned",
+		"number of procs scanned during the last scrape",
+		nil,
+		nil)
+
+	threadsScannedDesc = prometheus.NewDesc(
+		"namedprocess_exporter_threads_scanned",
+		"number of threads read during the last scrape",
+		nil,
+		nil)
+
+	procFileReadsDesc = prometheus.NewDesc(
+		"namedprocess_exporter_proc_file_reads_total",
+		"number of times each file under /proc/<pid> has been read",
+		[]string{"file"},
+		nil)
+
+	procExecsDesc = prometheus.NewDesc(
+		"namedprocess_exporter_proc_execs_total",
+		"number of known procs found to have exec'd another program, and so named again",
+		nil,
+		nil)
+
+	topCPUDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_topk_cpu_rate",
+		"CPU seconds per second (user+system) used since the previous update, by a scrape, snapshot or sink, by each of the busiest procs in this group",
+		[]string{"groupname", "pid", "cmdline"},
+		nil)
+
+	topResidentDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_topk_resident_memory_bytes",
+		"resident memory of each of the procs in this group using the most",
+		[]string{"groupname", "pid", "cmdline"},
+		nil)
+
+	unmatchedTopCPUDesc = prometheus.NewDesc(
+		"namedprocess_unmatched_topk_cpu_rate",
+		"CPU seconds per second (user+system) used since the previous update, by a scrape, snapshot or sink, by unmatched procs with each of the busiest comms",
+		[]string{"comm"},
+		nil)
+
+	unmatchedTopResidentDesc = prometheus.NewDesc(
+		"namedprocess_unmatched_topk_resident_memory_bytes",
+		"resident memory of unmatched procs with each of the comms using the most",
+		[]string{"comm"},
+		nil)
+
+	hostCPUSecsDesc = prometheus.NewDesc(
+		"namedprocess_host_cpu_seconds_total",
+		"CPU seconds spent by all CPUs of the host, from /proc/stat",
+		[]string{"mode"},
+		nil)
+
+	hostMemBytesDesc = prometheus.NewDesc(
+		"namedprocess_host_memory_bytes",
+		"memory of the host, from /proc/meminfo",
+		[]string{"memtype"},
+		nil)
+
+	coverageRatioDesc = prometheus.NewDesc(
+		"namedprocess_coverage_ratio",
+		"fraction of the host's process CPU time (user+nice+system) since the previous scrape, regardless of updates by snapshots or sinks, or of its memory in use (total-free), accounted for by named groups; capped at 1 since memory shared between procs is counted in each",
+		[]string{"resource"},
+		nil)
+
+	cpusAllowedDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_cpus_allowed",
+		"number of procs in this group allowed to run on the given list of CPUs",
+		[]string{"groupname", "cpus"},
+		nil)
+
+	memsAllowedDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_mems_allowed",
+		"number of procs in this group allowed to allocate memory on the given list of NUMA nodes",
+		[]string{"groupname", "nodes"},
+		nil)
+
+	threadsByCPUDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_threads_by_cpu",
+		"number of threads in this group that last ran on each CPU",
+		[]string{"groupname", "cpu"},
+		nil)
+
+	threadsByNodeDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_threads_by_numa_node",
+		"number of threads in this group that last ran on a CPU of each NUMA node",
+		[]string{"groupname", "node"},
+		nil)
+
+	numaMemBytesDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_numa_memory_bytes",
+		"memory mapped by this group on each NUMA node, from numa_maps",
+		[]string{"groupname", "node"},
+		nil)
+
+	threadsByPolicyDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_threads_by_policy",
+		"number of threads in this group with each scheduling policy",
+		[]string{"groupname", "policy"},
+		nil)
+
+	minNiceDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_min_nice",
+		"lowest nice value (highest priority) of the threads in this group",
+		[]string{"groupname"},
+		nil)
+
+	maxNiceDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_max_nice",
+		"highest nice value (lowest priority) of the threads in this group",
+		[]string{"groupname"},
+		nil)
+
+	unreapedChildrenDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_unreaped_children",
+		"number of zombie procs whose parent is in this group",
+		[]string{"groupname"},
+		nil)
+
+	orphanedProcsDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_orphaned_procs",
+		"number of procs in this group adopted by init or a subreaper after their tracked parent exited",
+		[]string{"groupname", "adopter"},
+		nil)
+
 	threadWchanDesc = prometheus.NewDesc(
 		"namedprocess_namegroup_threads_wchan",
 		"Number of threads in this group waiting on each wchan",
@@ -129,6 +307,18 @@ var (
 		[]string{"groupname", "threadname", "iomode"},
 		nil)
 
+	threadIoCharsDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_thread_io_chars_total",
+		"number of bytes read/written by these threads using read/write syscalls",
+		[]string{"groupname", "threadname", "iomode"},
+		nil)
+
+	threadSyscallsDesc = prometheus.NewDesc(
+		"namedprocess_namegroup_thread_syscalls_total",
+		"number of read/write syscalls made by these threads",
+		[]string{"groupname", "threadname", "iomode"},
+		nil)
+
 	threadMajorPageFaultsDesc = prometheus.NewDesc(
 		"namedprocess_namegroup_thread_major_page_faults_total",
 		"Major page faults for these threads",
@@ -148,52 +338,264 @@ var (
 		nil)
 )
 
+// metricFamilies are the names of the per-group metric families that may be
+// selected using a MetricFilter.
+var metricFamilies = map[string]bool{
+	"num_procs":                      true,
+	"cpu_seconds_total":              true,
+	"read_bytes_total":               true,
+	"write_bytes_total":              true,
+	"read_chars_total":               true,
+	"write_chars_total":              true,
+	"read_syscalls_total":            true,
+	"write_syscalls_total":           true,
+	"cancelled_write_bytes_total":    true,
+	"guest_cpu_seconds_total":        true,
+	"blkio_delay_seconds_total":      true,
+	"major_page_faults_total":        true,
+	"minor_page_faults_total":        true,
+	"context_switches_total":         true,
+	"memory_bytes":                   true,
+	"open_filedesc":                  true,
+	"worst_fd_ratio":                 true,
+	"oldest_start_time_seconds":      true,
+	"num_threads":                    true,
+	"states":                         true,
+	"threads_wchan":                  true,
+	"thread_count":                   true,
+	"thread_cpu_seconds_total":       true,
+	"thread_io_bytes_total":          true,
+	"thread_io_chars_total":          true,
+	"thread_syscalls_total":          true,
+	"thread_major_page_faults_total": true,
+	"thread_minor_page_faults_total": true,
+	"thread_context_switches_total":  true,
+	"cpus_allowed":                   true,
+	"mems_allowed":                   true,
+	"threads_by_cpu":                 true,
+	"threads_by_numa_node":           true,
+	"numa_memory_bytes":              true,
+	"threads_by_policy":              true,
+	"min_nice":                       true,
+	"max_nice":                       true,
+	"unreaped_children":              true,
+	"orphaned_procs":                 true,
+	"topk_cpu_rate":                  true,
+	"topk_resident_memory_bytes":     true,
+}
+
 type (
+	// MetricFilter selects which per-group metric families are emitted.
+	// Families are named by their metric name without the
+	// namedprocess_namegroup_ prefix, e.g. "cpu_seconds_total".
+	MetricFilter interface {
+		// MetricEnabled returns true if family should be emitted for groups
+		// of rule, as returned by proc.Grouper.GroupRule.
+		MetricEnabled(family string, rule int) bool
+		// MetricNeeded returns true if family may be emitted for any group.
+		MetricNeeded(family string) bool
+		// MetricFamilies returns the names of all the families referred to.
+		MetricFamilies() []string
+	}
+
 	scrapeRequest struct {
 		results chan<- prometheus.Metric
 		done    chan struct{}
 	}
 
+	// ProcessCollectorOption configures a NamedProcessCollector.
 	ProcessCollectorOption struct {
-		ProcFSPath  string
+		// ProcFSPath is where procs are read from, normally /proc, unless
+		// Source is set.
+		ProcFSPath string
+		// Source, if not nil, is where procs are read from instead of
+		// ProcFSPath.  If it's a *proc.FS, its Gather fields are set from
+		// these options and the metric families needed; other sources are
+		// expected to provide what they can.
+		Source      proc.Source
 		Children    bool
 		Threads     bool
 		GatherSMaps bool
-		Namer       common.MatchNamer
-		Recheck     bool
-		Debug       bool
+		// SysFSPath is where to read the NUMA topology from, normally /sys.
+		SysFSPath string
+		// NUMAMaps enables reading the memory of procs on each NUMA node.
+		NUMAMaps bool
+		// Namer assigns procs to groups.  It's required.
+		Namer   common.MatchNamer
+		Recheck bool
+		Debug   bool
+		// Metrics selects the metric families to emit.  If nil, all are.
+		Metrics MetricFilter
+		// ThreadPolicy, if not nil, controls thread reporting for each
+		// group, overriding Threads.
+		ThreadPolicy proc.ThreadPolicy
+		// ChildrenPolicy, if not nil, controls which descendants of the
+		// procs of each group are tracked with them, overriding Children.
+		ChildrenPolicy proc.ChildrenPolicy
+		// TopK, if not nil, selects the groups for which to report the
+		// busiest procs individually.
+		TopK proc.TopKPolicy
+		// CatchAll, if not empty, is the name of the group to account
+		// procs in that would otherwise be ignored.
+		CatchAll string
+		// CatchAllTopK is the number of comms among procs in the CatchAll
+		// group using the most resources to report.
+		CatchAllTopK int
+		// Sinks, if not empty, are sent the group metrics every
+		// SinkInterval.
+		Sinks        []Sink
+		SinkInterval time.Duration
+	}
+
+	// fileReadCounter is implemented by sources that count the files they read,
+	// e.g. proc.FS.
+	fileReadCounter interface {
+		FileReads() map[string]uint64
 	}
 
 	NamedProcessCollector struct {
 		scrapeChan chan scrapeRequest
+		// debugChan carries functions to run on the collector goroutine,
+		// e.g. for the debug handlers.
+		debugChan chan func()
 		*proc.Grouper
-		threads              bool
+		// stageDurations observes how long each stage of a scrape takes.
+		stageDurations       *prometheus.HistogramVec
 		smaps                bool
 		source               proc.Source
 		scrapeErrors         int
 		scrapeProcReadErrors int
 		scrapePartialErrors  int
-		debug                bool
+		procExecs            int
+		metrics              MetricFilter
+		catchAll             string
+		// lastHostCPU and lastGroupCPU are the host process CPU time and the
+		// sum of group CPU time as of the last scrape, used to compute CPU
+		// coverage; haveLastCPU is false until they're first set.  Unlike
+		// the topk rates, which cover the time since the previous update,
+		// they're only set by scrapes, since both are cumulative.
+		lastHostCPU  float64
+		lastGroupCPU float64
+		haveLastCPU  bool
+		sinks        []Sink
+		sinkInterval time.Duration
+		// sinkChan carries updates from the collector goroutine to the
+		// goroutine sending them to sinks.
+		sinkChan chan SinkUpdate
+		// lastSink is when sinks were last fed, and sinkLatest how much the
+		// counts of each group increased since; sinkLatest is nil without
+		// sinks.
+		lastSink   time.Time
+		sinkLatest map[string]proc.Delta
+		// started is when procs were first read.
+		started time.Time
+		// done is closed when the collector is stopped.
+		done  <-chan struct{}
+		debug bool
 	}
 )
 
+// NewProcessCollector returns a collector configured by options, which runs
+// until the process exits.
 func NewProcessCollector(options ProcessCollectorOption) (*NamedProcessCollector, error) {
-	fs, err := proc.NewFS(options.ProcFSPath, options.Debug)
-	if err != nil {
-		return nil, err
+	return New(context.Background(), options)
+}
+
+// New returns a collector configured by options.  Procs are read once
+// before it returns, to prime the counters.  The collector stops once ctx is
+// done: from then on Collect reports nothing, and methods reading procs
+// return ErrStopped.
+func New(ctx context.Context, options ProcessCollectorOption) (*NamedProcessCollector, error) {
+	if options.Namer == nil {
+		return nil, fmt.Errorf("no namer")
+	}
+	if options.Metrics != nil {
+		for _, family := range options.Metrics.MetricFamilies() {
+			if !metricFamilies[family] {
+				return nil, fmt.Errorf("unknown metric family %q", family)
+			}
+		}
+	}
+
+	source := options.Source
+	fs, _ := source.(*proc.FS)
+	if source == nil {
+		var err error
+		fs, err = proc.NewFS(options.ProcFSPath, options.Debug)
+		if err != nil {
+			return nil, err
+		}
+		source = fs
 	}
 
-	fs.GatherSMaps = options.GatherSMaps
 	p := &NamedProcessCollector{
 		scrapeChan: make(chan scrapeRequest),
-		Grouper:    proc.NewGrouper(options.Namer, options.Children, options.Threads, options.Recheck, options.Debug),
-		source:     fs,
-		threads:    options.Threads,
-		smaps:      options.GatherSMaps,
-		debug:      options.Debug,
+		debugChan:  make(chan func()),
+		stageDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "namedprocess_exporter_scrape_stage_duration_seconds",
+			Help:    "time spent in each stage of a scrape: update (reading procs), ancestry (resolving parents of new procs), threads (reading threads, part of update) and emit (producing metrics)",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"stage"}),
+		Grouper:  proc.NewGrouper(options.Namer, options.Children, options.Threads, options.Recheck, options.Debug),
+		source:   source,
+		smaps:    options.GatherSMaps,
+		metrics:  options.Metrics,
+		catchAll: options.CatchAll,
+		done:     ctx.Done(),
+		debug:    options.Debug,
+	}
+
+	// Avoid reading what we won't report.
+	numaMaps := options.NUMAMaps && p.needed("numa_memory_bytes")
+	if fs != nil {
+		fs.GatherSMaps = options.GatherSMaps && p.needed("memory_bytes")
+		fs.GatherFDs = p.needed("open_filedesc") || p.needed("worst_fd_ratio")
+		fs.GatherLimits = p.needed("worst_fd_ratio")
+		fs.GatherWchan = p.needed("threads_wchan")
+		threads := options.Threads || options.ThreadPolicy != nil
+		fs.GatherIO = p.needed("read_bytes_total") || p.needed("write_bytes_total") ||
+			p.needed("read_chars_total") || p.needed("write_chars_total") ||
+			p.needed("read_syscalls_total") || p.needed("write_syscalls_total") ||
+			p.needed("cancelled_write_bytes_total") ||
+			(threads && (p.needed("thread_io_bytes_total") ||
+				p.needed("thread_io_chars_total") || p.needed("thread_syscalls_total")))
+		fs.GatherNUMAMaps = numaMaps
 	}
 
-	colErrs, _, err := p.Update(p.source.AllProcs())
+	if options.ThreadPolicy != nil {
+		p.SetThreadPolicy(options.ThreadPolicy)
+	}
+	if options.ChildrenPolicy != nil {
+		p.SetChildrenPolicy(options.ChildrenPolicy)
+	}
+	if options.TopK != nil {
+		p.SetTopKPolicy(options.TopK)
+	}
+	if options.CatchAll != "" {
+		p.SetCatchAll(options.CatchAll, options.CatchAllTopK)
+	}
+	if p.needed("cpus_allowed") || p.needed("mems_allowed") || p.needed("threads_by_cpu") ||
+		p.needed("threads_by_numa_node") || numaMaps {
+		var cpuNodes map[int]int
+		if options.SysFSPath != "" && p.needed("threads_by_numa_node") {
+			var err error
+			cpuNodes, err = proc.ReadCPUNodes(options.SysFSPath)
+			if err != nil && options.Debug {
+				log.Printf("not reporting threads by NUMA node: %v", err)
+			}
+		}
+		p.SetPlacement(cpuNodes)
+	}
+	if p.needed("threads_by_policy") || p.needed("min_nice") || p.needed("max_nice") {
+		p.SetScheduling()
+	}
+
+	iter := p.source.AllProcs()
+	p.started = time.Now()
+	if ti, ok := iter.(proc.TimedIter); ok {
+		p.started = ti.Time()
+	}
+	colErrs, _, err := p.Update(iter)
 	if err != nil {
 		if options.Debug {
 			log.Print(err)
@@ -203,6 +605,16 @@ func NewProcessCollector(options ProcessCollectorOption) (*NamedProcessCollector
 	p.scrapePartialErrors += colErrs.Partial
 	p.scrapeProcReadErrors += colErrs.Read
 
+	if len(options.Sinks) > 0 {
+		if options.SinkInterval <= 0 {
+			return nil, fmt.Errorf("sink interval must be positive")
+		}
+		p.sinks, p.sinkInterval = options.Sinks, options.SinkInterval
+		p.sinkChan = make(chan SinkUpdate, 1)
+		p.lastSink, p.sinkLatest = time.Now(), make(map[string]proc.Delta)
+		go p.runSinks()
+	}
+
 	go p.start()
 
 	return p, nil
@@ -214,6 +626,23 @@ func (p *NamedProcessCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- numprocsDesc
 	ch <- readBytesDesc
 	ch <- writeBytesDesc
+	ch <- readCharsDesc
+	ch <- writeCharsDesc
+	ch <- readSyscallsDesc
+	ch <- writeSyscallsDesc
+	ch <- cancelledWriteBytesDesc
+	ch <- guestCPUSecsDesc
+	ch <- blkioDelayDesc
+	ch <- cpusAllowedDesc
+	ch <- memsAllowedDesc
+	ch <- threadsByCPUDesc
+	ch <- threadsByNodeDesc
+	ch <- numaMemBytesDesc
+	ch <- threadsByPolicyDesc
+	ch <- minNiceDesc
+	ch <- maxNiceDesc
+	ch <- unreapedChildrenDesc
+	ch <- orphanedProcsDesc
 	ch <- membytesDesc
 	ch <- openFDsDesc
 	ch <- worstFDRatioDesc
@@ -226,10 +655,25 @@ func (p *NamedProcessCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeErrorsDesc
 	ch <- scrapeProcReadErrorsDesc
 	ch <- scrapePartialErrorsDesc
+	ch <- trackerEntriesDesc
+	ch <- procsScannedDesc
+	ch <- threadsScannedDesc
+	ch <- procFileReadsDesc
+	ch <- procExecsDesc
+	p.stageDurations.Describe(ch)
+	ch <- topCPUDesc
+	ch <- topResidentDesc
+	ch <- unmatchedTopCPUDesc
+	ch <- unmatchedTopResidentDesc
+	ch <- hostCPUSecsDesc
+	ch <- hostMemBytesDesc
+	ch <- coverageRatioDesc
 	ch <- threadWchanDesc
 	ch <- threadCountDesc
 	ch <- threadCpuSecsDesc
 	ch <- threadIoBytesDesc
+	ch <- threadIoCharsDesc
+	ch <- threadSyscallsDesc
 	ch <- threadMajorPageFaultsDesc
 	ch <- threadMinorPageFaultsDesc
 	ch <- threadContextSwitchesDesc
@@ -238,112 +682,78 @@ func (p *NamedProcessCollector) Describe(ch chan<- *prometheus.Desc) {
 // Collect implements prometheus.Collector.
 func (p *NamedProcessCollector) Collect(ch chan<- prometheus.Metric) {
 	req := scrapeRequest{results: ch, done: make(chan struct{})}
-	p.scrapeChan <- req
+	select {
+	case p.scrapeChan <- req:
+	case <-p.done:
+		return
+	}
 	<-req.done
 }
 
 func (p *NamedProcessCollector) start() {
-	for req := range p.scrapeChan {
-		ch := req.results
-		p.scrape(ch)
-		req.done <- struct{}{}
+	var sinkTick <-chan time.Time
+	if len(p.sinks) > 0 {
+		ticker := time.NewTicker(p.sinkInterval)
+		defer ticker.Stop()
+		sinkTick = ticker.C
+	}
+	for {
+		select {
+		case req := <-p.scrapeChan:
+			ch := req.results
+			p.scrape(ch)
+			req.done <- struct{}{}
+		case f := <-p.debugChan:
+			f()
+		case <-sinkTick:
+			p.feedSinks()
+		case <-p.done:
+			if p.sinkChan != nil {
+				close(p.sinkChan)
+			}
+			return
+		}
 	}
 }
 
 func (p *NamedProcessCollector) scrape(ch chan<- prometheus.Metric) {
-	permErrs, groups, err := p.Update(p.source.AllProcs())
-	p.scrapePartialErrors += permErrs.Partial
+	_, groups, err := p.update()
+	stats := p.Stats()
+	p.stageDurations.WithLabelValues("update").Observe(stats.UpdateTime.Seconds())
+	if p.ChecksAncestry() {
+		p.stageDurations.WithLabelValues("ancestry").Observe(stats.AncestryTime.Seconds())
+	}
+	p.stageDurations.WithLabelValues("threads").Observe(stats.ThreadsTime.Seconds())
+
+	emitStart := time.Now()
 	if err != nil {
-		p.scrapeErrors++
 		log.Printf("error reading procs: %v", err)
 	} else {
+		unreaped, orphans := p.Unreaped(), p.Orphans()
 		for gname, gcounts := range groups {
-			ch <- prometheus.MustNewConstMetric(numprocsDesc,
-				prometheus.GaugeValue, float64(gcounts.Procs), gname)
-			ch <- prometheus.MustNewConstMetric(membytesDesc,
-				prometheus.GaugeValue, float64(gcounts.Memory.ResidentBytes), gname, "resident")
-			ch <- prometheus.MustNewConstMetric(membytesDesc,
-				prometheus.GaugeValue, float64(gcounts.Memory.VirtualBytes), gname, "virtual")
-			ch <- prometheus.MustNewConstMetric(membytesDesc,
-				prometheus.GaugeValue, float64(gcounts.Memory.VmSwapBytes), gname, "swapped")
-			ch <- prometheus.MustNewConstMetric(startTimeDesc,
-				prometheus.GaugeValue, float64(gcounts.OldestStartTime.Unix()), gname)
-			ch <- prometheus.MustNewConstMetric(openFDsDesc,
-				prometheus.GaugeValue, float64(gcounts.OpenFDs), gname)
-			ch <- prometheus.MustNewConstMetric(worstFDRatioDesc,
-				prometheus.GaugeValue, float64(gcounts.WorstFDratio), gname)
-			ch <- prometheus.MustNewConstMetric(cpuSecsDesc,
-				prometheus.CounterValue, gcounts.CPUUserTime, gname, "user")
-			ch <- prometheus.MustNewConstMetric(cpuSecsDesc,
-				prometheus.CounterValue, gcounts.CPUSystemTime, gname, "system")
-			ch <- prometheus.MustNewConstMetric(readBytesDesc,
-				prometheus.CounterValue, float64(gcounts.ReadBytes), gname)
-			ch <- prometheus.MustNewConstMetric(writeBytesDesc,
-				prometheus.CounterValue, float64(gcounts.WriteBytes), gname)
-			ch <- prometheus.MustNewConstMetric(majorPageFaultsDesc,
-				prometheus.CounterValue, float64(gcounts.MajorPageFaults), gname)
-			ch <- prometheus.MustNewConstMetric(minorPageFaultsDesc,
-				prometheus.CounterValue, float64(gcounts.MinorPageFaults), gname)
-			ch <- prometheus.MustNewConstMetric(contextSwitchesDesc,
-				prometheus.CounterValue, float64(gcounts.CtxSwitchVoluntary), gname, "voluntary")
-			ch <- prometheus.MustNewConstMetric(contextSwitchesDesc,
-				prometheus.CounterValue, float64(gcounts.CtxSwitchNonvoluntary), gname, "nonvoluntary")
-			ch <- prometheus.MustNewConstMetric(numThreadsDesc,
-				prometheus.GaugeValue, float64(gcounts.NumThreads), gname)
-			ch <- prometheus.MustNewConstMetric(statesDesc,
-				prometheus.GaugeValue, float64(gcounts.States.Running), gname, "Running")
-			ch <- prometheus.MustNewConstMetric(statesDesc,
-				prometheus.GaugeValue, float64(gcounts.States.Sleeping), gname, "Sleeping")
-			ch <- prometheus.MustNewConstMetric(statesDesc,
-				prometheus.GaugeValue, float64(gcounts.States.Waiting), gname, "Waiting")
-			ch <- prometheus.MustNewConstMetric(statesDesc,
-				prometheus.GaugeValue, float64(gcounts.States.Zombie), gname, "Zombie")
-			ch <- prometheus.MustNewConstMetric(statesDesc,
-				prometheus.GaugeValue, float64(gcounts.States.Other), gname, "Other")
-
-			for wchan, count := range gcounts.Wchans {
-				ch <- prometheus.MustNewConstMetric(threadWchanDesc,
-					prometheus.GaugeValue, float64(count), gname, wchan)
-			}
-
-			if p.smaps {
-				ch <- prometheus.MustNewConstMetric(membytesDesc,
-					prometheus.GaugeValue, float64(gcounts.Memory.ProportionalBytes), gname, "proportionalResident")
-				ch <- prometheus.MustNewConstMetric(membytesDesc,
-					prometheus.GaugeValue, float64(gcounts.Memory.ProportionalSwapBytes), gname, "proportionalSwapped")
-			}
-
-			if p.threads {
-				for _, thr := range gcounts.Threads {
-					ch <- prometheus.MustNewConstMetric(threadCountDesc,
-						prometheus.GaugeValue, float64(thr.NumThreads),
-						gname, thr.Name)
-					ch <- prometheus.MustNewConstMetric(threadCpuSecsDesc,
-						prometheus.CounterValue, float64(thr.CPUUserTime),
-						gname, thr.Name, "user")
-					ch <- prometheus.MustNewConstMetric(threadCpuSecsDesc,
-						prometheus.CounterValue, float64(thr.CPUSystemTime),
-						gname, thr.Name, "system")
-					ch <- prometheus.MustNewConstMetric(threadIoBytesDesc,
-						prometheus.CounterValue, float64(thr.ReadBytes),
-						gname, thr.Name, "read")
-					ch <- prometheus.MustNewConstMetric(threadIoBytesDesc,
-						prometheus.CounterValue, float64(thr.WriteBytes),
-						gname, thr.Name, "write")
-					ch <- prometheus.MustNewConstMetric(threadMajorPageFaultsDesc,
-						prometheus.CounterValue, float64(thr.MajorPageFaults),
-						gname, thr.Name)
-					ch <- prometheus.MustNewConstMetric(threadMinorPageFaultsDesc,
-						prometheus.CounterValue, float64(thr.MinorPageFaults),
-						gname, thr.Name)
-					ch <- prometheus.MustNewConstMetric(threadContextSwitchesDesc,
-						prometheus.CounterValue, float64(thr.CtxSwitchVoluntary),
-						gname, thr.Name, "voluntary")
-					ch <- prometheus.MustNewConstMetric(threadContextSwitchesDesc,
-						prometheus.CounterValue, float64(thr.CtxSwitchNonvoluntary),
-						gname, thr.Name, "nonvoluntary")
-				}
-			}
+			p.scrapeGroup(ch, gname, gcounts)
+			p.scrapeReaping(ch, gname, unreaped[gname], orphans[gname])
+		}
+		for gname, top := range p.TopProcs() {
+			p.scrapeTopProcs(ch, gname, top)
+		}
+		for gname, gp := range p.Placement() {
+			p.scrapePlacement(ch, gname, gp)
+		}
+		for gname, gs := range p.Scheduling() {
+			p.scrapeScheduling(ch, gname, gs)
+		}
+		top := p.TopUnmatched()
+		for _, tc := range top.CPU {
+			ch <- prometheus.MustNewConstMetric(unmatchedTopCPUDesc,
+				prometheus.GaugeValue, tc.Value, tc.Comm)
+		}
+		for _, tc := range top.Resident {
+			ch <- prometheus.MustNewConstMetric(unmatchedTopResidentDesc,
+				prometheus.GaugeValue, tc.Value, tc.Comm)
+		}
+		if hs, ok := p.source.(proc.HostStatter); ok {
+			p.scrapeHost(ch, hs, groups)
 		}
 	}
 	ch <- prometheus.MustNewConstMetric(scrapeErrorsDesc,
@@ -352,4 +762,377 @@ func (p *NamedProcessCollector) scrape(ch chan<- prometheus.Metric) {
 		prometheus.CounterValue, float64(p.scrapeProcReadErrors))
 	ch <- prometheus.MustNewConstMetric(scrapePartialErrorsDesc,
 		prometheus.CounterValue, float64(p.scrapePartialErrors))
+
+	tracked, ignored := p.NumEntries()
+	ch <- prometheus.MustNewConstMetric(trackerEntriesDesc,
+		prometheus.GaugeValue, float64(tracked), "tracked")
+	ch <- prometheus.MustNewConstMetric(trackerEntriesDesc,
+		prometheus.GaugeValue, float64(ignored), "ignored")
+
+	ch <- prometheus.MustNewConstMetric(procsScannedDesc,
+		prometheus.GaugeValue, float64(stats.Procs))
+	ch <- prometheus.MustNewConstMetric(threadsScannedDesc,
+		prometheus.GaugeValue, float64(stats.Threads))
+	ch <- prometheus.MustNewConstMetric(procExecsDesc,
+		prometheus.CounterValue, float64(p.procExecs))
+	if frc, ok := p.source.(fileReadCounter); ok {
+		for file, count := range frc.FileReads() {
+			ch <- prometheus.MustNewConstMetric(procFileReadsDesc,
+				prometheus.CounterValue, float64(count), file)
+		}
+	}
+
+	p.stageDurations.WithLabelValues("emit").Observe(time.Since(emitStart).Seconds())
+	p.stageDurations.Collect(ch)
+}
+
+// scrapeHost emits whole-host totals and the fraction of them accounted
+// for by groups, excluding the catch-all group if any.
+func (p *NamedProcessCollector) scrapeHost(ch chan<- prometheus.Metric, hs proc.HostStatter, groups proc.GroupByName) {
+	host, err := hs.HostStats()
+	if err != nil {
+		p.scrapeErrors++
+		log.Printf("error reading host stats: %v", err)
+		return
+	}
+
+	for _, mode := range []struct {
+		name  string
+		value float64
+	}{
+		{"user", host.CPU.User},
+		{"nice", host.CPU.Nice},
+		{"system", host.CPU.System},
+		{"idle", host.CPU.Idle},
+		{"iowait", host.CPU.Iowait},
+		{"irq", host.CPU.IRQ},
+		{"softirq", host.CPU.SoftIRQ},
+		{"steal", host.CPU.Steal},
+	} {
+		ch <- prometheus.MustNewConstMetric(hostCPUSecsDesc,
+			prometheus.CounterValue, mode.value, mode.name)
+	}
+	ch <- prometheus.MustNewConstMetric(hostMemBytesDesc,
+		prometheus.GaugeValue, float64(host.Memory.Total), "total")
+	ch <- prometheus.MustNewConstMetric(hostMemBytesDesc,
+		prometheus.GaugeValue, float64(host.Memory.Available), "available")
+	ch <- prometheus.MustNewConstMetric(hostMemBytesDesc,
+		prometheus.GaugeValue, float64(host.Memory.Free), "free")
+
+	var groupCPU float64
+	var groupResident uint64
+	for gname, gcounts := range groups {
+		if gname == p.catchAll {
+			continue
+		}
+		groupCPU += gcounts.CPUUserTime + gcounts.CPUSystemTime
+		groupResident += gcounts.Memory.ResidentBytes
+	}
+
+	hostCPU := host.CPU.ProcessTime()
+	// Group CPU time may go backwards if groups are removed when empty.
+	if p.haveLastCPU && hostCPU > p.lastHostCPU && groupCPU >= p.lastGroupCPU {
+		ch <- prometheus.MustNewConstMetric(coverageRatioDesc, prometheus.GaugeValue,
+			(groupCPU-p.lastGroupCPU)/(hostCPU-p.lastHostCPU), "cpu")
+	}
+	p.lastHostCPU, p.lastGroupCPU, p.haveLastCPU = hostCPU, groupCPU, true
+
+	if used := host.Memory.InUse(); used > 0 {
+		// Resident memory includes shared pages, counted in each proc
+		// mapping them, so the sum may exceed what's in use.
+		ratio := float64(groupResident) / float64(used)
+		if ratio > 1 {
+			ratio = 1
+		}
+		ch <- prometheus.MustNewConstMetric(coverageRatioDesc, prometheus.GaugeValue,
+			ratio, "memory")
+	}
+}
+
+// scrapeReaping emits the zombie children and orphans of group gname.
+func (p *NamedProcessCollector) scrapeReaping(ch chan<- prometheus.Metric, gname string, unreaped int, orphans proc.Orphans) {
+	if p.enabled("unreaped_children", gname) {
+		ch <- prometheus.MustNewConstMetric(unreapedChildrenDesc,
+			prometheus.GaugeValue, float64(unreaped), gname)
+	}
+	if p.enabled("orphaned_procs", gname) {
+		ch <- prometheus.MustNewConstMetric(orphanedProcsDesc,
+			prometheus.GaugeValue, float64(orphans.Init), gname, "init")
+		ch <- prometheus.MustNewConstMetric(orphanedProcsDesc,
+			prometheus.GaugeValue, float64(orphans.Subreaper), gname, "subreaper")
+	}
+}
+
+// scrapePlacement emits where the procs of group gname run and may run.
+func (p *NamedProcessCollector) scrapePlacement(ch chan<- prometheus.Metric, gname string, gp proc.GroupPlacement) {
+	if p.enabled("cpus_allowed", gname) {
+		for cpus, count := range gp.CPUsAllowed {
+			ch <- prometheus.MustNewConstMetric(cpusAllowedDesc,
+				prometheus.GaugeValue, float64(count), gname, cpus)
+		}
+	}
+	if p.enabled("mems_allowed", gname) {
+		for nodes, count := range gp.MemsAllowed {
+			ch <- prometheus.MustNewConstMetric(memsAllowedDesc,
+				prometheus.GaugeValue, float64(count), gname, nodes)
+		}
+	}
+	if p.enabled("threads_by_cpu", gname) {
+		for cpu, count := range gp.ThreadCPUs {
+			ch <- prometheus.MustNewConstMetric(threadsByCPUDesc,
+				prometheus.GaugeValue, float64(count), gname, strconv.Itoa(cpu))
+		}
+	}
+	if p.enabled("threads_by_numa_node", gname) {
+		for node, count := range gp.ThreadNodes {
+			ch <- prometheus.MustNewConstMetric(threadsByNodeDesc,
+				prometheus.GaugeValue, float64(count), gname, strconv.Itoa(node))
+		}
+	}
+	if p.enabled("numa_memory_bytes", gname) {
+		for node, bytes := range gp.NUMAMemory {
+			ch <- prometheus.MustNewConstMetric(numaMemBytesDesc,
+				prometheus.GaugeValue, float64(bytes), gname, strconv.Itoa(node))
+		}
+	}
+}
+
+// scrapeScheduling emits how the threads of group gname are scheduled.
+func (p *NamedProcessCollector) scrapeScheduling(ch chan<- prometheus.Metric, gname string, gs proc.GroupScheduling) {
+	if p.enabled("threads_by_policy", gname) {
+		for policy, count := range gs.ThreadPolicies {
+			ch <- prometheus.MustNewConstMetric(threadsByPolicyDesc,
+				prometheus.GaugeValue, float64(count), gname, proc.PolicyName(policy))
+		}
+	}
+	if p.enabled("min_nice", gname) {
+		ch <- prometheus.MustNewConstMetric(minNiceDesc,
+			prometheus.GaugeValue, float64(gs.MinNice), gname)
+	}
+	if p.enabled("max_nice", gname) {
+		ch <- prometheus.MustNewConstMetric(maxNiceDesc,
+			prometheus.GaugeValue, float64(gs.MaxNice), gname)
+	}
+}
+
+// enabled returns true if metric family should be emitted for group gname.
+func (p *NamedProcessCollector) enabled(family, gname string) bool {
+	return p.metrics == nil || p.metrics.MetricEnabled(family, p.GroupRule(gname))
+}
+
+// MetricEnabled returns true if metric family is emitted for group gname,
+// as selected by ProcessCollectorOption.Metrics, for exporters other than
+// Prometheus.  It returns false once the collector is stopped.
+func (p *NamedProcessCollector) MetricEnabled(family, gname string) bool {
+	var enabled bool
+	if err := p.onCollector(func() { enabled = p.enabled(family, gname) }); err != nil {
+		return false
+	}
+	return enabled
+}
+
+// needed returns true if metric family may be emitted for some group,
+// including the catch-all group, which no rule named.
+func (p *NamedProcessCollector) needed(family string) bool {
+	return p.metrics == nil || p.metrics.MetricNeeded(family) ||
+		p.catchAll != "" && p.metrics.MetricEnabled(family, common.NoRule)
+}
+
+func (p *NamedProcessCollector) scrapeGroup(ch chan<- prometheus.Metric, gname string, gcounts proc.Group) {
+	if p.enabled("num_procs", gname) {
+		ch <- prometheus.MustNewConstMetric(numprocsDesc,
+			prometheus.GaugeValue, float64(gcounts.Procs), gname)
+	}
+	if p.enabled("memory_bytes", gname) {
+		ch <- prometheus.MustNewConstMetric(membytesDesc,
+			prometheus.GaugeValue, float64(gcounts.Memory.ResidentBytes), gname, "resident")
+		ch <- prometheus.MustNewConstMetric(membytesDesc,
+			prometheus.GaugeValue, float64(gcounts.Memory.VirtualBytes), gname, "virtual")
+		ch <- prometheus.MustNewConstMetric(membytesDesc,
+			prometheus.GaugeValue, float64(gcounts.Memory.VmSwapBytes), gname, "swapped")
+		if p.smaps {
+			ch <- prometheus.MustNewConstMetric(membytesDesc,
+				prometheus.GaugeValue, float64(gcounts.Memory.ProportionalBytes), gname, "proportionalResident")
+			ch <- prometheus.MustNewConstMetric(membytesDesc,
+				prometheus.GaugeValue, float64(gcounts.Memory.ProportionalSwapBytes), gname, "proportionalSwapped")
+		}
+	}
+	if p.enabled("oldest_start_time_seconds", gname) {
+		ch <- prometheus.MustNewConstMetric(startTimeDesc,
+			prometheus.GaugeValue, float64(gcounts.OldestStartTime.Unix()), gname)
+	}
+	if p.enabled("open_filedesc", gname) {
+		ch <- prometheus.MustNewConstMetric(openFDsDesc,
+			prometheus.GaugeValue, float64(gcounts.OpenFDs), gname)
+	}
+	if p.enabled("worst_fd_ratio", gname) {
+		ch <- prometheus.MustNewConstMetric(worstFDRatioDesc,
+			prometheus.GaugeValue, float64(gcounts.WorstFDratio), gname)
+	}
+	if p.enabled("cpu_seconds_total", gname) {
+		ch <- prometheus.MustNewConstMetric(cpuSecsDesc,
+			prometheus.CounterValue, gcounts.CPUUserTime, gname, "user")
+		ch <- prometheus.MustNewConstMetric(cpuSecsDesc,
+			prometheus.CounterValue, gcounts.CPUSystemTime, gname, "system")
+		ch <- prometheus.MustNewConstMetric(cpuSecsDesc,
+			prometheus.CounterValue, gcounts.CPUChildrenUserTime, gname, "children_user")
+		ch <- prometheus.MustNewConstMetric(cpuSecsDesc,
+			prometheus.CounterValue, gcounts.CPUChildrenSystemTime, gname, "children_system")
+	}
+	if p.enabled("read_bytes_total", gname) {
+		ch <- prometheus.MustNewConstMetric(readBytesDesc,
+			prometheus.CounterValue, float64(gcounts.ReadBytes), gname)
+	}
+	if p.enabled("write_bytes_total", gname) {
+		ch <- prometheus.MustNewConstMetric(writeBytesDesc,
+			prometheus.CounterValue, float64(gcounts.WriteBytes), gname)
+	}
+	if p.enabled("read_chars_total", gname) {
+		ch <- prometheus.MustNewConstMetric(readCharsDesc,
+			prometheus.CounterValue, float64(gcounts.ReadChars), gname)
+	}
+	if p.enabled("write_chars_total", gname) {
+		ch <- prometheus.MustNewConstMetric(writeCharsDesc,
+			prometheus.CounterValue, float64(gcounts.WriteChars), gname)
+	}
+	if p.enabled("read_syscalls_total", gname) {
+		ch <- prometheus.MustNewConstMetric(readSyscallsDesc,
+			prometheus.CounterValue, float64(gcounts.ReadSyscalls), gname)
+	}
+	if p.enabled("write_syscalls_total", gname) {
+		ch <- prometheus.MustNewConstMetric(writeSyscallsDesc,
+			prometheus.CounterValue, float64(gcounts.WriteSyscalls), gname)
+	}
+	if p.enabled("cancelled_write_bytes_total", gname) {
+		ch <- prometheus.MustNewConstMetric(cancelledWriteBytesDesc,
+			prometheus.CounterValue, float64(gcounts.CancelledWriteBytes), gname)
+	}
+	if p.enabled("guest_cpu_seconds_total", gname) {
+		ch <- prometheus.MustNewConstMetric(guestCPUSecsDesc,
+			prometheus.CounterValue, gcounts.CPUGuestTime, gname)
+	}
+	if p.enabled("blkio_delay_seconds_total", gname) {
+		ch <- prometheus.MustNewConstMetric(blkioDelayDesc,
+			prometheus.CounterValue, gcounts.BlockIODelay, gname)
+	}
+	if p.enabled("major_page_faults_total", gname) {
+		ch <- prometheus.MustNewConstMetric(majorPageFaultsDesc,
+			prometheus.CounterValue, float64(gcounts.MajorPageFaults), gname)
+	}
+	if p.enabled("minor_page_faults_total", gname) {
+		ch <- prometheus.MustNewConstMetric(minorPageFaultsDesc,
+			prometheus.CounterValue, float64(gcounts.MinorPageFaults), gname)
+	}
+	if p.enabled("context_switches_total", gname) {
+		ch <- prometheus.MustNewConstMetric(contextSwitchesDesc,
+			prometheus.CounterValue, float64(gcounts.CtxSwitchVoluntary), gname, "voluntary")
+		ch <- prometheus.MustNewConstMetric(contextSwitchesDesc,
+			prometheus.CounterValue, float64(gcounts.CtxSwitchNonvoluntary), gname, "nonvoluntary")
+	}
+	if p.enabled("num_threads", gname) {
+		ch <- prometheus.MustNewConstMetric(numThreadsDesc,
+			prometheus.GaugeValue, float64(gcounts.NumThreads), gname)
+	}
+	if p.enabled("states", gname) {
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Running), gname, "Running")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Sleeping), gname, "Sleeping")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Waiting), gname, "Waiting")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Zombie), gname, "Zombie")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Stopped), gname, "Stopped")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Traced), gname, "Traced")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Idle), gname, "Idle")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Dead), gname, "Dead")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Parked), gname, "Parked")
+		ch <- prometheus.MustNewConstMetric(statesDesc,
+			prometheus.GaugeValue, float64(gcounts.States.Other), gname, "Other")
+	}
+
+	if p.enabled("threads_wchan", gname) {
+		for wchan, count := range gcounts.Wchans {
+			ch <- prometheus.MustNewConstMetric(threadWchanDesc,
+				prometheus.GaugeValue, float64(count), gname, wchan)
+		}
+	}
+
+	for _, thr := range gcounts.Threads {
+		if p.enabled("thread_count", gname) {
+			ch <- prometheus.MustNewConstMetric(threadCountDesc,
+				prometheus.GaugeValue, float64(thr.NumThreads),
+				gname, thr.Name)
+		}
+		if p.enabled("thread_cpu_seconds_total", gname) {
+			ch <- prometheus.MustNewConstMetric(threadCpuSecsDesc,
+				prometheus.CounterValue, float64(thr.CPUUserTime),
+				gname, thr.Name, "user")
+			ch <- prometheus.MustNewConstMetric(threadCpuSecsDesc,
+				prometheus.CounterValue, float64(thr.CPUSystemTime),
+				gname, thr.Name, "system")
+		}
+		if p.enabled("thread_io_bytes_total", gname) {
+			ch <- prometheus.MustNewConstMetric(threadIoBytesDesc,
+				prometheus.CounterValue, float64(thr.ReadBytes),
+				gname, thr.Name, "read")
+			ch <- prometheus.MustNewConstMetric(threadIoBytesDesc,
+				prometheus.CounterValue, float64(thr.WriteBytes),
+				gname, thr.Name, "write")
+		}
+		if p.enabled("thread_io_chars_total", gname) {
+			ch <- prometheus.MustNewConstMetric(threadIoCharsDesc,
+				prometheus.CounterValue, float64(thr.ReadChars),
+				gname, thr.Name, "read")
+			ch <- prometheus.MustNewConstMetric(threadIoCharsDesc,
+				prometheus.CounterValue, float64(thr.WriteChars),
+				gname, thr.Name, "write")
+		}
+		if p.enabled("thread_syscalls_total", gname) {
+			ch <- prometheus.MustNewConstMetric(threadSyscallsDesc,
+				prometheus.CounterValue, float64(thr.ReadSyscalls),
+				gname, thr.Name, "read")
+			ch <- prometheus.MustNewConstMetric(threadSyscallsDesc,
+				prometheus.CounterValue, float64(thr.WriteSyscalls),
+				gname, thr.Name, "write")
+		}
+		if p.enabled("thread_major_page_faults_total", gname) {
+			ch <- prometheus.MustNewConstMetric(threadMajorPageFaultsDesc,
+				prometheus.CounterValue, float64(thr.MajorPageFaults),
+				gname, thr.Name)
+		}
+		if p.enabled("thread_minor_page_faults_total", gname) {
+			ch <- prometheus.MustNewConstMetric(threadMinorPageFaultsDesc,
+				prometheus.CounterValue, float64(thr.MinorPageFaults),
+				gname, thr.Name)
+		}
+		if p.enabled("thread_context_switches_total", gname) {
+			ch <- prometheus.MustNewConstMetric(threadContextSwitchesDesc,
+				prometheus.CounterValue, float64(thr.CtxSwitchVoluntary),
+				gname, thr.Name, "voluntary")
+			ch <- prometheus.MustNewConstMetric(threadContextSwitchesDesc,
+				prometheus.CounterValue, float64(thr.CtxSwitchNonvoluntary),
+				gname, thr.Name, "nonvoluntary")
+		}
+	}
+}
+
+func (p *NamedProcessCollector) scrapeTopProcs(ch chan<- prometheus.Metric, gname string, top proc.TopProcs) {
+	if p.enabled("topk_cpu_rate", gname) {
+		for _, tp := range top.CPU {
+			ch <- prometheus.MustNewConstMetric(topCPUDesc,
+				prometheus.GaugeValue, tp.Value, gname, strconv.Itoa(tp.Pid), tp.Cmdline)
+		}
+	}
+	if p.enabled("topk_resident_memory_bytes", gname) {
+		for _, tp := range top.Resident {
+			ch <- prometheus.MustNewConstMetric(topResidentDesc,
+				prometheus.GaugeValue, tp.Value, gname, strconv.Itoa(tp.Pid), tp.Cmdline)
+		}
+	}
 }
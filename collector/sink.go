@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+type (
+	// SinkSample is a value of a group metric sent to sinks: a gauge, or the
+	// per-second rate of a counter.
+	SinkSample struct {
+		Group string
+		// Name is the name of the metric family without namespace, e.g.
+		// "num_procs", or for rates the name of the counter family with
+		// _total replaced by _per_second, e.g. "cpu_seconds_per_second".
+		Name string
+		// Label and LabelValue tell apart the samples of a family with
+		// several per group, e.g. "mode" and "user"; they're empty for
+		// families with a single sample per group.
+		Label, LabelValue string
+		Value             float64
+	}
+
+	// SinkUpdate is what sinks are sent every SinkInterval.
+	SinkUpdate struct {
+		Time time.Time
+		// Elapsed is the time since the previous SinkUpdate.
+		Elapsed time.Duration
+		Groups  proc.GroupByName
+		// Latest is, for each group, how much its counts increased since
+		// the previous SinkUpdate: the sum of the Update.Latest of its procs
+		// over all updates since, including those made for scrapes.
+		Latest map[string]proc.Delta
+		// Samples are the gauges of the enabled group metric families, and
+		// the rates of its counters computed from Latest and Elapsed, sorted
+		// by group, name and label value.  Per-thread families are left out.
+		Samples []SinkSample
+	}
+
+	// Sink is an output for group metrics other than Prometheus scrapes,
+	// fed by the collector every SinkInterval.
+	Sink interface {
+		// Send outputs u.  It's called from a single goroutine, one update
+		// at a time; updates are dropped while it's busy.
+		Send(u SinkUpdate) error
+	}
+)
+
+// update reads all procs and updates the groups, counting errors and execs
+// in the collector metrics, and accumulating what the counts of each group
+// increased by for sinks.  Every read of procs goes through it, whether it's
+// for a scrape or not.
+func (p *NamedProcessCollector) update() (proc.CollectErrors, proc.GroupByName, error) {
+	colErrs, groups, err := p.Update(p.source.AllProcs())
+	p.scrapePartialErrors += colErrs.Partial
+	p.procExecs += p.Stats().Execs
+	if err != nil {
+		p.scrapeErrors++
+	}
+	if err == nil && p.sinkLatest != nil {
+		for gname, d := range p.Latest() {
+			c := proc.Counts(p.sinkLatest[gname])
+			c.Add(d)
+			p.sinkLatest[gname] = proc.Delta(c)
+		}
+	}
+	return colErrs, groups, err
+}
+
+// feedSinks updates the groups and hands them to the sinks goroutine.  It
+// runs on the collector goroutine.
+func (p *NamedProcessCollector) feedSinks() {
+	_, groups, err := p.update()
+	if err != nil {
+		log.Printf("error reading procs: %v", err)
+		return
+	}
+
+	now := time.Now()
+	u := SinkUpdate{
+		Time:    now,
+		Elapsed: now.Sub(p.lastSink),
+		Groups:  groups,
+		Latest:  p.sinkLatest,
+	}
+	u.Samples = p.sinkSamples(u)
+	p.lastSink, p.sinkLatest = now, make(map[string]proc.Delta)
+
+	select {
+	case p.sinkChan <- u:
+	default:
+		log.Printf("sinks still busy with the previous update, dropping this one")
+	}
+}
+
+// runSinks sends the updates fed by feedSinks to each sink.
+func (p *NamedProcessCollector) runSinks() {
+	for u := range p.sinkChan {
+		for _, s := range p.sinks {
+			if err := s.Send(u); err != nil {
+				log.Printf("error sending metrics to sink: %v", err)
+			}
+		}
+	}
+}
+
+// sinkSamples returns the samples of u.
+func (p *NamedProcessCollector) sinkSamples(u SinkUpdate) []SinkSample {
+	secs := u.Elapsed.Seconds()
+	var samples []SinkSample
+	for gname, g := range u.Groups {
+		for _, s := range p.groupSamples(gname, g) {
+			if !s.counter {
+				samples = append(samples, SinkSample{gname, s.family, s.label, s.labelValue, s.value})
+			}
+		}
+		if secs <= 0 {
+			continue
+		}
+		// Groups without running procs have no latest counts, i.e. rates
+		// of zero.
+		latest := proc.Group{Counts: proc.Counts(u.Latest[gname])}
+		for _, s := range p.groupSamples(gname, latest) {
+			if s.counter {
+				name := strings.TrimSuffix(s.family, "_total") + "_per_second"
+				samples = append(samples, SinkSample{gname, name, s.label, s.labelValue, s.value / secs})
+			}
+		}
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		si, sj := samples[i], samples[j]
+		if si.Group != sj.Group {
+			return si.Group < sj.Group
+		}
+		if si.Name != sj.Name {
+			return si.Name < sj.Name
+		}
+		return si.LabelValue < sj.LabelValue
+	})
+	return samples
+}
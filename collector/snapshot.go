@@ -0,0 +1,247 @@
+package collector
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+// sample is one value of a group metric family.
+type sample struct {
+	group  string
+	family string
+	// label and labelValue tell apart the samples of a family with several
+	// per group, e.g. "mode" and "user"; they're empty for families with a
+	// single sample per group.
+	label, labelValue string
+	value             float64
+	counter           bool
+}
+
+var (
+	// SnapshotFormats are the formats WriteSnapshot supports.
+	SnapshotFormats = []string{"openmetrics", "json", "csv"}
+
+	// labelValueEscaper escapes label values in the OpenMetrics format.
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// groupSamples returns the samples of the enabled group metric families of
+// group gname.  Per-thread families are left out.
+func (p *NamedProcessCollector) groupSamples(gname string, g proc.Group) []sample {
+	var samples []sample
+	add := func(family string, counter bool, value float64) {
+		if p.enabled(family, gname) {
+			samples = append(samples, sample{group: gname, family: family, value: value, counter: counter})
+		}
+	}
+	addBy := func(family string, counter bool, label string, values map[string]float64) {
+		if !p.enabled(family, gname) {
+			return
+		}
+		for lv, value := range values {
+			samples = append(samples, sample{group: gname, family: family,
+				label: label, labelValue: lv, value: value, counter: counter})
+		}
+	}
+
+	add("num_procs", false, float64(g.Procs))
+	memory := map[string]float64{
+		"resident": float64(g.Memory.ResidentBytes),
+		"virtual":  float64(g.Memory.VirtualBytes),
+		"swapped":  float64(g.Memory.VmSwapBytes),
+	}
+	if p.smaps {
+		memory["proportionalResident"] = float64(g.Memory.ProportionalBytes)
+		memory["proportionalSwapped"] = float64(g.Memory.ProportionalSwapBytes)
+	}
+	addBy("memory_bytes", false, "memtype", memory)
+	add("oldest_start_time_seconds", false, float64(g.OldestStartTime.Unix()))
+	add("open_filedesc", false, float64(g.OpenFDs))
+	add("worst_fd_ratio", false, g.WorstFDratio)
+	addBy("cpu_seconds_total", true, "mode", map[string]float64{
+		"user":            g.CPUUserTime,
+		"system":          g.CPUSystemTime,
+		"children_user":   g.CPUChildrenUserTime,
+		"children_system": g.CPUChildrenSystemTime,
+	})
+	add("read_bytes_total", true, float64(g.ReadBytes))
+	add("write_bytes_total", true, float64(g.WriteBytes))
+	add("read_chars_total", true, float64(g.ReadChars))
+	add("write_chars_total", true, float64(g.WriteChars))
+	add("read_syscalls_total", true, float64(g.ReadSyscalls))
+	add("write_syscalls_total", true, float64(g.WriteSyscalls))
+	add("cancelled_write_bytes_total", true, float64(g.CancelledWriteBytes))
+	add("guest_cpu_seconds_total", true, g.CPUGuestTime)
+	add("blkio_delay_seconds_total", true, g.BlockIODelay)
+	add("major_page_faults_total", true, float64(g.MajorPageFaults))
+	add("minor_page_faults_total", true, float64(g.MinorPageFaults))
+	addBy("context_switches_total", true, "ctxswitchtype", map[string]float64{
+		"voluntary":    float64(g.CtxSwitchVoluntary),
+		"nonvoluntary": float64(g.CtxSwitchNonvoluntary),
+	})
+	add("num_threads", false, float64(g.NumThreads))
+	addBy("states", false, "state", map[string]float64{
+		"Running":  float64(g.States.Running),
+		"Sleeping": float64(g.States.Sleeping),
+		"Waiting":  float64(g.States.Waiting),
+		"Zombie":   float64(g.States.Zombie),
+		"Stopped":  float64(g.States.Stopped),
+		"Traced":   float64(g.States.Traced),
+		"Idle":     float64(g.States.Idle),
+		"Dead":     float64(g.States.Dead),
+		"Parked":   float64(g.States.Parked),
+		"Other":    float64(g.States.Other),
+	})
+	return samples
+}
+
+// WriteSnapshot reads all procs, updating the groups as a scrape would, and
+// writes the group metrics to w in the given format, one of
+// SnapshotFormats.  Unlike a scrape, the metrics are produced directly from
+// the groups, without going through a Prometheus registry, and per-thread
+// metrics are left out.  Errors are counted in the scrape error metrics.
+func (p *NamedProcessCollector) WriteSnapshot(w io.Writer, format string) error {
+	var write func(io.Writer, []sample) error
+	switch format {
+	case "openmetrics":
+		write = writeOpenMetrics
+	case "json":
+		write = writeJSON
+	case "csv":
+		write = writeCSV
+	default:
+		return fmt.Errorf("unknown snapshot format %q, want one of %s",
+			format, strings.Join(SnapshotFormats, ", "))
+	}
+
+	var (
+		samples []sample
+		err     error
+	)
+	if stopErr := p.onCollector(func() {
+		var groups proc.GroupByName
+		_, groups, err = p.update()
+		for gname, g := range groups {
+			samples = append(samples, p.groupSamples(gname, g)...)
+		}
+	}); stopErr != nil {
+		return stopErr
+	}
+	if err != nil {
+		return fmt.Errorf("error reading procs: %v", err)
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		si, sj := samples[i], samples[j]
+		if si.family != sj.family {
+			return si.family < sj.family
+		}
+		if si.group != sj.group {
+			return si.group < sj.group
+		}
+		return si.labelValue < sj.labelValue
+	})
+	return write(w, samples)
+}
+
+// writeOpenMetrics writes samples in the OpenMetrics text format.  samples
+// must be sorted by family.
+func writeOpenMetrics(w io.Writer, samples []sample) error {
+	for i, s := range samples {
+		if i == 0 || s.family != samples[i-1].family {
+			typ, name := "gauge", s.family
+			if s.counter {
+				typ, name = "counter", strings.TrimSuffix(s.family, "_total")
+			}
+			fmt.Fprintf(w, "# TYPE namedprocess_namegroup_%s %s\n", name, typ)
+		}
+		labels := `groupname="` + labelValueEscaper.Replace(s.group) + `"`
+		if s.label != "" {
+			labels += "," + s.label + `="` + labelValueEscaper.Replace(s.labelValue) + `"`
+		}
+		fmt.Fprintf(w, "namedprocess_namegroup_%s{%s} %s\n", s.family, labels,
+			strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+	_, err := fmt.Fprintln(w, "# EOF")
+	return err
+}
+
+// writeJSON writes samples as a JSON object mapping each group name to an
+// object mapping each family to its value, or for families with several
+// samples per group, to an object mapping each label value to its value.
+func writeJSON(w io.Writer, samples []sample) error {
+	groups := make(map[string]map[string]interface{})
+	for _, s := range samples {
+		families := groups[s.group]
+		if families == nil {
+			families = make(map[string]interface{})
+			groups[s.group] = families
+		}
+		if s.label == "" {
+			families[s.family] = jsonValue(s.value)
+			continue
+		}
+		values, _ := families[s.family].(map[string]interface{})
+		if values == nil {
+			values = make(map[string]interface{})
+			families[s.family] = values
+		}
+		values[s.labelValue] = jsonValue(s.value)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(groups)
+}
+
+// jsonValue returns v, or for values JSON numbers can't hold, its string
+// form in the OpenMetrics format, e.g. "NaN" or "+Inf".
+func jsonValue(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return v
+}
+
+// writeCSV writes samples as CSV with a header line, one sample per line.
+func writeCSV(w io.Writer, samples []sample) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"groupname", "metric", "label", "label_value", "value"})
+	for _, s := range samples {
+		cw.Write([]string{s.group, s.family, s.label, s.labelValue,
+			strconv.FormatFloat(s.value, 'g', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Groups reads all procs, updating the groups as a scrape would, and returns
+// the groups along with what the procs of each have in common, for exporters
+// other than Prometheus.  Errors are counted in the scrape error metrics.
+func (p *NamedProcessCollector) Groups() (proc.GroupByName, map[string]proc.GroupAttributes, error) {
+	var (
+		groups proc.GroupByName
+		attrs  map[string]proc.GroupAttributes
+		err    error
+	)
+	if stopErr := p.onCollector(func() {
+		_, groups, err = p.update()
+		if err == nil {
+			attrs = p.Attributes()
+		}
+	}); stopErr != nil {
+		return nil, nil, stopErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading procs: %v", err)
+	}
+	return groups, attrs, nil
+}
@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+// families is a MetricFilter enabling its keys for all groups.
+type families map[string]bool
+
+func (f families) MetricEnabled(family string, rule int) bool { return f[family] }
+
+func (f families) MetricNeeded(family string) bool { return f[family] }
+
+func (f families) MetricFamilies() []string {
+	var names []string
+	for family := range f {
+		names = append(names, family)
+	}
+	return names
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	src := &procSource{}
+	src.set(newProc(1, 0, "bash", 1), newProc(2, 0, "cat", 2))
+	p, err := NewProcessCollector(ProcessCollectorOption{
+		Source:  src,
+		Namer:   newNamer("bash", "cat"),
+		Metrics: families{"num_procs": true, "cpu_seconds_total": true, "worst_fd_ratio": true},
+	})
+	noerr(t, err)
+	// What procs used before the first read isn't counted.
+	_, err = p.Snapshot()
+	noerr(t, err)
+	src.set(newProc(1, 0, "bash", 1.5), newProc(2, 0, "cat", 4))
+
+	for _, tc := range []struct {
+		format string
+		want   string
+	}{
+		{"openmetrics", `# TYPE namedprocess_namegroup_cpu_seconds counter
+namedprocess_namegroup_cpu_seconds_total{groupname="bash",mode="children_system"} 0
+namedprocess_namegroup_cpu_seconds_total{groupname="bash",mode="children_user"} 0
+namedprocess_namegroup_cpu_seconds_total{groupname="bash",mode="system"} 0
+namedprocess_namegroup_cpu_seconds_total{groupname="bash",mode="user"} 0.5
+namedprocess_namegroup_cpu_seconds_total{groupname="cat",mode="children_system"} 0
+namedprocess_namegroup_cpu_seconds_total{groupname="cat",mode="children_user"} 0
+namedprocess_namegroup_cpu_seconds_total{groupname="cat",mode="system"} 0
+namedprocess_namegroup_cpu_seconds_total{groupname="cat",mode="user"} 2
+# TYPE namedprocess_namegroup_num_procs gauge
+namedprocess_namegroup_num_procs{groupname="bash"} 1
+namedprocess_namegroup_num_procs{groupname="cat"} 1
+# TYPE namedprocess_namegroup_worst_fd_ratio gauge
+namedprocess_namegroup_worst_fd_ratio{groupname="bash"} 0.25
+namedprocess_namegroup_worst_fd_ratio{groupname="cat"} 0.25
+# EOF
+`},
+		{"json", `{
+  "bash": {
+    "cpu_seconds_total": {
+      "children_system": 0,
+      "children_user": 0,
+      "system": 0,
+      "user": 0.5
+    },
+    "num_procs": 1,
+    "worst_fd_ratio": 0.25
+  },
+  "cat": {
+    "cpu_seconds_total": {
+      "children_system": 0,
+      "children_user": 0,
+      "system": 0,
+      "user": 2
+    },
+    "num_procs": 1,
+    "worst_fd_ratio": 0.25
+  }
+}
+`},
+		{"csv", `groupname,metric,label,label_value,value
+bash,cpu_seconds_total,mode,children_system,0
+bash,cpu_seconds_total,mode,children_user,0
+bash,cpu_seconds_total,mode,system,0
+bash,cpu_seconds_total,mode,user,0.5
+cat,cpu_seconds_total,mode,children_system,0
+cat,cpu_seconds_total,mode,children_user,0
+cat,cpu_seconds_total,mode,system,0
+cat,cpu_seconds_total,mode,user,2
+bash,num_procs,,,1
+cat,num_procs,,,1
+bash,worst_fd_ratio,,,0.25
+cat,worst_fd_ratio,,,0.25
+`},
+	} {
+		// The procs don't use anything more between snapshots, so each
+		// format gets the same counts.
+		var buf bytes.Buffer
+		noerr(t, p.WriteSnapshot(&buf, tc.format))
+		if diff := cmp.Diff(buf.String(), tc.want); diff != "" {
+			t.Errorf("%s: output differs: (-got +want)\n%s", tc.format, diff)
+		}
+	}
+
+	if err := p.WriteSnapshot(&bytes.Buffer{}, "xml"); err == nil {
+		t.Errorf("got no error for unknown format")
+	}
+}
+
+// TestWriteJSONNonFinite verifies that values JSON numbers can't hold are
+// written as strings.
+func TestWriteJSONNonFinite(t *testing.T) {
+	var buf bytes.Buffer
+	noerr(t, writeJSON(&buf, []sample{
+		{group: "g1", family: "worst_fd_ratio", value: math.Inf(1)},
+		{group: "g1", family: "memory_bytes", label: "memtype", labelValue: "resident", value: math.NaN()},
+	}))
+	want := `{
+  "g1": {
+    "memory_bytes": {
+      "resident": "NaN"
+    },
+    "worst_fd_ratio": "+Inf"
+  }
+}
+`
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("output differs: (-got +want)\n%s", diff)
+	}
+}
+
+// TestSnapshotExecs verifies that snapshots count execs like scrapes do.
+func TestSnapshotExecs(t *testing.T) {
+	src := &procSource{}
+	src.set(newProc(1, 0, "bash", 1))
+	p, err := NewProcessCollector(ProcessCollectorOption{Source: src, Namer: newNamer("bash", "cat")})
+	noerr(t, err)
+	_, err = p.Snapshot()
+	noerr(t, err)
+	src.set(newProc(1, 0, "cat", 1))
+	noerr(t, p.WriteSnapshot(&bytes.Buffer{}, "csv"))
+
+	var execs int
+	noerr(t, p.onCollector(func() { execs = p.procExecs }))
+	if execs != 1 {
+		t.Errorf("got %d execs, want 1", execs)
+	}
+}
+
+// TestReplaySource verifies that the collector reads recorded snapshots
+// when given a Replay as Source.
+func TestReplaySource(t *testing.T) {
+	var buf bytes.Buffer
+	rec := proc.NewRecorder(&buf, "../fixtures")
+	for i := 0; i < 2; i++ {
+		noerr(t, rec.Snapshot())
+	}
+	noerr(t, rec.Close())
+	rp, err := proc.NewReplay(&buf, false)
+	noerr(t, err)
+	defer rp.Close()
+
+	// The collector reads the first snapshot when created.
+	p, err := NewProcessCollector(ProcessCollectorOption{Source: rp, Namer: newNamer("process-exporte")})
+	noerr(t, err)
+	groups, err := p.Snapshot()
+	noerr(t, err)
+	if g := groups["process-exporte"]; g.Procs != 1 {
+		t.Errorf("got group %+v, want 1 proc", g)
+	}
+	if _, err := p.Snapshot(); err == nil {
+		t.Errorf("got no error reading past the last snapshot")
+	}
+}
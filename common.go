@@ -21,4 +21,17 @@ type (
 		MatchAndName(ProcAttributes) (bool, string)
 		fmt.Stringer
 	}
+
+	// RuleMatchNamer is implemented by MatchNamers made of several rules,
+	// e.g. those of a config file, whose settings may differ.
+	RuleMatchNamer interface {
+		MatchNamer
+		// MatchAndNameRule is like MatchAndName, but also returns the index
+		// of the rule that matched, or NoRule if none did.
+		MatchAndNameRule(ProcAttributes) (matched bool, name string, rule int)
+	}
 )
+
+// NoRule is the rule of procs, and groups, that no rule of a RuleMatchNamer
+// named, e.g. those in the catch-all group.
+const NoRule = -1
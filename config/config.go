@@ -82,12 +82,44 @@ func (f FirstMatcher) String() string {
 }
 
 func (f FirstMatcher) MatchAndName(nacl common.ProcAttributes) (bool, string) {
-	for _, m := range f.matchers {
+	matched, name, _ := f.MatchAndNameRule(nacl)
+	return matched, name
+}
+
+// MatchAndNameRule implements common.RuleMatchNamer.  The rule is the index
+// of the first matcher that matched.
+func (f FirstMatcher) MatchAndNameRule(nacl common.ProcAttributes) (bool, string, int) {
+	for i, m := range f.matchers {
 		if matched, name := m.MatchAndName(nacl); matched {
-			return true, name
+			return true, name, i
 		}
 	}
-	return false, ""
+	return false, "", common.NoRule
+}
+
+// CheckMatchAndName is like MatchAndName, but also returns any error
+// encountered executing the name template of the matching rule.
+func (f FirstMatcher) CheckMatchAndName(nacl common.ProcAttributes) (bool, string, error) {
+	matched, name, _, err := f.CheckMatchAndNameRule(nacl)
+	return matched, name, err
+}
+
+// CheckMatchAndNameRule is like MatchAndNameRule, but also returns any
+// error encountered executing the name template of the matching rule.
+func (f FirstMatcher) CheckMatchAndNameRule(nacl common.ProcAttributes) (bool, string, int, error) {
+	for i, m := range f.matchers {
+		mn, ok := m.(*matchNamer)
+		if !ok {
+			if matched, name := m.MatchAndName(nacl); matched {
+				return true, name, i, nil
+			}
+			continue
+		}
+		if matched, name, err := mn.matchAndName(nacl); matched {
+			return true, name, i, err
+		}
+	}
+	return false, "", common.NoRule, nil
 }
 
 func (m *matchNamer) String() string {
@@ -95,8 +127,13 @@ func (m *matchNamer) String() string {
 }
 
 func (m *matchNamer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
+	matched, name, _ := m.matchAndName(nacl)
+	return matched, name
+}
+
+func (m *matchNamer) matchAndName(nacl common.ProcAttributes) (bool, string, error) {
 	if !m.Match(nacl) {
-		return false, ""
+		return false, "", nil
 	}
 
 	matches := make(map[string]string)
@@ -115,7 +152,7 @@ func (m *matchNamer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
 	}
 
 	var buf bytes.Buffer
-	m.template.Execute(&buf, &templateParams{
+	err := m.template.Execute(&buf, &templateParams{
 		Comm:      nacl.Name,
 		Cgroups:   nacl.Cgroups,
 		ExeBase:   exebase,
@@ -125,7 +162,10 @@ func (m *matchNamer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
 		PID:       nacl.PID,
 		StartTime: nacl.StartTime,
 	})
-	return true, buf.String()
+	if err != nil {
+		return true, buf.String(), fmt.Errorf("error executing name template for %q: %v", nacl.Name, err)
+	}
+	return true, buf.String(), nil
 }
 
 func (m *commMatcher) Match(nacl common.ProcAttributes) bool {
@@ -178,12 +218,107 @@ func (m andMatcher) Match(nacl common.ProcAttributes) bool {
 
 type Config struct {
 	MatchNamers FirstMatcher
+	// Metrics selects the metric families to emit for groups whose rule
+	// doesn't have its own selection.
+	Metrics MetricFamilies
+	// Unmatched, if not nil, configures a catch-all group for procs that
+	// no matcher selects.
+	Unmatched *Unmatched
+	// rules holds the settings of each matcher, in the same order.
+	rules []ruleSettings
+}
+
+// ruleSettings are the per-group settings of a matcher.
+type ruleSettings struct {
+	// metrics is the metric families selection, or nil if not given.
+	metrics *MetricFamilies
+	// threads is whether to report per-thread metrics, or nil if not given.
+	threads *bool
+	// threadNames are applied in order to rewrite thread names.
+	threadNames []threadNameRule
+	// maxThreadNames limits the number of distinct thread names, 0 means
+	// no limit.
+	maxThreadNames int
+	// topK is the number of busiest procs to report.
+	topK int
+	// children is the children inheritance policy, or nil if not given.
+	children *ChildrenRule
+}
+
+// childGroupSeparator separates the group name of a matched proc from the
+// comm of a descendant in the name of the descendant's group, when
+// ChildrenRule.Suffix is set.
+const childGroupSeparator = "/child:"
+
+// threadNameRule rewrites thread names matching regex using replace.
+type threadNameRule struct {
+	regex   *regexp.Regexp
+	replace string
+}
+
+// ThreadNameRule is the YAML form of a thread name rewrite rule.  Any part of
+// a thread name matching Match is replaced with Replace, which may refer to
+// captures as in regexp.ReplaceAllString.
+type ThreadNameRule struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// ChildrenRule is the YAML form of a group's children inheritance policy.
+type ChildrenRule struct {
+	// Inherit is whether descendants of the group's procs are tracked with
+	// them.  If not given, the -children flag decides.
+	Inherit *bool `yaml:"inherit"`
+	// MaxDepth is how many generations of descendants to track with the
+	// group, e.g. 1 for children only.  0 means no limit.
+	MaxDepth int `yaml:"max_depth"`
+	// Suffix makes descendants be tracked in a group of their own per
+	// comm, named e.g. "postgres/child:psql", instead of in the group of
+	// their matched ancestor.
+	Suffix bool `yaml:"suffix"`
+}
+
+// MetricFamilies selects metric families by name, e.g. "cpu_seconds_total".
+// If Include is non-empty only the families listed are enabled, otherwise
+// all are.  Families listed in Exclude are disabled regardless.
+type MetricFamilies struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// Enabled returns true if family is selected.
+func (m MetricFamilies) Enabled(family string) bool {
+	for _, f := range m.Exclude {
+		if f == family {
+			return false
+		}
+	}
+	if len(m.Include) == 0 {
+		return true
+	}
+	for _, f := range m.Include {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// Unmatched configures the catch-all group for procs no matcher selects.
+type Unmatched struct {
+	// Name is the group name, "other" by default.
+	Name string `yaml:"name"`
+	// TopK is the number of comms using the most resources among unmatched
+	// procs to report.
+	TopK int `yaml:"topk"`
 }
 
 func (c *Config) UnmarshalYAML(unmarshal func(v interface{}) error) error {
 	type (
 		root struct {
-			Matchers MatcherRules `yaml:"process_names"`
+			Metrics   MetricFamilies `yaml:"metrics"`
+			Unmatched *Unmatched     `yaml:"unmatched"`
+			Matchers  MatcherRules   `yaml:"process_names"`
 		}
 	)
 
@@ -196,15 +331,149 @@ func (c *Config) UnmarshalYAML(unmarshal func(v interface{}) error) error {
 	if err != nil {
 		return err
 	}
+	cfg.Metrics = r.Metrics
+	if r.Unmatched != nil {
+		if r.Unmatched.Name == "" {
+			r.Unmatched.Name = "other"
+		}
+		if r.Unmatched.TopK < 0 {
+			return fmt.Errorf("bad unmatched topk %d: must not be negative", r.Unmatched.TopK)
+		}
+		cfg.Unmatched = r.Unmatched
+	}
 	*c = *cfg
 	return nil
 }
 
+// ruleSettings returns the settings of the matcher at index rule, as
+// returned by MatchAndNameRule, or the zero value for common.NoRule.
+func (c *Config) ruleSettings(rule int) ruleSettings {
+	if rule >= 0 && rule < len(c.rules) {
+		return c.rules[rule]
+	}
+	return ruleSettings{}
+}
+
+// MetricEnabled returns true if family should be emitted for groups of
+// rule.
+func (c *Config) MetricEnabled(family string, rule int) bool {
+	if m := c.ruleSettings(rule).metrics; m != nil {
+		return m.Enabled(family)
+	}
+	return c.Metrics.Enabled(family)
+}
+
+// MetricNeeded returns true if family may be emitted for any group.
+func (c *Config) MetricNeeded(family string) bool {
+	if c.Metrics.Enabled(family) && c.fallsBack() {
+		return true
+	}
+	for _, rule := range c.rules {
+		if rule.metrics != nil && rule.metrics.Enabled(family) {
+			return true
+		}
+	}
+	return false
+}
+
+// fallsBack returns true if some groups may use the global metric families
+// selection: those of rules without a selection of their own, and those no
+// rule named, i.e. the catch-all group.
+func (c *Config) fallsBack() bool {
+	if len(c.rules) == 0 || c.Unmatched != nil {
+		return true
+	}
+	for _, rule := range c.rules {
+		if rule.metrics == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// TrackThreads returns whether per-thread metrics should be reported for
+// groups of rule.  ok is false if the config doesn't say.
+func (c *Config) TrackThreads(rule int) (track bool, ok bool) {
+	if t := c.ruleSettings(rule).threads; t != nil {
+		return *t, true
+	}
+	return false, false
+}
+
+// ThreadName returns the name under which to report a thread named
+// threadname of a group of rule.
+func (c *Config) ThreadName(rule int, threadname string) string {
+	for _, r := range c.ruleSettings(rule).threadNames {
+		threadname = r.regex.ReplaceAllString(threadname, r.replace)
+	}
+	return threadname
+}
+
+// MaxThreadNames returns the maximum number of distinct thread names to
+// report for groups of rule, or 0 if there's no limit.
+func (c *Config) MaxThreadNames(rule int) int {
+	return c.ruleSettings(rule).maxThreadNames
+}
+
+// TopK returns the number of busiest procs of groups of rule to report
+// individually, or 0 for none.
+func (c *Config) TopK(rule int) int {
+	return c.ruleSettings(rule).topK
+}
+
+// InheritChildren returns whether descendants of the procs rule matched are
+// tracked with them.  ok is false if the config doesn't say.
+func (c *Config) InheritChildren(rule int) (inherit bool, ok bool) {
+	if ch := c.ruleSettings(rule).children; ch != nil && ch.Inherit != nil {
+		return *ch.Inherit, true
+	}
+	return false, false
+}
+
+// MaxChildDepth returns how many generations of descendants of the procs
+// rule matched are tracked with them, or 0 if there's no limit.
+func (c *Config) MaxChildDepth(rule int) int {
+	if ch := c.ruleSettings(rule).children; ch != nil {
+		return ch.MaxDepth
+	}
+	return 0
+}
+
+// ChildGroupName returns the name of the group tracking a descendant named
+// comm of a proc rule matched and named groupname.
+func (c *Config) ChildGroupName(rule int, groupname, comm string) string {
+	if ch := c.ruleSettings(rule).children; ch != nil && ch.Suffix {
+		return groupname + childGroupSeparator + comm
+	}
+	return groupname
+}
+
+// MetricFamilies returns the names of all the metric families the config
+// refers to.
+func (c *Config) MetricFamilies() []string {
+	var families []string
+	families = append(families, c.Metrics.Include...)
+	families = append(families, c.Metrics.Exclude...)
+	for _, rule := range c.rules {
+		if rule.metrics != nil {
+			families = append(families, rule.metrics.Include...)
+			families = append(families, rule.metrics.Exclude...)
+		}
+	}
+	return families
+}
+
 type MatcherGroup struct {
-	Name         string   `yaml:"name"`
-	CommRules    []string `yaml:"comm"`
-	ExeRules     []string `yaml:"exe"`
-	CmdlineRules []string `yaml:"cmdline"`
+	Name           string           `yaml:"name"`
+	CommRules      []string         `yaml:"comm"`
+	ExeRules       []string         `yaml:"exe"`
+	CmdlineRules   []string         `yaml:"cmdline"`
+	Metrics        *MetricFamilies  `yaml:"metrics"`
+	Threads        *bool            `yaml:"threads"`
+	ThreadNames    []ThreadNameRule `yaml:"thread_names"`
+	MaxThreadNames int              `yaml:"max_thread_names"`
+	TopK           int              `yaml:"topk"`
+	Children       *ChildrenRule    `yaml:"children"`
 }
 
 type MatcherRules []MatcherGroup
@@ -263,6 +532,30 @@ func (r MatcherRules) ToConfig() (*Config, error) {
 
 		matchNamer := &matchNamer{matchers, templateNamer{tmpl}}
 		cfg.MatchNamers.matchers = append(cfg.MatchNamers.matchers, matchNamer)
+		rule := ruleSettings{
+			metrics:        matcher.Metrics,
+			threads:        matcher.Threads,
+			maxThreadNames: matcher.MaxThreadNames,
+			topK:           matcher.TopK,
+			children:       matcher.Children,
+		}
+		for _, tn := range matcher.ThreadNames {
+			r, err := regexp.Compile(tn.Match)
+			if err != nil {
+				return nil, fmt.Errorf("bad thread_names regex %q: %v", tn.Match, err)
+			}
+			rule.threadNames = append(rule.threadNames, threadNameRule{r, tn.Replace})
+		}
+		if matcher.MaxThreadNames < 0 {
+			return nil, fmt.Errorf("bad max_thread_names %d: must not be negative", matcher.MaxThreadNames)
+		}
+		if matcher.TopK < 0 {
+			return nil, fmt.Errorf("bad topk %d: must not be negative", matcher.TopK)
+		}
+		if matcher.Children != nil && matcher.Children.MaxDepth < 0 {
+			return nil, fmt.Errorf("bad children max_depth %d: must not be negative", matcher.Children.MaxDepth)
+		}
+		cfg.rules = append(cfg.rules, rule)
 	}
 
 	return &cfg, nil
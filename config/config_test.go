@@ -93,3 +93,235 @@ process_names:
 	c.Check(found, Equals, true)
 	c.Check(name, Equals, now.String())
 }
+
+func (s MySuite) TestConfigTemplateError(c *C) {
+	yml := `
+process_names:
+  - comm:
+    - cat
+    name: "{{.NoSuchField}}"
+  - comm:
+    - bash
+`
+	cfg, err := GetConfig(yml, false)
+	c.Assert(err, IsNil)
+
+	cat := common.ProcAttributes{Name: "cat", Cmdline: []string{"/bin/cat"}}
+	found, _, err := cfg.MatchNamers.CheckMatchAndName(cat)
+	c.Check(found, Equals, true)
+	c.Check(err, NotNil)
+
+	bash := common.ProcAttributes{Name: "bash", Cmdline: []string{"/bin/bash"}}
+	found, name, err := cfg.MatchNamers.CheckMatchAndName(bash)
+	c.Check(found, Equals, true)
+	c.Check(name, Equals, "bash")
+	c.Check(err, IsNil)
+
+	_, _, rule, err := cfg.MatchNamers.CheckMatchAndNameRule(cat)
+	c.Check(rule, Equals, 0)
+	c.Check(err, NotNil)
+	_, _, rule, err = cfg.MatchNamers.CheckMatchAndNameRule(bash)
+	c.Check(rule, Equals, 1)
+	c.Check(err, IsNil)
+}
+
+func (s MySuite) TestConfigMetrics(c *C) {
+	yml := `
+metrics:
+  exclude:
+  - threads_wchan
+process_names:
+  - comm:
+    - bash
+    metrics:
+      include:
+      - num_procs
+  - comm:
+    - cat
+`
+	cfg, err := GetConfig(yml, false)
+	c.Assert(err, IsNil)
+	c.Check(cfg.MetricFamilies(), DeepEquals, []string{"threads_wchan", "num_procs"})
+
+	_, _, bash := cfg.MatchNamers.MatchAndNameRule(common.ProcAttributes{Name: "bash", Cmdline: []string{"/bin/bash"}})
+	_, _, cat := cfg.MatchNamers.MatchAndNameRule(common.ProcAttributes{Name: "cat", Cmdline: []string{"/bin/cat"}})
+
+	c.Check(cfg.MetricEnabled("num_procs", bash), Equals, true)
+	c.Check(cfg.MetricEnabled("cpu_seconds_total", bash), Equals, false)
+	c.Check(cfg.MetricEnabled("cpu_seconds_total", cat), Equals, true)
+	c.Check(cfg.MetricEnabled("threads_wchan", cat), Equals, false)
+	c.Check(cfg.MetricEnabled("cpu_seconds_total", common.NoRule), Equals, true)
+	c.Check(cfg.MetricNeeded("cpu_seconds_total"), Equals, true)
+	c.Check(cfg.MetricNeeded("threads_wchan"), Equals, false)
+}
+
+func (s MySuite) TestConfigMetricsNeeded(c *C) {
+	yml := `
+metrics:
+  include:
+  - cpu_seconds_total
+process_names:
+  - comm:
+    - bash
+    metrics:
+      include:
+      - num_procs
+`
+	cfg, err := GetConfig(yml, false)
+	c.Assert(err, IsNil)
+	// Every rule has its own selection, so the global one isn't needed.
+	c.Check(cfg.MetricNeeded("num_procs"), Equals, true)
+	c.Check(cfg.MetricNeeded("cpu_seconds_total"), Equals, false)
+
+	// The catch-all group uses the global selection.
+	cfg, err = GetConfig("unmatched:\n  name: other\n"+yml, false)
+	c.Assert(err, IsNil)
+	c.Check(cfg.MetricNeeded("num_procs"), Equals, true)
+	c.Check(cfg.MetricNeeded("cpu_seconds_total"), Equals, true)
+	c.Check(cfg.MetricNeeded("memory_bytes"), Equals, false)
+}
+
+func (s MySuite) TestConfigRules(c *C) {
+	yml := `
+process_names:
+  - name: shell
+    comm:
+    - bash
+  - name: shell
+    comm:
+    - sh
+`
+	cfg, err := GetConfig(yml, false)
+	c.Assert(err, IsNil)
+
+	// Rules are told apart even when they give the same name.
+	for i, name := range []string{"bash", "sh"} {
+		matched, gname, rule := cfg.MatchNamers.MatchAndNameRule(common.ProcAttributes{Name: name, Cmdline: []string{name}})
+		c.Check(matched, Equals, true)
+		c.Check(gname, Equals, "shell")
+		c.Check(rule, Equals, i)
+	}
+	matched, _, rule := cfg.MatchNamers.MatchAndNameRule(common.ProcAttributes{Name: "cat"})
+	c.Check(matched, Equals, false)
+	c.Check(rule, Equals, common.NoRule)
+}
+
+func (s MySuite) TestConfigThreads(c *C) {
+	yml := `
+process_names:
+  - comm:
+    - java
+    threads: true
+    thread_names:
+    - match: '-\d+$'
+      replace: ''
+    max_thread_names: 10
+  - comm:
+    - bash
+    threads: false
+  - comm:
+    - cat
+`
+	cfg, err := GetConfig(yml, false)
+	c.Assert(err, IsNil)
+
+	rules := make(map[string]int)
+	for _, name := range []string{"java", "bash", "cat"} {
+		_, _, rules[name] = cfg.MatchNamers.MatchAndNameRule(common.ProcAttributes{Name: name, Cmdline: []string{name}})
+	}
+
+	track, ok := cfg.TrackThreads(rules["java"])
+	c.Check(track, Equals, true)
+	c.Check(ok, Equals, true)
+	c.Check(cfg.ThreadName(rules["java"], "worker-1234"), Equals, "worker")
+	c.Check(cfg.MaxThreadNames(rules["java"]), Equals, 10)
+
+	track, ok = cfg.TrackThreads(rules["bash"])
+	c.Check(track, Equals, false)
+	c.Check(ok, Equals, true)
+
+	_, ok = cfg.TrackThreads(rules["cat"])
+	c.Check(ok, Equals, false)
+	c.Check(cfg.ThreadName(rules["cat"], "worker-1234"), Equals, "worker-1234")
+	c.Check(cfg.MaxThreadNames(rules["cat"]), Equals, 0)
+
+	_, ok = cfg.TrackThreads(common.NoRule)
+	c.Check(ok, Equals, false)
+}
+
+func (s MySuite) TestConfigUnmatched(c *C) {
+	yml := `
+process_names:
+  - comm:
+    - bash
+`
+	cfg, err := GetConfig(yml, false)
+	c.Assert(err, IsNil)
+	c.Check(cfg.Unmatched, IsNil)
+
+	cfg, err = GetConfig("unmatched:\n  topk: 5\n"+yml, false)
+	c.Assert(err, IsNil)
+	c.Check(cfg.Unmatched, DeepEquals, &Unmatched{Name: "other", TopK: 5})
+
+	cfg, err = GetConfig("unmatched:\n  name: rest\n"+yml, false)
+	c.Assert(err, IsNil)
+	c.Check(cfg.Unmatched, DeepEquals, &Unmatched{Name: "rest"})
+
+	_, err = GetConfig("unmatched:\n  topk: -1\n"+yml, false)
+	c.Check(err, NotNil)
+}
+
+func (s MySuite) TestConfigChildren(c *C) {
+	yml := `
+process_names:
+  - comm:
+    - postgres
+    threads: true
+    children:
+      inherit: true
+      max_depth: 2
+      suffix: true
+  - comm:
+    - sshd
+    children:
+      inherit: false
+  - comm:
+    - cat
+`
+	cfg, err := GetConfig(yml, false)
+	c.Assert(err, IsNil)
+
+	rules := make(map[string]int)
+	for _, name := range []string{"postgres", "sshd", "cat"} {
+		_, _, rules[name] = cfg.MatchNamers.MatchAndNameRule(common.ProcAttributes{Name: name, Cmdline: []string{name}})
+	}
+
+	inherit, ok := cfg.InheritChildren(rules["postgres"])
+	c.Check(inherit, Equals, true)
+	c.Check(ok, Equals, true)
+	c.Check(cfg.MaxChildDepth(rules["postgres"]), Equals, 2)
+	c.Check(cfg.ChildGroupName(rules["postgres"], "postgres", "psql"), Equals, "postgres/child:psql")
+	// Suffixed groups have the rule of their ancestor's group, and so its
+	// settings.
+	track, ok := cfg.TrackThreads(rules["postgres"])
+	c.Check(track, Equals, true)
+	c.Check(ok, Equals, true)
+
+	inherit, ok = cfg.InheritChildren(rules["sshd"])
+	c.Check(inherit, Equals, false)
+	c.Check(ok, Equals, true)
+	c.Check(cfg.ChildGroupName(rules["sshd"], "sshd", "bash"), Equals, "sshd")
+
+	_, ok = cfg.InheritChildren(rules["cat"])
+	c.Check(ok, Equals, false)
+	c.Check(cfg.MaxChildDepth(rules["cat"]), Equals, 0)
+
+	_, err = GetConfig(`
+process_names:
+  - comm:
+    - postgres
+    children:
+      max_depth: -1
+`, false)
+	c.Check(err, NotNil)
+}
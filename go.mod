@@ -3,15 +3,20 @@ module github.com/ncabatoff/process-exporter
 go 1.13
 
 require (
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.4
 	github.com/google/go-cmp v0.5.6
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/ncabatoff/fakescraper v0.0.0-20201102132415-4b37ba603d65
 	github.com/ncabatoff/go-seq v0.0.0-20180805175032-b08ef85ed833
 	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.29.0
 	github.com/prometheus/exporter-toolkit v0.7.0
 	github.com/prometheus/procfs v0.7.3
 	github.com/rogpeppe/go-internal v1.8.0 // indirect
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -65,6 +65,28 @@ func (n namer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
 	return false, ""
 }
 
+// ruleNamer names procs after their comm like namer, and also reports the
+// rule of each name, which is its index.  Rules may share a name.
+type ruleNamer []string
+
+func (n ruleNamer) String() string {
+	return fmt.Sprintf("%v", []string(n))
+}
+
+func (n ruleNamer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
+	matched, name, _ := n.MatchAndNameRule(nacl)
+	return matched, name
+}
+
+func (n ruleNamer) MatchAndNameRule(nacl common.ProcAttributes) (bool, string, int) {
+	for i, name := range n {
+		if name == nacl.Name {
+			return true, name, i
+		}
+	}
+	return false, "", common.NoRule
+}
+
 func newProcIDStatic(pid, ppid int, startTime uint64, name string, cmdline []string) (ID, Static) {
 	return ID{pid, startTime},
 		Static{name, cmdline, []string{}, ppid, time.Unix(int64(startTime), 0).UTC(), 1000}
@@ -100,6 +122,6 @@ func piinfost(pid int, name string, c Counts, m Memory, f Filedesc, t int, s Sta
 	return IDInfo{
 		ID:      id,
 		Static:  static,
-		Metrics: Metrics{c, m, f, uint64(t), s, ""},
+		Metrics: Metrics{c, m, f, uint64(t), s, "", Placement{}, Scheduling{}},
 	}
 }
@@ -1,6 +1,7 @@
 package proc
 
 import (
+	"sort"
 	"time"
 
 	seq "github.com/ncabatoff/go-seq/seq"
@@ -13,10 +14,56 @@ type (
 	Grouper struct {
 		// groupAccum records the historical accumulation of a group so that
 		// we can avoid ever decreasing the counts we return.
-		groupAccum  map[string]Counts
+		groupAccum map[string]Counts
+		// latest holds how much the counts of each group with running
+		// procs increased in the last Update.
+		latest map[string]Delta
+		// rules maps each group to the rule of its procs, which decides
+		// its settings in the policies: the lowest of the rules of its
+		// running procs, or if it has none, the last known one.
+		rules       map[string]int
 		tracker     *Tracker
 		threadAccum map[string]map[string]Threads
-		debug       bool
+		// trackThreads is whether to report threads for groups the
+		// threadPolicy has no opinion on.
+		trackThreads bool
+		threadPolicy ThreadPolicy
+		topKPolicy   TopKPolicy
+		// topProcs holds the busiest procs of each group as of lastUpdate.
+		topProcs map[string]TopProcs
+		// catchAllTopK is the number of comms to report in topUnmatched.
+		catchAllTopK int
+		// topUnmatched holds the busiest comms among unmatched procs as
+		// of lastUpdate.
+		topUnmatched TopComms
+		// placement, if not nil, holds where the procs of each group run
+		// as of lastUpdate.
+		placement map[string]GroupPlacement
+		// cpuNodes maps each CPU to its NUMA node, if known.
+		cpuNodes map[int]int
+		// scheduling, if not nil, holds how the threads of each group are
+		// scheduled as of lastUpdate.
+		scheduling map[string]GroupScheduling
+		// lastUpdate is the time of the previous Update, whatever it was
+		// called for, and so the start of the interval of the topk CPU
+		// rates.
+		lastUpdate time.Time
+		debug      bool
+	}
+
+	// ThreadPolicy controls how the threads of each group are reported,
+	// based on the rule of the group as returned by GroupRule.
+	ThreadPolicy interface {
+		// TrackThreads returns whether to report threads for groups of
+		// rule.  ok is false if the policy has no opinion.
+		TrackThreads(rule int) (track bool, ok bool)
+		// ThreadName returns the name under which to aggregate a thread
+		// named threadname of a group of rule.
+		ThreadName(rule int, threadname string) string
+		// MaxThreadNames returns the maximum number of distinct thread
+		// names to report for groups of rule, or 0 if there's no limit.
+		// Threads beyond the limit are aggregated under OtherThreadName.
+		MaxThreadNames(rule int) int
 	}
 
 	// GroupByName maps group name to group metrics.
@@ -44,6 +91,10 @@ type (
 	}
 )
 
+// OtherThreadName is the name under which threads are aggregated once a
+// group has reached its limit of distinct thread names.
+const OtherThreadName = "other"
+
 // Returns true if x < y.  Test designers should ensure they always have
 // a unique name/numthreads combination for each group.
 func lessThreads(x, y Threads) bool { return seq.Compare(x, y) < 0 }
@@ -51,14 +102,37 @@ func lessThreads(x, y Threads) bool { return seq.Compare(x, y) < 0 }
 // NewGrouper creates a grouper.
 func NewGrouper(namer common.MatchNamer, trackChildren, trackThreads, alwaysRecheck, debug bool) *Grouper {
 	g := Grouper{
-		groupAccum:  make(map[string]Counts),
-		threadAccum: make(map[string]map[string]Threads),
-		tracker:     NewTracker(namer, trackChildren, alwaysRecheck, debug),
-		debug:       debug,
+		groupAccum:   make(map[string]Counts),
+		rules:        make(map[string]int),
+		threadAccum:  make(map[string]map[string]Threads),
+		tracker:      NewTracker(namer, trackChildren, alwaysRecheck, debug),
+		trackThreads: trackThreads,
+		debug:        debug,
 	}
 	return &g
 }
 
+// SetThreadPolicy makes the grouper report threads of each group as
+// directed by tp.
+func (g *Grouper) SetThreadPolicy(tp ThreadPolicy) {
+	g.threadPolicy = tp
+}
+
+// SetChildrenPolicy makes the grouper track descendants of the procs of
+// each group as directed by cp.
+func (g *Grouper) SetChildrenPolicy(cp ChildrenPolicy) {
+	g.tracker.SetChildrenPolicy(cp)
+}
+
+func (g *Grouper) tracksThreads(gname string) bool {
+	if g.threadPolicy != nil {
+		if track, ok := g.threadPolicy.TrackThreads(g.GroupRule(gname)); ok {
+			return track
+		}
+	}
+	return g.trackThreads
+}
+
 func groupadd(grp Group, ts Update) Group {
 	var zeroTime time.Time
 
@@ -71,9 +145,12 @@ func groupadd(grp Group, ts Update) Group {
 	if ts.Filedesc.Open != -1 {
 		grp.OpenFDs += uint64(ts.Filedesc.Open)
 	}
-	openratio := float64(ts.Filedesc.Open) / float64(ts.Filedesc.Limit)
-	if grp.WorstFDratio < openratio {
-		grp.WorstFDratio = openratio
+	// The limit isn't known when limits aren't gathered.
+	if ts.Filedesc.Limit > 0 {
+		openratio := float64(ts.Filedesc.Open) / float64(ts.Filedesc.Limit)
+		if grp.WorstFDratio < openratio {
+			grp.WorstFDratio = openratio
+		}
 	}
 	grp.NumThreads += ts.NumThreads
 	grp.Counts.Add(ts.Latest)
@@ -101,13 +178,57 @@ func groupadd(grp Group, ts Update) Group {
 // with the same counts as before; of course, all non-count metrics
 // will be zero.
 func (g *Grouper) Update(iter Iter) (CollectErrors, GroupByName, error) {
+	now := iterTime(iter)
 	cerrs, tracked, err := g.tracker.Update(iter)
 	if err != nil {
 		return cerrs, nil, err
 	}
+
+	g.updateRules()
+
+	var elapsed time.Duration
+	if !g.lastUpdate.IsZero() {
+		elapsed = now.Sub(g.lastUpdate)
+	}
+	g.lastUpdate = now
+	g.updateTopProcs(elapsed)
+	if g.placement != nil {
+		g.updatePlacement()
+	}
+	if g.scheduling != nil {
+		g.updateScheduling()
+	}
+
 	return cerrs, g.groups(tracked), nil
 }
 
+// updateRules records the rule of each group with running procs.  When
+// several rules name procs of the same group, the lowest, i.e. the first
+// rule in a config file, wins, so that the group's settings don't depend on
+// which procs happen to be running.
+func (g *Grouper) updateRules() {
+	current := make(map[string]int)
+	for _, tproc := range g.tracker.tracked {
+		rule, ok := current[tproc.groupName]
+		if !ok || tproc.rule != common.NoRule && (rule == common.NoRule || tproc.rule < rule) {
+			current[tproc.groupName] = tproc.rule
+		}
+	}
+	for gname, rule := range current {
+		g.rules[gname] = rule
+	}
+}
+
+// GroupRule returns the rule of group gname, i.e. that of its procs as
+// returned by a common.RuleMatchNamer, or common.NoRule if it's unknown or
+// the procs were named by a plain common.MatchNamer.
+func (g *Grouper) GroupRule(gname string) int {
+	if rule, ok := g.rules[gname]; ok {
+		return rule
+	}
+	return common.NoRule
+}
+
 // Translate the updates into a new GroupByName and update internal history.
 func (g *Grouper) groups(tracked []Update) GroupByName {
 	groups := make(GroupByName)
@@ -123,12 +244,18 @@ func (g *Grouper) groups(tracked []Update) GroupByName {
 
 	// Add any accumulated counts to what was just observed,
 	// and update the accumulators.
+	g.latest = make(map[string]Delta, len(groups))
 	for gname, group := range groups {
+		g.latest[gname] = Delta(group.Counts)
 		if oldcounts, ok := g.groupAccum[gname]; ok {
 			group.Counts.Add(Delta(oldcounts))
 		}
 		g.groupAccum[gname] = group.Counts
-		group.Threads = g.threads(gname, threadsByGroup[gname])
+		if g.tracksThreads(gname) {
+			group.Threads = g.threads(gname, threadsByGroup[gname])
+		} else {
+			delete(g.threadAccum, gname)
+		}
 		groups[gname] = group
 	}
 
@@ -153,11 +280,21 @@ func (g *Grouper) threads(gname string, tracked []ThreadUpdate) []Threads {
 
 	// First aggregate the thread metrics by thread name.
 	for _, nc := range tracked {
-		curthr := threads[nc.ThreadName]
+		tname := nc.ThreadName
+		if g.threadPolicy != nil {
+			tname = g.threadPolicy.ThreadName(g.GroupRule(gname), tname)
+		}
+		curthr := threads[tname]
 		curthr.NumThreads++
 		curthr.Counts.Add(nc.Latest)
-		curthr.Name = nc.ThreadName
-		threads[nc.ThreadName] = curthr
+		curthr.Name = tname
+		threads[tname] = curthr
+	}
+
+	if g.threadPolicy != nil {
+		if max := g.threadPolicy.MaxThreadNames(g.GroupRule(gname)); max > 0 && len(threads) > max {
+			threads = capThreads(threads, g.threadAccum[gname], max)
+		}
 	}
 
 	// Add any accumulated counts to what was just observed,
@@ -179,3 +316,96 @@ func (g *Grouper) threads(gname string, tracked []ThreadUpdate) []Threads {
 	}
 	return ret
 }
+
+// capThreads keeps at most max of the thread names in threads, aggregating
+// the rest under OtherThreadName.  Names already present in history are
+// kept in preference to new ones so that reported names are stable.
+func capThreads(threads, history map[string]Threads, max int) map[string]Threads {
+	names := make([]string, 0, len(threads))
+	for tname := range threads {
+		if tname != OtherThreadName {
+			names = append(names, tname)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		_, iold := history[names[i]]
+		_, jold := history[names[j]]
+		if iold != jold {
+			return iold
+		}
+		return names[i] < names[j]
+	})
+
+	capped := make(map[string]Threads, max+1)
+	other := threads[OtherThreadName]
+	other.Name = OtherThreadName
+	for i, tname := range names {
+		if i < max {
+			capped[tname] = threads[tname]
+			continue
+		}
+		other.NumThreads += threads[tname].NumThreads
+		other.Counts.Add(Delta(threads[tname].Counts))
+	}
+	if other.NumThreads > 0 {
+		capped[OtherThreadName] = other
+	}
+	return capped
+}
+
+// Latest returns, for each group with running procs, how much its counts
+// increased in the last Update, i.e. the sum of the Update.Latest of its
+// procs.
+func (g *Grouper) Latest() map[string]Delta {
+	return g.latest
+}
+
+// Tracked returns a description of each proc currently being tracked.
+func (g *Grouper) Tracked() []TrackedProc {
+	return g.tracker.Tracked()
+}
+
+// Tree returns the tracked procs arranged by parentage.
+func (g *Grouper) Tree() []*TreeNode {
+	return g.tracker.Tree()
+}
+
+// Attributes returns, for each group with tracked procs, what its procs
+// have in common.
+func (g *Grouper) Attributes() map[string]GroupAttributes {
+	return g.tracker.Attributes()
+}
+
+// Ignored returns the IDs of procs that aren't being tracked.
+func (g *Grouper) Ignored() []ID {
+	return g.tracker.Ignored()
+}
+
+// NumEntries returns how many procs are currently tracked and ignored.
+func (g *Grouper) NumEntries() (tracked, ignored int) {
+	return g.tracker.NumEntries()
+}
+
+// Unreaped returns the number of zombies whose parent is tracked, by the
+// group of the parent, as of the last Update.
+func (g *Grouper) Unreaped() map[string]int {
+	return g.tracker.Unreaped()
+}
+
+// Orphans returns, for each group, how many of its procs have been adopted
+// by another proc after their tracked parent exited.
+func (g *Grouper) Orphans() map[string]Orphans {
+	return g.tracker.Orphans()
+}
+
+// Stats describes the work done by the most recent Update.
+func (g *Grouper) Stats() UpdateStats {
+	return g.tracker.Stats()
+}
+
+// ChecksAncestry returns true if new procs may be tracked because of their
+// parents, i.e. if children are tracked or a children policy is set, and so
+// whether Stats includes an AncestryTime.
+func (g *Grouper) ChecksAncestry() bool {
+	return g.tracker.checksAncestry()
+}
@@ -1,11 +1,13 @@
 package proc
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	common "github.com/ncabatoff/process-exporter"
 )
 
 type grouptest struct {
@@ -45,9 +47,9 @@ func TestGrouperBasic(t *testing.T) {
 	}{
 		{
 			[]IDInfo{
-				piinfost(p1, n1, Counts{1, 2, 3, 4, 5, 6, 0, 0}, Memory{7, 8, 0, 0, 0},
+				piinfost(p1, n1, Counts{1, 2, 3, 4, 5, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{7, 8, 0, 0, 0},
 					Filedesc{4, 400}, 2, States{Other: 1}),
-				piinfost(p2, n2, Counts{2, 3, 4, 5, 6, 7, 0, 0}, Memory{8, 9, 0, 0, 0},
+				piinfost(p2, n2, Counts{2, 3, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{8, 9, 0, 0, 0},
 					Filedesc{40, 400}, 3, States{Waiting: 1}),
 			},
 			GroupByName{
@@ -59,15 +61,15 @@ func TestGrouperBasic(t *testing.T) {
 		},
 		{
 			[]IDInfo{
-				piinfost(p1, n1, Counts{2, 3, 4, 5, 6, 7, 0, 0},
+				piinfost(p1, n1, Counts{2, 3, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 					Memory{6, 7, 0, 0, 0}, Filedesc{100, 400}, 4, States{Zombie: 1}),
-				piinfost(p2, n2, Counts{4, 5, 6, 7, 8, 9, 0, 0},
+				piinfost(p2, n2, Counts{4, 5, 6, 7, 8, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 					Memory{9, 8, 0, 0, 0}, Filedesc{400, 400}, 2, States{Running: 1}),
 			},
 			GroupByName{
-				"g1": Group{Counts{1, 1, 1, 1, 1, 1, 0, 0}, States{Zombie: 1}, msi{}, 1,
+				"g1": Group{Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, States{Zombie: 1}, msi{}, 1,
 					Memory{6, 7, 0, 0, 0}, starttime, 100, 0.25, 4, nil},
-				"g2": Group{Counts{2, 2, 2, 2, 2, 2, 0, 0}, States{Running: 1}, msi{}, 1,
+				"g2": Group{Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, States{Running: 1}, msi{}, 1,
 					Memory{9, 8, 0, 0, 0}, starttime, 400, 1, 2, nil},
 			},
 		},
@@ -95,7 +97,7 @@ func TestGrouperProcJoin(t *testing.T) {
 	}{
 		{
 			[]IDInfo{
-				piinfo(p1, n1, Counts{1, 2, 3, 4, 5, 6, 0, 0}, Memory{3, 4, 0, 0, 0}, Filedesc{4, 400}, 2),
+				piinfo(p1, n1, Counts{1, 2, 3, 4, 5, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{3, 4, 0, 0, 0}, Filedesc{4, 400}, 2),
 			},
 			GroupByName{
 				"g1": Group{Counts{}, States{}, msi{}, 1, Memory{3, 4, 0, 0, 0}, starttime, 4, 0.01, 2, nil},
@@ -105,24 +107,24 @@ func TestGrouperProcJoin(t *testing.T) {
 			// to counts starting with the second time we see a proc. Memory and FDs are
 			// affected though.
 			[]IDInfo{
-				piinfost(p1, n1, Counts{3, 4, 5, 6, 7, 8, 0, 0},
+				piinfost(p1, n1, Counts{3, 4, 5, 6, 7, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 					Memory{3, 4, 0, 0, 0}, Filedesc{4, 400}, 2, States{Running: 1}),
-				piinfost(p2, n2, Counts{1, 1, 1, 1, 1, 1, 0, 0},
+				piinfost(p2, n2, Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 					Memory{1, 2, 0, 0, 0}, Filedesc{40, 400}, 3, States{Sleeping: 1}),
 			},
 			GroupByName{
-				"g1": Group{Counts{2, 2, 2, 2, 2, 2, 0, 0}, States{Running: 1, Sleeping: 1}, msi{}, 2,
+				"g1": Group{Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, States{Running: 1, Sleeping: 1}, msi{}, 2,
 					Memory{4, 6, 0, 0, 0}, starttime, 44, 0.1, 5, nil},
 			},
 		}, {
 			[]IDInfo{
-				piinfost(p1, n1, Counts{4, 5, 6, 7, 8, 9, 0, 0},
+				piinfost(p1, n1, Counts{4, 5, 6, 7, 8, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 					Memory{1, 5, 0, 0, 0}, Filedesc{4, 400}, 2, States{Running: 1}),
-				piinfost(p2, n2, Counts{2, 2, 2, 2, 2, 2, 0, 0},
+				piinfost(p2, n2, Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 					Memory{2, 4, 0, 0, 0}, Filedesc{40, 400}, 3, States{Running: 1}),
 			},
 			GroupByName{
-				"g1": Group{Counts{4, 4, 4, 4, 4, 4, 0, 0}, States{Running: 2}, msi{}, 2,
+				"g1": Group{Counts{4, 4, 4, 4, 4, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, States{Running: 2}, msi{}, 2,
 					Memory{3, 9, 0, 0, 0}, starttime, 44, 0.1, 5, nil},
 			},
 		},
@@ -150,23 +152,23 @@ func TestGrouperNonDecreasing(t *testing.T) {
 	}{
 		{
 			[]IDInfo{
-				piinfo(p1, n1, Counts{3, 4, 5, 6, 7, 8, 0, 0}, Memory{3, 4, 0, 0, 0}, Filedesc{4, 400}, 2),
-				piinfo(p2, n2, Counts{1, 1, 1, 1, 1, 1, 0, 0}, Memory{1, 2, 0, 0, 0}, Filedesc{40, 400}, 3),
+				piinfo(p1, n1, Counts{3, 4, 5, 6, 7, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{3, 4, 0, 0, 0}, Filedesc{4, 400}, 2),
+				piinfo(p2, n2, Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{1, 2, 0, 0, 0}, Filedesc{40, 400}, 3),
 			},
 			GroupByName{
 				"g1": Group{Counts{}, States{}, msi{}, 2, Memory{4, 6, 0, 0, 0}, starttime, 44, 0.1, 5, nil},
 			},
 		}, {
 			[]IDInfo{
-				piinfo(p1, n1, Counts{4, 5, 6, 7, 8, 9, 0, 0}, Memory{1, 5, 0, 0, 0}, Filedesc{4, 400}, 2),
+				piinfo(p1, n1, Counts{4, 5, 6, 7, 8, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{1, 5, 0, 0, 0}, Filedesc{4, 400}, 2),
 			},
 			GroupByName{
-				"g1": Group{Counts{1, 1, 1, 1, 1, 1, 0, 0}, States{}, msi{}, 1, Memory{1, 5, 0, 0, 0}, starttime, 4, 0.01, 2, nil},
+				"g1": Group{Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, States{}, msi{}, 1, Memory{1, 5, 0, 0, 0}, starttime, 4, 0.01, 2, nil},
 			},
 		}, {
 			[]IDInfo{},
 			GroupByName{
-				"g1": Group{Counts{1, 1, 1, 1, 1, 1, 0, 0}, States{}, nil, 0, Memory{}, time.Time{}, 0, 0, 0, nil},
+				"g1": Group{Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, States{}, nil, 0, Memory{}, time.Time{}, 0, 0, 0, nil},
 			},
 		},
 	}
@@ -180,6 +182,43 @@ func TestGrouperNonDecreasing(t *testing.T) {
 	}
 }
 
+// TestGrouperLatest verifies that Latest reports what the counts of each
+// group with running procs increased by in the last Update.
+func TestGrouperLatest(t *testing.T) {
+	p1, p2 := 1, 2
+	n1 := "g1"
+
+	tests := []struct {
+		procs []IDInfo
+		want  map[string]Delta
+	}{
+		{
+			[]IDInfo{
+				piinfo(p1, n1, Counts{3, 4, 5, 6, 7, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{}, Filedesc{}, 1),
+				piinfo(p2, n1, Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{}, Filedesc{}, 1),
+			},
+			map[string]Delta{"g1": {}},
+		}, {
+			[]IDInfo{
+				piinfo(p1, n1, Counts{4, 5, 6, 7, 8, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{}, Filedesc{}, 1),
+				piinfo(p2, n1, Counts{3, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{}, Filedesc{}, 1),
+			},
+			map[string]Delta{"g1": {3, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		}, {
+			[]IDInfo{},
+			map[string]Delta{},
+		},
+	}
+
+	gr := NewGrouper(newNamer(n1), false, false, false, false)
+	for i, tc := range tests {
+		rungroup(t, gr, procInfoIter(tc.procs...))
+		if diff := cmp.Diff(gr.Latest(), tc.want); diff != "" {
+			t.Errorf("%d: latest differs: (-got +want)\n%s", i, diff)
+		}
+	}
+}
+
 func TestGrouperThreads(t *testing.T) {
 	p, n, tm := 1, "g1", time.Unix(0, 0).UTC()
 
@@ -189,8 +228,8 @@ func TestGrouperThreads(t *testing.T) {
 	}{
 		{
 			piinfot(p, n, Counts{}, Memory{}, Filedesc{1, 1}, []Thread{
-				{ThreadID(ID{p, 0}), "t1", Counts{1, 2, 3, 4, 5, 6, 0, 0}, "", States{}},
-				{ThreadID(ID{p + 1, 0}), "t2", Counts{1, 1, 1, 1, 1, 1, 0, 0}, "", States{}},
+				{ThreadID(ID{p, 0}), "t1", Counts{1, 2, 3, 4, 5, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 1, 0}), "t2", Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
 			}),
 			GroupByName{
 				"g1": Group{Counts{}, States{}, msi{}, 1, Memory{}, tm, 1, 1, 2, []Threads{
@@ -200,24 +239,24 @@ func TestGrouperThreads(t *testing.T) {
 			},
 		}, {
 			piinfot(p, n, Counts{}, Memory{}, Filedesc{1, 1}, []Thread{
-				{ThreadID(ID{p, 0}), "t1", Counts{2, 3, 4, 5, 6, 7, 0, 0}, "", States{}},
-				{ThreadID(ID{p + 1, 0}), "t2", Counts{2, 2, 2, 2, 2, 2, 0, 0}, "", States{}},
-				{ThreadID(ID{p + 2, 0}), "t2", Counts{1, 1, 1, 1, 1, 1, 0, 0}, "", States{}},
+				{ThreadID(ID{p, 0}), "t1", Counts{2, 3, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 1, 0}), "t2", Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 2, 0}), "t2", Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
 			}),
 			GroupByName{
 				"g1": Group{Counts{}, States{}, msi{}, 1, Memory{}, tm, 1, 1, 3, []Threads{
-					Threads{"t1", 1, Counts{1, 1, 1, 1, 1, 1, 0, 0}},
-					Threads{"t2", 2, Counts{1, 1, 1, 1, 1, 1, 0, 0}},
+					Threads{"t1", 1, Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+					Threads{"t2", 2, Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
 				}},
 			},
 		}, {
 			piinfot(p, n, Counts{}, Memory{}, Filedesc{1, 1}, []Thread{
-				{ThreadID(ID{p + 1, 0}), "t2", Counts{4, 4, 4, 4, 4, 4, 0, 0}, "", States{}},
-				{ThreadID(ID{p + 2, 0}), "t2", Counts{2, 3, 4, 5, 6, 7, 0, 0}, "", States{}},
+				{ThreadID(ID{p + 1, 0}), "t2", Counts{4, 4, 4, 4, 4, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 2, 0}), "t2", Counts{2, 3, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
 			}),
 			GroupByName{
 				"g1": Group{Counts{}, States{}, msi{}, 1, Memory{}, tm, 1, 1, 2, []Threads{
-					Threads{"t2", 2, Counts{4, 5, 6, 7, 8, 9, 0, 0}},
+					Threads{"t2", 2, Counts{4, 5, 6, 7, 8, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
 				}},
 			},
 		},
@@ -232,3 +271,263 @@ func TestGrouperThreads(t *testing.T) {
 		}
 	}
 }
+
+// threadPolicy implements ThreadPolicy for testing, stripping trailing digits
+// from thread names and capping them at max.
+type threadPolicy struct {
+	track bool
+	max   int
+}
+
+func (tp threadPolicy) TrackThreads(int) (bool, bool) { return tp.track, true }
+
+func (tp threadPolicy) ThreadName(_ int, tname string) string {
+	return strings.TrimRight(tname, "0123456789")
+}
+
+func (tp threadPolicy) MaxThreadNames(int) int { return tp.max }
+
+// TestGrouperThreadPolicy verifies that thread names are rewritten before
+// aggregation, and that names beyond the limit are aggregated as "other".
+func TestGrouperThreadPolicy(t *testing.T) {
+	p, n, tm := 1, "g1", time.Unix(0, 0).UTC()
+
+	tests := []struct {
+		proc IDInfo
+		want GroupByName
+	}{
+		{
+			piinfot(p, n, Counts{}, Memory{}, Filedesc{1, 1}, []Thread{
+				{ThreadID(ID{p, 0}), "b1", Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 1, 0}), "b2", Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 2, 0}), "c", Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+			}),
+			GroupByName{
+				"g1": Group{Counts{}, States{}, msi{}, 1, Memory{}, tm, 1, 1, 3, []Threads{
+					Threads{"b", 2, Counts{}},
+					Threads{"other", 1, Counts{}},
+				}},
+			},
+		}, {
+			// "a" sorts before "b", but "b" was reported before so it's kept.
+			piinfot(p, n, Counts{}, Memory{}, Filedesc{1, 1}, []Thread{
+				{ThreadID(ID{p, 0}), "b1", Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 1, 0}), "b2", Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 2, 0}), "c", Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 3, 0}), "a", Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+			}),
+			GroupByName{
+				"g1": Group{Counts{}, States{}, msi{}, 1, Memory{}, tm, 1, 1, 4, []Threads{
+					Threads{"b", 2, Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+					Threads{"other", 2, Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				}},
+			},
+		},
+	}
+
+	opts := cmpopts.SortSlices(lessThreads)
+	gr := NewGrouper(newNamer(n), false, false, false, false)
+	gr.SetThreadPolicy(threadPolicy{track: true, max: 1})
+	for i, tc := range tests {
+		got := rungroup(t, gr, procInfoIter(tc.proc))
+		if diff := cmp.Diff(got, tc.want, opts); diff != "" {
+			t.Errorf("%d: curgroups differs: (-got +want)\n%s", i, diff)
+		}
+	}
+}
+
+// TestGrouperNoLimits verifies that procs whose fd limit isn't known don't
+// make the worst fd ratio of their group NaN or infinite.
+func TestGrouperNoLimits(t *testing.T) {
+	gr := NewGrouper(newNamer("g1"), false, false, false, false)
+	got := rungroup(t, gr, procInfoIter(
+		piinfo(1, "g1", Counts{}, Memory{}, Filedesc{4, 0}, 1),
+		piinfo(2, "g1", Counts{}, Memory{}, Filedesc{0, 0}, 1),
+	))
+	if ratio := got["g1"].WorstFDratio; ratio != 0 {
+		t.Errorf("got worst fd ratio %v, want 0", ratio)
+	}
+}
+
+// commRules implements common.RuleMatchNamer for testing, giving procs the
+// group name and rule of their comm.
+type commRules map[string]struct {
+	name string
+	rule int
+}
+
+func (cr commRules) String() string { return "commRules" }
+
+func (cr commRules) MatchAndName(nacl common.ProcAttributes) (bool, string) {
+	matched, name, _ := cr.MatchAndNameRule(nacl)
+	return matched, name
+}
+
+func (cr commRules) MatchAndNameRule(nacl common.ProcAttributes) (bool, string, int) {
+	if r, ok := cr[nacl.Name]; ok {
+		return true, r.name, r.rule
+	}
+	return false, "", common.NoRule
+}
+
+// TestGrouperRules verifies that each group gets the rule of its procs, the
+// lowest when rules share a group name, that suffixed child groups get the
+// rule of their ancestor, and that a group keeps its rule once its procs exit.
+func TestGrouperRules(t *testing.T) {
+	cr := commRules{
+		"p1": {"g1", 0},
+		"p2": {"g2", 1},
+		"p3": {"g2", 2},
+	}
+	gr := NewGrouper(cr, true, false, false, false)
+	gr.SetChildrenPolicy(childrenPolicy{inherit: map[int]bool{0: true}, suffix: true})
+
+	rungroup(t, gr, procInfoIter(
+		newProcParent(1, "p1", 0),
+		newProcParent(2, "c2", 1),
+		newProcParent(3, "p3", 0),
+		newProcParent(4, "p2", 0),
+	))
+	want := map[string]int{"g1": 0, "g1/child:c2": 0, "g2": 1, "g3": common.NoRule}
+	got := make(map[string]int)
+	for gname := range want {
+		got[gname] = gr.GroupRule(gname)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("rules differ: (-got +want)\n%s", diff)
+	}
+
+	rungroup(t, gr, procInfoIter(newProcParent(3, "p3", 0)))
+	if rule := gr.GroupRule("g2"); rule != 2 {
+		t.Errorf("got rule %d for g2, want 2", rule)
+	}
+	rungroup(t, gr, procInfoIter())
+	if rule := gr.GroupRule("g2"); rule != 2 {
+		t.Errorf("got rule %d for group without procs, want 2", rule)
+	}
+}
+
+type topKPolicy int
+
+func (tk topKPolicy) TopK(int) int { return int(tk) }
+
+// TestGrouperTopProcs verifies that the busiest procs of a group are reported
+// in descending order, and that CPU usage is only reported once known.
+func TestGrouperTopProcs(t *testing.T) {
+	n := "g1"
+	gr := NewGrouper(newNamer(n), false, false, false, false)
+	gr.SetTopKPolicy(topKPolicy(2))
+
+	rungroup(t, gr, procInfoIter(
+		piinfo(1, n, Counts{}, Memory{ResidentBytes: 10}, Filedesc{}, 1),
+		piinfo(2, n, Counts{}, Memory{ResidentBytes: 30}, Filedesc{}, 1),
+		piinfo(3, n, Counts{}, Memory{ResidentBytes: 20}, Filedesc{}, 1),
+	))
+	top := gr.TopProcs()[n]
+	if len(top.CPU) != 0 {
+		t.Errorf("got %d procs by CPU before CPU usage is known, want 0", len(top.CPU))
+	}
+	want := []TopProc{{2, "[g1]", 30}, {3, "[g1]", 20}}
+	if diff := cmp.Diff(top.Resident, want); diff != "" {
+		t.Errorf("top procs by resident memory differ: (-got +want)\n%s", diff)
+	}
+
+	rungroup(t, gr, procInfoIter(
+		piinfo(1, n, Counts{CPUUserTime: 3}, Memory{ResidentBytes: 10}, Filedesc{}, 1),
+		piinfo(2, n, Counts{CPUUserTime: 1}, Memory{ResidentBytes: 30}, Filedesc{}, 1),
+		piinfo(3, n, Counts{CPUSystemTime: 2}, Memory{ResidentBytes: 20}, Filedesc{}, 1),
+	))
+	top = gr.TopProcs()[n]
+	var gotPids []int
+	for _, tp := range top.CPU {
+		gotPids = append(gotPids, tp.Pid)
+	}
+	if diff := cmp.Diff(gotPids, []int{1, 3}); diff != "" {
+		t.Errorf("top procs by CPU differ: (-got +want)\n%s", diff)
+	}
+}
+
+// TestGrouperTopUnmatched verifies that unmatched procs are accounted in the
+// catch-all group and that their comms are ranked by total resource usage.
+func TestGrouperTopUnmatched(t *testing.T) {
+	n := "g1"
+	gr := NewGrouper(newNamer(n), false, false, false, false)
+	gr.SetCatchAll("other", 2)
+
+	got := rungroup(t, gr, procInfoIter(
+		piinfo(1, n, Counts{}, Memory{ResidentBytes: 100}, Filedesc{}, 1),
+		piinfo(2, "a", Counts{}, Memory{ResidentBytes: 10}, Filedesc{}, 1),
+		piinfo(3, "a", Counts{}, Memory{ResidentBytes: 15}, Filedesc{}, 1),
+		piinfo(4, "b", Counts{}, Memory{ResidentBytes: 20}, Filedesc{}, 1),
+		piinfo(5, "c", Counts{}, Memory{ResidentBytes: 5}, Filedesc{}, 1),
+	))
+	if got["other"].Procs != 4 {
+		t.Errorf("got %d procs in catch-all group, want 4", got["other"].Procs)
+	}
+	want := []TopComm{{"a", 2, 25}, {"b", 1, 20}}
+	if diff := cmp.Diff(gr.TopUnmatched().Resident, want); diff != "" {
+		t.Errorf("top unmatched comms by resident memory differ: (-got +want)\n%s", diff)
+	}
+}
+
+// TestGrouperPlacement verifies that threads are counted by CPU and NUMA
+// node, and procs by allowed CPUs.
+func TestGrouperPlacement(t *testing.T) {
+	n := "g1"
+	gr := NewGrouper(newNamer(n), false, false, false, false)
+	gr.SetPlacement(map[int]int{0: 0, 1: 0, 2: 1, 3: 1})
+
+	withPlacement := func(idinfo IDInfo, p Placement) IDInfo {
+		idinfo.Placement = p
+		return idinfo
+	}
+	rungroup(t, gr, procInfoIter(
+		withPlacement(piinfot(1, n, Counts{}, Memory{}, Filedesc{}, []Thread{
+			{ThreadID: ThreadID(ID{1, 0}), ThreadName: "t1", Processor: 0},
+			{ThreadID: ThreadID(ID{2, 0}), ThreadName: "t2", Processor: 2},
+		}), Placement{CPUsAllowed: "0-3", MemsAllowed: "0-1"}),
+		withPlacement(piinfo(3, n, Counts{}, Memory{}, Filedesc{}, 1),
+			Placement{CPUsAllowed: "2", MemsAllowed: "1", Processor: 2, NUMAMemory: map[int]uint64{1: 4096}}),
+	))
+
+	want := GroupPlacement{
+		CPUsAllowed: map[string]int{"0-3": 1, "2": 1},
+		MemsAllowed: map[string]int{"0-1": 1, "1": 1},
+		ThreadCPUs:  map[int]int{0: 1, 2: 2},
+		ThreadNodes: map[int]int{0: 1, 1: 2},
+		NUMAMemory:  map[int]uint64{1: 4096},
+	}
+	if diff := cmp.Diff(gr.Placement()[n], want); diff != "" {
+		t.Errorf("placement differs: (-got +want)\n%s", diff)
+	}
+}
+
+// TestGrouperScheduling verifies that threads are counted by scheduling
+// policy and that the nice range spans all threads of the group.
+func TestGrouperScheduling(t *testing.T) {
+	n := "g1"
+	gr := NewGrouper(newNamer(n), false, false, false, false)
+	gr.SetScheduling()
+
+	withScheduling := func(idinfo IDInfo, s Scheduling) IDInfo {
+		idinfo.Scheduling = s
+		return idinfo
+	}
+	rungroup(t, gr, procInfoIter(
+		piinfot(1, n, Counts{}, Memory{}, Filedesc{}, []Thread{
+			{ThreadID: ThreadID(ID{1, 0}), ThreadName: "t1", Policy: SchedFIFO, Nice: 0},
+			{ThreadID: ThreadID(ID{2, 0}), ThreadName: "t2", Policy: SchedOther, Nice: -5},
+		}),
+		withScheduling(piinfo(3, n, Counts{}, Memory{}, Filedesc{}, 1),
+			Scheduling{Policy: SchedBatch, Nice: 10}),
+	))
+
+	want := GroupScheduling{
+		ThreadPolicies: map[uint]int{SchedOther: 1, SchedFIFO: 1, SchedBatch: 1},
+		MinNice:        -5,
+		MaxNice:        10,
+	}
+	if diff := cmp.Diff(gr.Scheduling()[n], want); diff != "" {
+		t.Errorf("scheduling differs: (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,103 @@
+package proc
+
+import "fmt"
+
+type (
+	// HostCPU is the CPU time spent by all CPUs of the host since boot, in
+	// seconds, by mode.
+	HostCPU struct {
+		User    float64
+		Nice    float64
+		System  float64
+		Idle    float64
+		Iowait  float64
+		IRQ     float64
+		SoftIRQ float64
+		Steal   float64
+	}
+
+	// HostMemory describes the memory of the host, in bytes.
+	HostMemory struct {
+		Total     uint64
+		Available uint64
+		// Free is the memory not used at all, not even by buffers and the
+		// page cache.
+		Free uint64
+	}
+
+	// HostStats describes whole-host resource usage, as read from /proc/stat
+	// and /proc/meminfo.
+	HostStats struct {
+		CPU    HostCPU
+		Memory HostMemory
+	}
+
+	// HostStatter is implemented by sources that can report whole-host
+	// resource usage, e.g. FS.
+	HostStatter interface {
+		HostStats() (HostStats, error)
+	}
+)
+
+// ProcessTime returns the CPU time spent running processes, i.e. the time
+// that may be attributed to some proc's user or system time.
+func (c HostCPU) ProcessTime() float64 {
+	return c.User + c.Nice + c.System
+}
+
+// InUse returns the memory that isn't free, including buffers and the page
+// cache, which holds the file-backed part of procs' resident memory.
+func (m HostMemory) InUse() uint64 {
+	if m.Free > m.Total {
+		return 0
+	}
+	return m.Total - m.Free
+}
+
+// HostStats implements HostStatter.
+func (fs *FS) HostStats() (HostStats, error) {
+	stat, err := fs.FS.Stat()
+	if err != nil {
+		return HostStats{}, fmt.Errorf("error reading stat: %v", err)
+	}
+	meminfo, err := fs.FS.Meminfo()
+	if err != nil {
+		return HostStats{}, fmt.Errorf("error reading meminfo: %v", err)
+	}
+
+	cpu := stat.CPUTotal
+	hs := HostStats{
+		CPU: HostCPU{
+			User:    cpu.User,
+			Nice:    cpu.Nice,
+			System:  cpu.System,
+			Idle:    cpu.Idle,
+			Iowait:  cpu.Iowait,
+			IRQ:     cpu.IRQ,
+			SoftIRQ: cpu.SoftIRQ,
+			Steal:   cpu.Steal,
+		},
+	}
+	// meminfo values are in kB.
+	if meminfo.MemTotal != nil {
+		hs.Memory.Total = *meminfo.MemTotal * 1024
+	}
+	if meminfo.MemFree != nil {
+		hs.Memory.Free = *meminfo.MemFree * 1024
+	}
+	switch {
+	case meminfo.MemAvailable != nil:
+		hs.Memory.Available = *meminfo.MemAvailable * 1024
+	case meminfo.MemFree != nil:
+		// Kernels older than 3.14 don't report MemAvailable.
+		avail := *meminfo.MemFree
+		if meminfo.Buffers != nil {
+			avail += *meminfo.Buffers
+		}
+		if meminfo.Cached != nil {
+			avail += *meminfo.Cached
+		}
+		hs.Memory.Available = avail * 1024
+	}
+	return hs, nil
+}
@@ -0,0 +1,70 @@
+package proc
+
+// GroupPlacement describes where the procs of a group run and may run.
+type GroupPlacement struct {
+	// CPUsAllowed and MemsAllowed count procs by the list of CPUs and NUMA
+	// nodes they may use, e.g. "0-3,8".
+	CPUsAllowed map[string]int
+	MemsAllowed map[string]int
+	// ThreadCPUs counts threads by the CPU they last ran on.
+	ThreadCPUs map[int]int
+	// ThreadNodes counts threads by the NUMA node of the CPU they last ran
+	// on.  It's nil if the NUMA topology is unknown.
+	ThreadNodes map[int]int
+	// NUMAMemory is the memory mapped on each NUMA node in bytes.  It's
+	// empty unless numa_maps are gathered.
+	NUMAMemory map[int]uint64
+}
+
+// SetPlacement makes the grouper track where the procs of each group run.
+// cpuNodes maps each CPU to its NUMA node; if nil, threads aren't counted by
+// node.
+func (g *Grouper) SetPlacement(cpuNodes map[int]int) {
+	g.placement = make(map[string]GroupPlacement)
+	g.cpuNodes = cpuNodes
+	g.tracker.threadCPUs = true
+}
+
+// Placement returns where the procs of each group run as of the last Update,
+// or nil if SetPlacement wasn't called.
+func (g *Grouper) Placement() map[string]GroupPlacement {
+	return g.placement
+}
+
+// updatePlacement recomputes the placement of each group from the tracker
+// state.
+func (g *Grouper) updatePlacement() {
+	g.placement = make(map[string]GroupPlacement)
+	for _, tproc := range g.tracker.tracked {
+		gp, ok := g.placement[tproc.groupName]
+		if !ok {
+			gp = GroupPlacement{
+				CPUsAllowed: make(map[string]int),
+				MemsAllowed: make(map[string]int),
+				ThreadCPUs:  make(map[int]int),
+				NUMAMemory:  make(map[int]uint64),
+			}
+			if g.cpuNodes != nil {
+				gp.ThreadNodes = make(map[int]int)
+			}
+			g.placement[tproc.groupName] = gp
+		}
+
+		p := tproc.metrics.Placement
+		if p.CPUsAllowed != "" {
+			gp.CPUsAllowed[p.CPUsAllowed]++
+		}
+		if p.MemsAllowed != "" {
+			gp.MemsAllowed[p.MemsAllowed]++
+		}
+		for cpu, n := range p.ThreadCPUs {
+			gp.ThreadCPUs[cpu] += n
+			if node, ok := g.cpuNodes[cpu]; ok {
+				gp.ThreadNodes[node] += n
+			}
+		}
+		for node, bytes := range p.NUMAMemory {
+			gp.NUMAMemory[node] += bytes
+		}
+	}
+}
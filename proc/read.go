@@ -2,9 +2,11 @@ package proc
 
 import (
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/procfs"
@@ -36,6 +38,30 @@ type (
 		EffectiveUID int
 	}
 
+	// Identity is what changes when a proc execs a new program, as read
+	// from stat.
+	Identity struct {
+		// Name is the proc's comm.
+		Name string
+		// StartCode, EndCode and StartStack are the addresses of the program
+		// text and the bottom of the stack, which are laid out anew on
+		// exec.  They're 0 if unknown, e.g. for other users' procs when not
+		// running as root.
+		StartCode, EndCode, StartStack uint64
+	}
+
+	// Program is the program a proc runs, to tell an exec from a mere
+	// change of name.
+	Program struct {
+		// Exe is the path of the program, the target of the exe link,
+		// without the " (deleted)" the kernel appends once it's replaced on
+		// disk.  It's empty if unknown, under the same conditions as the
+		// addresses of Identity, in which case CmdlineHash, a hash of the
+		// cmdline, is set instead.  Both are unset if neither is known.
+		Exe         string
+		CmdlineHash uint64
+	}
+
 	// Counts are metric counters common to threads and processes and groups.
 	Counts struct {
 		CPUUserTime           float64
@@ -46,6 +72,26 @@ type (
 		MinorPageFaults       uint64
 		CtxSwitchVoluntary    uint64
 		CtxSwitchNonvoluntary uint64
+		// CPUChildrenUserTime and CPUChildrenSystemTime are the CPU times of
+		// children that exited and were waited for.
+		CPUChildrenUserTime   float64
+		CPUChildrenSystemTime float64
+		// CPUGuestTime is the part of CPUUserTime spent running a virtual
+		// CPU for a guest operating system.
+		CPUGuestTime float64
+		// BlockIODelay is the time spent waiting for block I/O to complete.
+		BlockIODelay float64
+		// ReadChars and WriteChars are the bytes passed to read and write
+		// syscalls, whether or not they were served from the page cache.
+		ReadChars  uint64
+		WriteChars uint64
+		// ReadSyscalls and WriteSyscalls are the number of read and write
+		// syscalls.
+		ReadSyscalls  uint64
+		WriteSyscalls uint64
+		// CancelledWriteBytes is the part of WriteBytes that was never
+		// written due to truncation.
+		CancelledWriteBytes uint64
 	}
 
 	// Memory describes a proc's memory usage.
@@ -72,6 +118,14 @@ type (
 		Waiting  int
 		Zombie   int
 		Other    int
+		// Stopped is stopped by a signal (T), Traced is stopped by a
+		// debugger (t), Idle is an idle kernel thread (I), Dead is exiting
+		// (X), and Parked is a parked kernel thread (P).
+		Stopped int
+		Traced  int
+		Idle    int
+		Dead    int
+		Parked  int
 	}
 
 	// Metrics contains data read from /proc/pid/*
@@ -82,6 +136,41 @@ type (
 		NumThreads uint64
 		States
 		Wchan string
+		Placement
+		Scheduling
+	}
+
+	// Scheduling describes how a proc is scheduled.
+	Scheduling struct {
+		// Policy is the scheduling policy of the proc (or thread), e.g.
+		// SchedFIFO.
+		Policy uint
+		// Nice is the nice value of the proc (or thread).
+		Nice int
+		// ThreadPolicies counts the threads of the proc by policy, and
+		// MinNice and MaxNice bound their nice values.  They're filled in
+		// by the Tracker, ThreadPolicies only when the Grouper tracks
+		// scheduling.
+		ThreadPolicies map[uint]int
+		MinNice        int
+		MaxNice        int
+	}
+
+	// Placement describes where a proc runs and may run.
+	Placement struct {
+		// CPUsAllowed and MemsAllowed are the CPUs and NUMA nodes the proc
+		// may use, in list format, e.g. "0-3,8".
+		CPUsAllowed string
+		MemsAllowed string
+		// Processor is the CPU the proc (or thread) last ran on.
+		Processor int
+		// ThreadCPUs is the number of threads of the proc that last ran on
+		// each CPU.  It's filled in by the Tracker when the Grouper tracks
+		// placement.
+		ThreadCPUs map[int]int
+		// NUMAMemory is the memory mapped on each NUMA node in bytes, if
+		// gathered.
+		NUMAMemory map[int]uint64
 	}
 
 	// Thread contains per-thread data.
@@ -91,6 +180,11 @@ type (
 		Counts
 		Wchan string
 		States
+		// Processor is the CPU the thread last ran on.
+		Processor int
+		// Policy and Nice describe how the thread is scheduled.
+		Policy uint
+		Nice   int
 	}
 
 	// IDInfo groups all info for a single process.
@@ -118,12 +212,21 @@ type (
 		// GetStatic() returns various details read from files under /proc/<pid>/.  Technically
 		// name may not be static, but we'll pretend it is.
 		GetStatic() (Static, error)
+		// GetIdentity() returns what changes when the proc execs, so that
+		// its static details can be reread only when needed.
+		GetIdentity() (Identity, error)
+		// GetProgram() returns the program the proc runs.  Unlike
+		// GetIdentity it reads more than stat, so it's only meant to be
+		// called for new procs and when the identity changes.
+		GetProgram() (Program, error)
 		// GetMetrics() returns various metrics read from files under /proc/<pid>/.
 		// It returns an error on complete failure.  Otherwise, it returns metrics
 		// and 0 on complete success, 1 if some (like I/O) couldn't be read.
 		GetMetrics() (Metrics, int, error)
 		GetStates() (States, error)
 		GetWchan() (string, error)
+		GetPlacement() (Placement, error)
+		GetScheduling() (Scheduling, error)
 		GetCounts() (Counts, int, error)
 		GetThreads() ([]Thread, error)
 	}
@@ -133,9 +236,10 @@ type (
 	proccache struct {
 		procfs.Proc
 		procid  *ID
-		stat    *procfs.ProcStat
-		status  *procfs.ProcStatus
+		stat    *procStat
+		status  *procStatus
 		cmdline []string
+		program *Program
 		cgroups []procfs.Cgroup
 		io      *procfs.ProcIO
 		fs      *FS
@@ -152,6 +256,10 @@ type (
 		length() int
 	}
 
+	// procIDInfos implements procs using a slice of already
+	// populated IDInfo.
+	procIDInfos []IDInfo
+
 	// procfsprocs implements procs using procfs.
 	procfsprocs struct {
 		Procs []procfs.Proc
@@ -168,6 +276,15 @@ type (
 		Proc
 	}
 
+	// TimedIter is implemented by Iters over procs as they were at some
+	// time other than when they're read, e.g. those of a Replay.  The
+	// Tracker and Grouper use that time rather than the current one.
+	TimedIter interface {
+		Iter
+		// Time returns the time the procs were read at.
+		Time() time.Time
+	}
+
 	// procIterator implements the Iter interface
 	procIterator struct {
 		// procs is the list of Proc we're iterating over.
@@ -194,7 +311,20 @@ type (
 		BootTime    uint64
 		MountPoint  string
 		GatherSMaps bool
-		debug       bool
+		// GatherFDs, GatherLimits, GatherWchan and GatherIO control whether
+		// we read the open fd count, fd limit, wchan and I/O counters
+		// respectively.  They're all true by default.
+		GatherFDs    bool
+		GatherLimits bool
+		GatherWchan  bool
+		GatherIO     bool
+		// GatherNUMAMaps controls whether we read numa_maps to find the
+		// memory of each proc on each NUMA node.  It's false by default.
+		GatherNUMAMaps bool
+		debug          bool
+		// reads counts the files read under /proc/<pid>, by file name.  It is
+		// shared with the FS used to read each proc's threads.
+		reads map[string]uint64
 	}
 )
 
@@ -212,6 +342,15 @@ func (c *Counts) Add(c2 Delta) {
 	c.MinorPageFaults += c2.MinorPageFaults
 	c.CtxSwitchVoluntary += c2.CtxSwitchVoluntary
 	c.CtxSwitchNonvoluntary += c2.CtxSwitchNonvoluntary
+	c.CPUChildrenUserTime += c2.CPUChildrenUserTime
+	c.CPUChildrenSystemTime += c2.CPUChildrenSystemTime
+	c.CPUGuestTime += c2.CPUGuestTime
+	c.BlockIODelay += c2.BlockIODelay
+	c.ReadChars += c2.ReadChars
+	c.WriteChars += c2.WriteChars
+	c.ReadSyscalls += c2.ReadSyscalls
+	c.WriteSyscalls += c2.WriteSyscalls
+	c.CancelledWriteBytes += c2.CancelledWriteBytes
 }
 
 // Sub subtracts c2 from the counts.
@@ -224,6 +363,15 @@ func (c Counts) Sub(c2 Counts) Delta {
 	c.MinorPageFaults -= c2.MinorPageFaults
 	c.CtxSwitchVoluntary -= c2.CtxSwitchVoluntary
 	c.CtxSwitchNonvoluntary -= c2.CtxSwitchNonvoluntary
+	c.CPUChildrenUserTime -= c2.CPUChildrenUserTime
+	c.CPUChildrenSystemTime -= c2.CPUChildrenSystemTime
+	c.CPUGuestTime -= c2.CPUGuestTime
+	c.BlockIODelay -= c2.BlockIODelay
+	c.ReadChars -= c2.ReadChars
+	c.WriteChars -= c2.WriteChars
+	c.ReadSyscalls -= c2.ReadSyscalls
+	c.WriteSyscalls -= c2.WriteSyscalls
+	c.CancelledWriteBytes -= c2.CancelledWriteBytes
 	return Delta(c)
 }
 
@@ -233,6 +381,11 @@ func (s *States) Add(s2 States) {
 	s.Sleeping += s2.Sleeping
 	s.Waiting += s2.Waiting
 	s.Zombie += s2.Zombie
+	s.Stopped += s2.Stopped
+	s.Traced += s2.Traced
+	s.Idle += s2.Idle
+	s.Dead += s2.Dead
+	s.Parked += s2.Parked
 }
 
 func (p IDInfo) GetThreads() ([]Thread, error) {
@@ -254,6 +407,25 @@ func (p IDInfo) GetStatic() (Static, error) {
 	return p.Static, nil
 }
 
+// iterTime returns the time the procs of iter were read at: that given by
+// iter if it's a TimedIter, or the current time otherwise.
+func iterTime(iter Iter) time.Time {
+	if ti, ok := iter.(TimedIter); ok {
+		return ti.Time()
+	}
+	return time.Now()
+}
+
+// GetIdentity implements Proc.  Only the name is known.
+func (p IDInfo) GetIdentity() (Identity, error) {
+	return Identity{Name: p.Name}, nil
+}
+
+// GetProgram implements Proc.  The program isn't known.
+func (p IDInfo) GetProgram() (Program, error) {
+	return Program{}, nil
+}
+
 // GetCounts implements Proc.
 func (p IDInfo) GetCounts() (Counts, int, error) {
 	return p.Metrics.Counts, 0, nil
@@ -273,15 +445,26 @@ func (p IDInfo) GetWchan() (string, error) {
 	return p.Wchan, nil
 }
 
+// GetPlacement implements Proc.
+func (p IDInfo) GetPlacement() (Placement, error) {
+	return p.Placement, nil
+}
+
+// GetScheduling implements Proc.
+func (p IDInfo) GetScheduling() (Scheduling, error) {
+	return p.Scheduling, nil
+}
+
 func (p *proccache) GetPid() int {
 	return p.Proc.PID
 }
 
-func (p *proccache) getStat() (procfs.ProcStat, error) {
+func (p *proccache) getStat() (procStat, error) {
 	if p.stat == nil {
-		stat, err := p.Proc.NewStat()
+		p.fs.countRead("stat")
+		stat, err := readStat(filepath.Join(p.fs.MountPoint, strconv.Itoa(p.PID), "stat"), p.PID)
 		if err != nil {
-			return procfs.ProcStat{}, err
+			return procStat{}, err
 		}
 		p.stat = &stat
 	}
@@ -289,11 +472,12 @@ func (p *proccache) getStat() (procfs.ProcStat, error) {
 	return *p.stat, nil
 }
 
-func (p *proccache) getStatus() (procfs.ProcStatus, error) {
+func (p *proccache) getStatus() (procStatus, error) {
 	if p.status == nil {
-		status, err := p.Proc.NewStatus()
+		p.fs.countRead("status")
+		status, err := readStatus(filepath.Join(p.fs.MountPoint, strconv.Itoa(p.PID), "status"), p.PID)
 		if err != nil {
-			return procfs.ProcStatus{}, err
+			return procStatus{}, err
 		}
 		p.status = &status
 	}
@@ -303,6 +487,7 @@ func (p *proccache) getStatus() (procfs.ProcStatus, error) {
 
 func (p *proccache) getCgroups() ([]procfs.Cgroup, error) {
 	if p.cgroups == nil {
+		p.fs.countRead("cgroup")
 		cgroups, err := p.Proc.Cgroups()
 		if err != nil {
 			return nil, err
@@ -326,8 +511,45 @@ func (p *proccache) GetProcID() (ID, error) {
 	return *p.procid, nil
 }
 
+// GetIdentity implements Proc.
+func (p *proccache) GetIdentity() (Identity, error) {
+	stat, err := p.getStat()
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{
+		Name:       stat.Comm,
+		StartCode:  stat.StartCode,
+		EndCode:    stat.EndCode,
+		StartStack: stat.StartStack,
+	}, nil
+}
+
+// GetProgram implements Proc.
+func (p *proccache) GetProgram() (Program, error) {
+	if p.program == nil {
+		var program Program
+		p.fs.countRead("exe")
+		if exe, err := p.Proc.Executable(); err == nil && exe != "" {
+			program.Exe = strings.TrimSuffix(exe, " (deleted)")
+		} else if cmdline, err := p.getCmdLine(); err == nil {
+			h := fnv.New64a()
+			for _, arg := range cmdline {
+				h.Write([]byte(arg))
+				h.Write([]byte{0})
+			}
+			program.CmdlineHash = h.Sum64()
+		} else {
+			return Program{}, err
+		}
+		p.program = &program
+	}
+	return *p.program, nil
+}
+
 func (p *proccache) getCmdLine() ([]string, error) {
 	if p.cmdline == nil {
+		p.fs.countRead("cmdline")
 		cmdline, err := p.Proc.CmdLine()
 		if err != nil {
 			return nil, err
@@ -338,7 +560,11 @@ func (p *proccache) getCmdLine() ([]string, error) {
 }
 
 func (p *proccache) getWchan() (string, error) {
+	if !p.fs.GatherWchan {
+		return "", nil
+	}
 	if p.wchan == nil {
+		p.fs.countRead("wchan")
 		wchan, err := p.Proc.Wchan()
 		if err != nil {
 			return "", err
@@ -349,7 +575,11 @@ func (p *proccache) getWchan() (string, error) {
 }
 
 func (p *proccache) getIo() (procfs.ProcIO, error) {
+	if !p.fs.GatherIO {
+		return procfs.ProcIO{}, nil
+	}
 	if p.io == nil {
+		p.fs.countRead("io")
 		io, err := p.Proc.IO()
 		if err != nil {
 			return procfs.ProcIO{}, err
@@ -440,6 +670,15 @@ func (p proc) GetCounts() (Counts, int, error) {
 		MinorPageFaults:       uint64(stat.MinFlt),
 		CtxSwitchVoluntary:    uint64(status.VoluntaryCtxtSwitches),
 		CtxSwitchNonvoluntary: uint64(status.NonVoluntaryCtxtSwitches),
+		CPUChildrenUserTime:   float64(stat.CUTime) / userHZ,
+		CPUChildrenSystemTime: float64(stat.CSTime) / userHZ,
+		CPUGuestTime:          float64(stat.GuestTime) / userHZ,
+		BlockIODelay:          float64(stat.DelayAcctBlkIOTicks) / userHZ,
+		ReadChars:             io.RChar,
+		WriteChars:            io.WChar,
+		ReadSyscalls:          io.SyscR,
+		WriteSyscalls:         io.SyscW,
+		CancelledWriteBytes:   uint64(io.CancelledWriteBytes),
 	}, softerrors, nil
 }
 
@@ -447,14 +686,59 @@ func (p proc) GetWchan() (string, error) {
 	return p.getWchan()
 }
 
+// GetPlacement implements Proc.
+func (p proc) GetPlacement() (Placement, error) {
+	stat, err := p.getStat()
+	if err != nil {
+		return Placement{}, err
+	}
+	status, err := p.getStatus()
+	if err != nil {
+		return Placement{}, err
+	}
+
+	placement := Placement{
+		CPUsAllowed: status.CPUsAllowedList,
+		MemsAllowed: status.MemsAllowedList,
+		Processor:   stat.Processor,
+	}
+	if p.fs.GatherNUMAMaps {
+		p.fs.countRead("numa_maps")
+		f, err := os.Open(filepath.Join(p.fs.MountPoint, strconv.Itoa(p.PID), "numa_maps"))
+		if err != nil {
+			return placement, err
+		}
+		defer f.Close()
+		if placement.NUMAMemory, err = parseNUMAMaps(f); err != nil {
+			return placement, err
+		}
+	}
+	return placement, nil
+}
+
+// GetScheduling implements Proc.
+func (p proc) GetScheduling() (Scheduling, error) {
+	stat, err := p.getStat()
+	if err != nil {
+		return Scheduling{}, err
+	}
+	return Scheduling{Policy: stat.Policy, Nice: stat.Nice}, nil
+}
+
 func (p proc) GetStates() (States, error) {
 	stat, err := p.getStat()
 	if err != nil {
 		return States{}, err
 	}
 
+	return statesFor(stat.State), nil
+}
+
+// statesFor returns the States of a single thread in the given state, as
+// found in the state field of stat.
+func statesFor(state string) States {
 	var s States
-	switch stat.State {
+	switch state {
 	case "R":
 		s.Running++
 	case "S":
@@ -463,10 +747,20 @@ func (p proc) GetStates() (States, error) {
 		s.Waiting++
 	case "Z":
 		s.Zombie++
+	case "T":
+		s.Stopped++
+	case "t":
+		s.Traced++
+	case "I":
+		s.Idle++
+	case "X", "x":
+		s.Dead++
+	case "P":
+		s.Parked++
 	default:
 		s.Other++
 	}
-	return s, nil
+	return s
 }
 
 // GetMetrics returns the current metrics for the proc.  The results are
@@ -489,15 +783,23 @@ func (p proc) GetMetrics() (Metrics, int, error) {
 	// Ditto for status
 	status, _ := p.getStatus()
 
-	numfds, err := p.Proc.FileDescriptorsLen()
-	if err != nil {
-		numfds = -1
-		softerrors |= 1
+	numfds := -1
+	if p.fs.GatherFDs {
+		p.fs.countRead("fd")
+		numfds, err = p.Proc.FileDescriptorsLen()
+		if err != nil {
+			numfds = -1
+			softerrors |= 1
+		}
 	}
 
-	limits, err := p.Proc.NewLimits()
-	if err != nil {
-		return Metrics{}, 0, err
+	var limits procfs.ProcLimits
+	if p.fs.GatherLimits {
+		p.fs.countRead("limits")
+		limits, err = p.Proc.NewLimits()
+		if err != nil {
+			return Metrics{}, 0, err
+		}
 	}
 
 	wchan, err := p.getWchan()
@@ -505,6 +807,14 @@ func (p proc) GetMetrics() (Metrics, int, error) {
 		softerrors |= 1
 	}
 
+	placement, err := p.GetPlacement()
+	if err != nil {
+		softerrors |= 1
+	}
+
+	// Ditto for scheduling, which comes from stat
+	scheduling, _ := p.GetScheduling()
+
 	memory := Memory{
 		ResidentBytes: uint64(stat.ResidentMemory()),
 		VirtualBytes:  uint64(stat.VirtualMemory()),
@@ -512,6 +822,7 @@ func (p proc) GetMetrics() (Metrics, int, error) {
 	}
 
 	if p.proccache.fs.GatherSMaps {
+		p.fs.countRead("smaps_rollup")
 		smaps, err := p.Proc.ProcSMapsRollup()
 		if err != nil {
 			softerrors |= 1
@@ -531,6 +842,8 @@ func (p proc) GetMetrics() (Metrics, int, error) {
 		NumThreads: uint64(stat.NumThreads),
 		States:     states,
 		Wchan:      wchan,
+		Placement:  placement,
+		Scheduling: scheduling,
 	}, softerrors, nil
 }
 
@@ -563,6 +876,8 @@ func (p proc) GetThreads() ([]Thread, error) {
 
 		wchan, _ := iter.GetWchan()
 		states, _ := iter.GetStates()
+		placement, _ := iter.GetPlacement()
+		scheduling, _ := iter.GetScheduling()
 
 		threads = append(threads, Thread{
 			ThreadID:   ThreadID(id),
@@ -570,6 +885,9 @@ func (p proc) GetThreads() ([]Thread, error) {
 			Counts:     counts,
 			Wchan:      wchan,
 			States:     states,
+			Processor:  placement.Processor,
+			Policy:     scheduling.Policy,
+			Nice:       scheduling.Nice,
 		})
 	}
 	err = iter.Close()
@@ -597,7 +915,17 @@ func NewFS(mountPoint string, debug bool) (*FS, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &FS{fs, stat.BootTime, mountPoint, false, debug}, nil
+	return &FS{
+		FS:           fs,
+		BootTime:     stat.BootTime,
+		MountPoint:   mountPoint,
+		GatherFDs:    true,
+		GatherLimits: true,
+		GatherWchan:  true,
+		GatherIO:     true,
+		debug:        debug,
+		reads:        make(map[string]uint64),
+	}, nil
 }
 
 func (fs *FS) threadFs(pid int) (*FS, error) {
@@ -606,7 +934,35 @@ func (fs *FS) threadFs(pid int) (*FS, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &FS{tfs, fs.BootTime, mountPoint, fs.GatherSMaps, false}, nil
+	return &FS{
+		FS:           tfs,
+		BootTime:     fs.BootTime,
+		MountPoint:   mountPoint,
+		GatherSMaps:  fs.GatherSMaps,
+		GatherFDs:    fs.GatherFDs,
+		GatherLimits: fs.GatherLimits,
+		GatherWchan:  fs.GatherWchan,
+		GatherIO:     fs.GatherIO,
+		// numa_maps describes the whole proc, not a thread.
+		GatherNUMAMaps: false,
+		reads:          fs.reads,
+	}, nil
+}
+
+func (fs *FS) countRead(file string) {
+	if fs.reads != nil {
+		fs.reads[file]++
+	}
+}
+
+// FileReads returns how many times each file under /proc/<pid> (or
+// /proc/<pid>/task/<tid>) has been read since fs was created, by file name.
+func (fs *FS) FileReads() map[string]uint64 {
+	reads := make(map[string]uint64, len(fs.reads))
+	for file, count := range fs.reads {
+		reads[file] = count
+	}
+	return reads
 }
 
 // AllProcs implements Source.
@@ -628,6 +984,21 @@ func (p procfsprocs) length() int {
 	return len(p.Procs)
 }
 
+// get implements procs.
+func (p procIDInfos) get(i int) Proc {
+	return &p[i]
+}
+
+// length implements procs.
+func (p procIDInfos) length() int {
+	return len(p)
+}
+
+// NewIDInfoIter returns an Iter over already populated IDInfos.
+func NewIDInfoIter(ps ...IDInfo) Iter {
+	return &procIterator{procs: procIDInfos(ps), idx: -1}
+}
+
 // Next implements Iter.
 func (pi *procIterator) Next() bool {
 	pi.idx++
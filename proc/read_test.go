@@ -2,28 +2,17 @@ package proc
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
-type (
-	// procIDInfos implements procs using a slice of already
-	// populated ProcIdInfo.  Used for testing.
-	procIDInfos []IDInfo
-)
-
-func (p procIDInfos) get(i int) Proc {
-	return &p[i]
-}
-
-func (p procIDInfos) length() int {
-	return len(p)
-}
-
 func procInfoIter(ps ...IDInfo) *procIterator {
 	return &procIterator{procs: procIDInfos(ps), idx: -1}
 }
@@ -40,6 +29,8 @@ func allprocs(procpath string) Iter {
 func TestReadFixture(t *testing.T) {
 	procs := allprocs("../fixtures")
 	var pii IDInfo
+	var identity Identity
+	var program Program
 
 	count := 0
 	for procs.Next() {
@@ -47,6 +38,10 @@ func TestReadFixture(t *testing.T) {
 		var err error
 		pii, err = procinfo(procs)
 		noerr(t, err)
+		identity, err = procs.GetIdentity()
+		noerr(t, err)
+		program, err = procs.GetProgram()
+		noerr(t, err)
 	}
 	err := procs.Close()
 	noerr(t, err)
@@ -72,6 +67,19 @@ func TestReadFixture(t *testing.T) {
 		t.Errorf("static differs: (-got +want)\n%s", diff)
 	}
 
+	wantidentity := Identity{
+		Name:       "process-exporte",
+		StartCode:  4194304,
+		EndCode:    7971236,
+		StartStack: 140736389529632,
+	}
+	if diff := cmp.Diff(identity, wantidentity); diff != "" {
+		t.Errorf("identity differs: (-got +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(program, Program{Exe: "/usr/bin/process-exporter"}); diff != "" {
+		t.Errorf("program differs: (-got +want)\n%s", diff)
+	}
+
 	wantmetrics := Metrics{
 		Counts: Counts{
 			CPUUserTime:           0.1,
@@ -82,6 +90,11 @@ func TestReadFixture(t *testing.T) {
 			MinorPageFaults:       0x643,
 			CtxSwitchVoluntary:    72,
 			CtxSwitchNonvoluntary: 6,
+			BlockIODelay:          0.02,
+			ReadChars:             1605958,
+			WriteChars:            69,
+			ReadSyscalls:          5534,
+			WriteSyscalls:         1,
 		},
 		Memory: Memory{
 			ResidentBytes: 0x7b1000,
@@ -94,12 +107,163 @@ func TestReadFixture(t *testing.T) {
 		},
 		NumThreads: 7,
 		States:     States{Sleeping: 1},
+		Placement: Placement{
+			CPUsAllowed: "0-7",
+			MemsAllowed: "0",
+			Processor:   4,
+		},
 	}
 	if diff := cmp.Diff(pii.Metrics, wantmetrics); diff != "" {
 		t.Errorf("metrics differs: (-got +want)\n%s", diff)
 	}
 }
 
+// TestProgram verifies that the cmdline stands in for the exe of procs
+// whose exe can't be read, and that the exe of a program replaced on disk
+// is the path it had.
+func TestProgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "process-exporter")
+	noerr(t, err)
+	defer os.RemoveAll(dir)
+
+	// Copy the fixture proc, but for its exe link.
+	dst := filepath.Join(dir, "14804")
+	noerr(t, os.Mkdir(dst, 0755))
+	for _, name := range []string{"stat", "14804/stat", "14804/cmdline"} {
+		b, err := ioutil.ReadFile(filepath.Join("..", "fixtures", name))
+		noerr(t, err)
+		noerr(t, ioutil.WriteFile(filepath.Join(dir, name), b, 0644))
+	}
+	program := func() Program {
+		fs, err := NewFS(dir, false)
+		noerr(t, err)
+		procs := fs.AllProcs()
+		if !procs.Next() {
+			t.Fatalf("no procs read from %s", dir)
+		}
+		program, err := procs.GetProgram()
+		noerr(t, err)
+		noerr(t, procs.Close())
+		return program
+	}
+
+	before := program()
+	if before.Exe != "" || before.CmdlineHash == 0 {
+		t.Errorf("got program %+v, want no exe and a cmdline hash", before)
+	}
+	noerr(t, ioutil.WriteFile(filepath.Join(dst, "cmdline"), []byte("postgres: checkpointer\x00"), 0644))
+	if after := program(); after.CmdlineHash == before.CmdlineHash {
+		t.Errorf("cmdline hash didn't change: %+v", after)
+	}
+
+	noerr(t, os.Symlink("/usr/sbin/sshd (deleted)", filepath.Join(dst, "exe")))
+	if diff := cmp.Diff(program(), Program{Exe: "/usr/sbin/sshd"}); diff != "" {
+		t.Errorf("program differs: (-got +want)\n%s", diff)
+	}
+}
+
+// TestFileReads verifies that each /proc file read is counted once per proc.
+func TestFileReads(t *testing.T) {
+	fs, err := NewFS("../fixtures", false)
+	noerr(t, err)
+	procs := fs.AllProcs()
+	for procs.Next() {
+		_, err := procinfo(procs)
+		noerr(t, err)
+	}
+	noerr(t, procs.Close())
+
+	want := map[string]uint64{
+		"stat": 1, "status": 1, "cmdline": 1, "cgroup": 1, "io": 1,
+		"wchan": 1, "fd": 1, "limits": 1,
+	}
+	if diff := cmp.Diff(fs.FileReads(), want); diff != "" {
+		t.Errorf("file reads differ: (-got +want)\n%s", diff)
+	}
+}
+
+// TestHostStats verifies that host CPU and memory totals are read from
+// stat and meminfo.
+func TestHostStats(t *testing.T) {
+	fs, err := NewFS("../fixtures", false)
+	noerr(t, err)
+	got, err := fs.HostStats()
+	noerr(t, err)
+
+	want := HostStats{
+		CPU: HostCPU{
+			User:    2580.72,
+			Nice:    101.28,
+			System:  559.19,
+			Idle:    21638.3,
+			Iowait:  69.46,
+			SoftIRQ: 23.36,
+		},
+		Memory: HostMemory{
+			Total:     16307356 * 1024,
+			Available: 11220648 * 1024,
+			Free:      5483212 * 1024,
+		},
+	}
+	if diff := cmp.Diff(got, want, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Errorf("host stats differ: (-got +want)\n%s", diff)
+	}
+	if used := got.Memory.InUse(); used != (16307356-5483212)*1024 {
+		t.Errorf("got %d bytes in use, want %d", used, (16307356-5483212)*1024)
+	}
+}
+
+// TestNUMAMaps verifies that per-node memory is read from numa_maps when
+// asked for.
+func TestNUMAMaps(t *testing.T) {
+	fs, err := NewFS("../fixtures", false)
+	noerr(t, err)
+	fs.GatherNUMAMaps = true
+	procs := fs.AllProcs()
+	var placements []Placement
+	for procs.Next() {
+		placement, err := procs.GetPlacement()
+		noerr(t, err)
+		placements = append(placements, placement)
+	}
+	noerr(t, procs.Close())
+
+	want := []Placement{{
+		CPUsAllowed: "0-7",
+		MemsAllowed: "0",
+		Processor:   4,
+		NUMAMemory:  map[int]uint64{0: (3 + 100) * 4096, 1: 512 * 2097152},
+	}}
+	if diff := cmp.Diff(placements, want); diff != "" {
+		t.Errorf("placement differs: (-got +want)\n%s", diff)
+	}
+}
+
+// TestStatesFor verifies that each state code is counted in its own field,
+// with unknown ones counted as Other.
+func TestStatesFor(t *testing.T) {
+	for _, tc := range []struct {
+		state string
+		want  States
+	}{
+		{"R", States{Running: 1}},
+		{"S", States{Sleeping: 1}},
+		{"D", States{Waiting: 1}},
+		{"Z", States{Zombie: 1}},
+		{"T", States{Stopped: 1}},
+		{"t", States{Traced: 1}},
+		{"I", States{Idle: 1}},
+		{"X", States{Dead: 1}},
+		{"x", States{Dead: 1}},
+		{"P", States{Parked: 1}},
+		{"W", States{Other: 1}},
+	} {
+		if diff := cmp.Diff(statesFor(tc.state), tc.want); diff != "" {
+			t.Errorf("state %q: (-got +want)\n%s", tc.state, diff)
+		}
+	}
+}
+
 func noerr(t *testing.T, err error) {
 	if err != nil {
 		t.Fatalf("error: %v", err)
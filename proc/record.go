@@ -0,0 +1,367 @@
+package proc
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// recordedProcFiles are the files archived for each proc, enough for FS
+	// to read it.
+	recordedProcFiles = []string{"stat", "status", "cgroup", "cmdline", "wchan", "io", "limits"}
+	// recordedThreadFiles are the files archived for each thread.
+	recordedThreadFiles = []string{"stat", "status", "cgroup", "cmdline", "wchan", "io"}
+
+	// ErrReplayDone is the error returned when closing the Iter returned by
+	// Replay.AllProcs once all snapshots have been replayed.
+	ErrReplayDone = errors.New("no more snapshots to replay")
+)
+
+type (
+	// Recorder archives snapshots of the files under a procfs that FS reads,
+	// as a gzipped tar, for Replay to read back.  Each snapshot is a
+	// directory laid out like the procfs, named after its sequence number,
+	// whose modification time is the time of the snapshot.
+	Recorder struct {
+		// GatherSMaps and GatherNUMAMaps control whether smaps_rollup and
+		// numa_maps are archived, which are costly to read.
+		GatherSMaps    bool
+		GatherNUMAMaps bool
+		procfsPath     string
+		gz             *gzip.Writer
+		tw             *tar.Writer
+		snapshots      int
+	}
+
+	// Replay implements Source by reading back the snapshots archived by a
+	// Recorder: each call to AllProcs returns the procs of the next one.
+	Replay struct {
+		// GatherSMaps and GatherNUMAMaps are applied to the FS reading each
+		// snapshot.
+		GatherSMaps    bool
+		GatherNUMAMaps bool
+		dir            string
+		snapshots      []replaySnapshot
+		next           int
+		fs             *FS
+		debug          bool
+	}
+
+	replaySnapshot struct {
+		name string
+		time time.Time
+	}
+
+	// replayIter is the TimedIter over the procs of a snapshot.
+	replayIter struct {
+		Iter
+		time time.Time
+	}
+)
+
+// NewRecorder returns a Recorder writing snapshots of the procfs mounted
+// under procfsPath to w.  Close must be called to flush the archive.
+func NewRecorder(w io.Writer, procfsPath string) *Recorder {
+	gz := gzip.NewWriter(w)
+	return &Recorder{procfsPath: procfsPath, gz: gz, tw: tar.NewWriter(gz)}
+}
+
+// Snapshot archives the files of all procs and their threads.  Procs that
+// exit while being read are left out, as are files that can't be read,
+// e.g. io for other users' procs when not running as root.
+func (r *Recorder) Snapshot() error {
+	now := time.Now()
+	dir := fmt.Sprintf("%06d", r.snapshots)
+	r.snapshots++
+	if err := r.writeDir(dir, now); err != nil {
+		return err
+	}
+	// stat holds the boot time, needed by NewFS; both it and meminfo are
+	// read for host stats.
+	for _, file := range []string{"stat", "meminfo"} {
+		data, err := ioutil.ReadFile(filepath.Join(r.procfsPath, file))
+		if err != nil {
+			return err
+		}
+		if err := r.writeData(path.Join(dir, file), data, now); err != nil {
+			return err
+		}
+	}
+
+	pids, err := listPids(r.procfsPath)
+	if err != nil {
+		return err
+	}
+	procFiles := recordedProcFiles
+	if r.GatherSMaps {
+		procFiles = append(procFiles, "smaps_rollup")
+	}
+	if r.GatherNUMAMaps {
+		procFiles = append(procFiles, "numa_maps")
+	}
+	for _, pid := range pids {
+		if err := r.snapshotProc(dir, pid, procFiles, now); err != nil {
+			return err
+		}
+	}
+	return r.tw.Flush()
+}
+
+// snapshotProc archives the files of the proc pid under the snapshot
+// directory snapdir.  Only errors writing the archive are returned.
+func (r *Recorder) snapshotProc(snapdir, pid string, files []string, now time.Time) error {
+	// Without stat there's nothing to replay, which usually means the proc
+	// has exited.
+	srcdir := filepath.Join(r.procfsPath, pid)
+	stat, err := ioutil.ReadFile(filepath.Join(srcdir, "stat"))
+	if err != nil {
+		return nil
+	}
+	dir := path.Join(snapdir, pid)
+	if err := r.writeDir(dir, now); err != nil {
+		return err
+	}
+	if err := r.writeData(path.Join(dir, "stat"), stat, now); err != nil {
+		return err
+	}
+	if err := r.copyFiles(srcdir, dir, files[1:], now); err != nil {
+		return err
+	}
+
+	// Only the number of fds matters, so they're archived as empty files
+	// rather than symlinks, whose targets may be sensitive.
+	if fds, err := ioutil.ReadDir(filepath.Join(srcdir, "fd")); err == nil {
+		if err := r.writeDir(path.Join(dir, "fd"), now); err != nil {
+			return err
+		}
+		for _, fd := range fds {
+			if err := r.writeData(path.Join(dir, "fd", fd.Name()), nil, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	taskDir := filepath.Join(srcdir, "task")
+	tids, err := listPids(taskDir)
+	if err != nil || len(tids) == 0 {
+		return nil
+	}
+	if err := r.writeDir(path.Join(dir, "task"), now); err != nil {
+		return err
+	}
+	for _, tid := range tids {
+		tdir := path.Join(dir, "task", tid)
+		if err := r.writeDir(tdir, now); err != nil {
+			return err
+		}
+		if err := r.copyFiles(filepath.Join(taskDir, tid), tdir, recordedThreadFiles, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFiles archives files from srcdir under the archive directory dir.
+// Files that can't be read are skipped: the proc may have exited, or we may
+// lack the permission to read them.
+func (r *Recorder) copyFiles(srcdir, dir string, files []string, now time.Time) error {
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(srcdir, file))
+		if err != nil {
+			continue
+		}
+		if err := r.writeData(path.Join(dir, file), data, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) writeDir(name string, now time.Time) error {
+	// Other formats truncate the time to the second, which is too coarse
+	// for the time of snapshots.
+	return r.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0555,
+		ModTime:  now,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (r *Recorder) writeData(name string, data []byte, now time.Time) error {
+	err := r.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0444,
+		Size:     int64(len(data)),
+		ModTime:  now,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.tw.Write(data)
+	return err
+}
+
+// Close finishes writing the archive.  It doesn't close the underlying
+// writer.
+func (r *Recorder) Close() error {
+	if err := r.tw.Close(); err != nil {
+		return err
+	}
+	return r.gz.Close()
+}
+
+// listPids returns the names of the numeric entries of dir, in numeric order.
+func listPids(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, name := range names {
+		if pid, err := strconv.Atoi(name); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	strs := make([]string, len(pids))
+	for i, pid := range pids {
+		strs[i] = strconv.Itoa(pid)
+	}
+	return strs, nil
+}
+
+// NewReplay reads an archive written by a Recorder from r, extracting it
+// to a temporary directory that Close removes.
+func NewReplay(r io.Reader, debug bool) (*Replay, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "process-exporter-replay")
+	if err != nil {
+		return nil, err
+	}
+	rp := &Replay{dir: dir, debug: debug}
+	if err := rp.extract(tar.NewReader(gz)); err != nil {
+		rp.Close()
+		return nil, err
+	}
+	if len(rp.snapshots) == 0 {
+		rp.Close()
+		return nil, errors.New("archive holds no snapshots")
+	}
+	sort.Slice(rp.snapshots, func(i, j int) bool { return rp.snapshots[i].name < rp.snapshots[j].name })
+	return rp, nil
+}
+
+func (rp *Replay) extract(tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("bad path %q in archive", hdr.Name)
+		}
+		dest := filepath.Join(rp.dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return err
+			}
+			if !strings.Contains(name, "/") {
+				rp.snapshots = append(rp.snapshots, replaySnapshot{name, hdr.ModTime})
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Len returns the number of snapshots in the archive.
+func (rp *Replay) Len() int {
+	return len(rp.snapshots)
+}
+
+// Time returns the time of the snapshot last returned by AllProcs.
+func (rp *Replay) Time() time.Time {
+	if rp.next == 0 {
+		return time.Time{}
+	}
+	return rp.snapshots[rp.next-1].time
+}
+
+// AllProcs implements Source.  The Iter is a TimedIter giving the time of
+// the snapshot.  Once all snapshots have been returned, it returns an empty
+// Iter whose Close returns ErrReplayDone.
+func (rp *Replay) AllProcs() Iter {
+	if rp.next >= len(rp.snapshots) {
+		return &procIterator{procs: procIDInfos(nil), err: ErrReplayDone, idx: -1}
+	}
+	snap := rp.snapshots[rp.next]
+	rp.next++
+
+	fs, err := NewFS(filepath.Join(rp.dir, snap.name), rp.debug)
+	if err != nil {
+		return &procIterator{procs: procIDInfos(nil),
+			err: fmt.Errorf("error reading snapshot %s: %v", snap.name, err), idx: -1}
+	}
+	fs.GatherSMaps = rp.GatherSMaps
+	fs.GatherNUMAMaps = rp.GatherNUMAMaps
+	rp.fs = fs
+	return replayIter{fs.AllProcs(), snap.time}
+}
+
+// Time implements TimedIter.
+func (ri replayIter) Time() time.Time {
+	return ri.time
+}
+
+// HostStats implements HostStatter, for the snapshot last returned by
+// AllProcs.
+func (rp *Replay) HostStats() (HostStats, error) {
+	if rp.fs == nil {
+		return HostStats{}, errors.New("no snapshot replayed yet")
+	}
+	return rp.fs.HostStats()
+}
+
+// Close removes the extracted snapshots.
+func (rp *Replay) Close() error {
+	return os.RemoveAll(rp.dir)
+}
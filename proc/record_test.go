@@ -0,0 +1,62 @@
+package proc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readAll returns the IDInfo of each proc of iter.
+func readAll(t *testing.T, iter Iter) []IDInfo {
+	var infos []IDInfo
+	for iter.Next() {
+		pii, err := procinfo(iter)
+		noerr(t, err)
+		infos = append(infos, pii)
+	}
+	noerr(t, iter.Close())
+	return infos
+}
+
+// Test that recording a procfs and replaying it yields the same procs as
+// reading it directly.
+func TestRecordReplay(t *testing.T) {
+	want := readAll(t, allprocs("../fixtures"))
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, "../fixtures")
+	for i := 0; i < 2; i++ {
+		noerr(t, rec.Snapshot())
+	}
+	noerr(t, rec.Close())
+
+	rp, err := NewReplay(&buf, false)
+	noerr(t, err)
+	defer rp.Close()
+	if rp.Len() != 2 {
+		t.Fatalf("got %d snapshots, want 2", rp.Len())
+	}
+
+	for i := 0; i < 2; i++ {
+		iter := rp.AllProcs()
+		got := readAll(t, iter)
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("snapshot %d differs: (-got +want)\n%s", i, diff)
+		}
+		if rp.Time().IsZero() {
+			t.Errorf("snapshot %d has no time", i)
+		}
+		if ti, ok := iter.(TimedIter); !ok || !ti.Time().Equal(rp.Time()) {
+			t.Errorf("snapshot %d: got iter %T, want a TimedIter with time %v", i, iter, rp.Time())
+		}
+	}
+
+	iter := rp.AllProcs()
+	if iter.Next() {
+		t.Errorf("got procs after the last snapshot")
+	}
+	if err := iter.Close(); err != ErrReplayDone {
+		t.Errorf("got error %v after the last snapshot, want %v", err, ErrReplayDone)
+	}
+}
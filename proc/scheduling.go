@@ -0,0 +1,81 @@
+package proc
+
+import "strconv"
+
+// Scheduling policies, as found in the policy field of /proc/<pid>/stat.
+const (
+	SchedOther    = 0
+	SchedFIFO     = 1
+	SchedRR       = 2
+	SchedBatch    = 3
+	SchedIdle     = 5
+	SchedDeadline = 6
+)
+
+// GroupScheduling describes how the threads of a group are scheduled.
+type GroupScheduling struct {
+	// ThreadPolicies counts threads by scheduling policy.
+	ThreadPolicies map[uint]int
+	// MinNice and MaxNice bound the nice values of the threads.
+	MinNice int
+	MaxNice int
+}
+
+// PolicyName returns the name of a scheduling policy, e.g. "SCHED_FIFO".
+func PolicyName(policy uint) string {
+	switch policy {
+	case SchedOther:
+		return "SCHED_OTHER"
+	case SchedFIFO:
+		return "SCHED_FIFO"
+	case SchedRR:
+		return "SCHED_RR"
+	case SchedBatch:
+		return "SCHED_BATCH"
+	case SchedIdle:
+		return "SCHED_IDLE"
+	case SchedDeadline:
+		return "SCHED_DEADLINE"
+	}
+	return "SCHED_" + strconv.FormatUint(uint64(policy), 10)
+}
+
+// SetScheduling makes the grouper track how the threads of each group are
+// scheduled.
+func (g *Grouper) SetScheduling() {
+	g.scheduling = make(map[string]GroupScheduling)
+	g.tracker.threadPolicies = true
+}
+
+// Scheduling returns how the threads of each group are scheduled as of the
+// last Update, or nil if SetScheduling wasn't called.
+func (g *Grouper) Scheduling() map[string]GroupScheduling {
+	return g.scheduling
+}
+
+// updateScheduling recomputes the scheduling of each group from the tracker
+// state.
+func (g *Grouper) updateScheduling() {
+	g.scheduling = make(map[string]GroupScheduling)
+	for _, tproc := range g.tracker.tracked {
+		s := tproc.metrics.Scheduling
+		gs, ok := g.scheduling[tproc.groupName]
+		if !ok {
+			gs = GroupScheduling{
+				ThreadPolicies: make(map[uint]int),
+				MinNice:        s.MinNice,
+				MaxNice:        s.MaxNice,
+			}
+		}
+		for policy, n := range s.ThreadPolicies {
+			gs.ThreadPolicies[policy] += n
+		}
+		if s.MinNice < gs.MinNice {
+			gs.MinNice = s.MinNice
+		}
+		if s.MaxNice > gs.MaxNice {
+			gs.MaxNice = s.MaxNice
+		}
+		g.scheduling[tproc.groupName] = gs
+	}
+}
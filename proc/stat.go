@@ -0,0 +1,252 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/procfs"
+)
+
+// procStat is the content of /proc/<pid>/stat: what procfs parses, plus
+// fields it doesn't know about.
+type procStat struct {
+	procfs.ProcStat
+	// Processor is the CPU the proc last ran on.
+	Processor int
+	// GuestTime is the time spent running a virtual CPU for a guest
+	// operating system, in clock ticks.  It's included in UTime.
+	GuestTime uint64
+	// StartCode, EndCode and StartStack are the addresses of the program
+	// text and the bottom of the stack.  The kernel reports them as 0 unless
+	// we may ptrace the proc.
+	StartCode  uint64
+	EndCode    uint64
+	StartStack uint64
+}
+
+// procStatus is the content of /proc/<pid>/status: the fields of
+// procfs.ProcStatus we use, plus fields it doesn't know about.
+type procStatus struct {
+	procfs.ProcStatus
+	// CPUsAllowedList and MemsAllowedList are the CPUs and memory nodes
+	// the proc may use, in list format, e.g. "0-3,8".
+	CPUsAllowedList string
+	MemsAllowedList string
+}
+
+// readStat reads and parses a stat file.  It does the same as
+// procfs.Proc.Stat, but also parses the fields following
+// delayacct_blkio_ticks, which may be absent on old kernels.
+func readStat(path string, pid int) (procStat, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return procStat{}, err
+	}
+
+	var (
+		ignoreInt64  int64
+		ignoreUint64 uint64
+
+		s = procStat{ProcStat: procfs.ProcStat{PID: pid}}
+		l = bytes.Index(data, []byte("("))
+		r = bytes.LastIndex(data, []byte(")"))
+	)
+	if l < 0 || r < 0 || r+2 > len(data) {
+		return procStat{}, fmt.Errorf("unexpected format, couldn't extract comm %q", data)
+	}
+
+	s.Comm = string(data[l+1 : r])
+	buf := bytes.NewBuffer(data[r+2:])
+	_, err = fmt.Fscan(buf,
+		&s.State,
+		&s.PPID,
+		&s.PGRP,
+		&s.Session,
+		&s.TTY,
+		&s.TPGID,
+		&s.Flags,
+		&s.MinFlt,
+		&s.CMinFlt,
+		&s.MajFlt,
+		&s.CMajFlt,
+		&s.UTime,
+		&s.STime,
+		&s.CUTime,
+		&s.CSTime,
+		&s.Priority,
+		&s.Nice,
+		&s.NumThreads,
+		&ignoreInt64,
+		&s.Starttime,
+		&s.VSize,
+		&s.RSS,
+		&s.RSSLimit,
+		&s.StartCode,
+		&s.EndCode,
+		&s.StartStack,
+		&ignoreUint64,
+		&ignoreUint64,
+		&ignoreUint64,
+		&ignoreUint64,
+		&ignoreUint64,
+		&ignoreUint64,
+		&ignoreUint64,
+		&ignoreUint64,
+		&ignoreUint64,
+		&ignoreInt64,
+		&s.Processor,
+		&s.RTPriority,
+		&s.Policy,
+		&s.DelayAcctBlkIOTicks,
+	)
+	if err != nil {
+		return procStat{}, err
+	}
+
+	// guest_time appeared in Linux 2.6.24.
+	fmt.Fscan(buf, &s.GuestTime)
+	return s, nil
+}
+
+// readStatus reads and parses a status file.  Unlike procfs.Proc.NewStatus
+// it only fills in the fields of procfs.ProcStatus that we use.
+func readStatus(path string, pid int) (procStatus, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return procStatus{}, err
+	}
+
+	s := procStatus{ProcStatus: procfs.ProcStatus{PID: pid}}
+	for _, line := range strings.Split(string(data), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch k {
+		case "Name":
+			s.Name = v
+		case "Tgid":
+			s.TGID, _ = strconv.Atoi(v)
+		case "Uid":
+			copy(s.UIDs[:], strings.Split(v, "\t"))
+		case "Gid":
+			copy(s.GIDs[:], strings.Split(v, "\t"))
+		case "VmSwap":
+			kb, _ := strconv.ParseUint(strings.TrimSuffix(v, " kB"), 10, 64)
+			s.VmSwap = kb * 1024
+		case "voluntary_ctxt_switches":
+			s.VoluntaryCtxtSwitches, _ = strconv.ParseUint(v, 10, 64)
+		case "nonvoluntary_ctxt_switches":
+			s.NonVoluntaryCtxtSwitches, _ = strconv.ParseUint(v, 10, 64)
+		case "Cpus_allowed_list":
+			s.CPUsAllowedList = v
+		case "Mems_allowed_list":
+			s.MemsAllowedList = v
+		}
+	}
+	return s, nil
+}
+
+// parseNUMAMaps returns the memory mapped on each NUMA node in bytes, given
+// the content of a numa_maps file.
+func parseNUMAMaps(r io.Reader) (map[int]uint64, error) {
+	nodes := make(map[int]uint64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		pageSize := uint64(os.Getpagesize())
+		for _, f := range fields {
+			if strings.HasPrefix(f, "kernelpagesize_kB=") {
+				if kb, err := strconv.ParseUint(f[len("kernelpagesize_kB="):], 10, 64); err == nil {
+					pageSize = kb * 1024
+				}
+			}
+		}
+		for _, f := range fields {
+			if len(f) < 2 || f[0] != 'N' {
+				continue
+			}
+			kv := strings.SplitN(f[1:], "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			node, err := strconv.Atoi(kv[0])
+			if err != nil {
+				continue
+			}
+			pages, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad numa_maps field %q: %v", f, err)
+			}
+			nodes[node] += pages * pageSize
+		}
+	}
+	return nodes, scanner.Err()
+}
+
+// ReadCPUNodes returns the NUMA node of each CPU, as described under
+// sysfsPath (normally /sys).  It returns an error if the system doesn't
+// expose NUMA topology.
+func ReadCPUNodes(sysfsPath string) (map[int]int, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsPath, "devices/system/node/node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no NUMA nodes found under %s", sysfsPath)
+	}
+
+	cpuNodes := make(map[int]int)
+	for _, dir := range dirs {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "cpulist"))
+		if err != nil {
+			return nil, err
+		}
+		cpus, err := parseCPUList(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("bad cpulist for node %d: %v", node, err)
+		}
+		for _, cpu := range cpus {
+			cpuNodes[cpu] = node
+		}
+	}
+	return cpuNodes, nil
+}
+
+// parseCPUList parses a list like "0-3,8,10-11" as found in sysfs and in
+// Cpus_allowed_list.
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	if list == "" {
+		return cpus, nil
+	}
+	for _, r := range strings.Split(list, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,192 @@
+package proc
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxTopCmdline is the longest cmdline reported in a TopProc.
+const maxTopCmdline = 64
+
+type (
+	// TopKPolicy determines how many of the busiest procs of each group to report.
+	TopKPolicy interface {
+		// TopK returns the number of procs to report for groups of rule, as
+		// returned by Grouper.GroupRule, 0 for none.
+		TopK(rule int) int
+	}
+
+	// TopProc describes one of the procs of a group using the most of some resource.
+	TopProc struct {
+		Pid int
+		// Cmdline is the proc's cmdline, truncated if long.
+		Cmdline string
+		// Value is the amount of the resource used.
+		Value float64
+	}
+
+	// TopComm describes one of the comms (proc names) among unmatched procs
+	// using the most of some resource.
+	TopComm struct {
+		Comm string
+		// Procs is the number of procs with this comm.
+		Procs int
+		// Value is the amount of the resource used by all those procs.
+		Value float64
+	}
+
+	// TopComms lists the comms of unmatched procs using the most CPU and memory.
+	TopComms struct {
+		// CPU lists the comms with the highest CPU usage (user+system)
+		// since the last update, in seconds per second.
+		CPU []TopComm
+		// Resident lists the comms with the most resident memory, in bytes.
+		Resident []TopComm
+	}
+
+	// TopProcs lists the procs of a group using the most CPU and memory.
+	TopProcs struct {
+		// CPU lists the procs with the highest CPU usage (user+system)
+		// since the last update, in seconds per second.
+		CPU []TopProc
+		// Resident lists the procs with the most resident memory, in bytes.
+		Resident []TopProc
+	}
+)
+
+// SetTopKPolicy makes the grouper track the busiest procs of each group as
+// directed by tk.
+func (g *Grouper) SetTopKPolicy(tk TopKPolicy) {
+	g.topKPolicy = tk
+}
+
+// TopProcs returns the busiest procs of each group as of the last Update,
+// for those groups the TopKPolicy asks for.
+func (g *Grouper) TopProcs() map[string]TopProcs {
+	return g.topProcs
+}
+
+// SetCatchAll makes the grouper account procs that would otherwise be
+// ignored in a group named gname, and report the topk comms among them
+// using the most CPU and memory.
+func (g *Grouper) SetCatchAll(gname string, topk int) {
+	g.tracker.SetCatchAll(gname)
+	g.catchAllTopK = topk
+}
+
+// TopUnmatched returns the comms of unmatched procs using the most
+// resources as of the last Update.
+func (g *Grouper) TopUnmatched() TopComms {
+	return g.topUnmatched
+}
+
+// updateTopProcs recomputes the busiest procs of each group from the tracker
+// state.  elapsed is the time since the previous update, or 0 if there was
+// none, in which case CPU usage is unknown.
+func (g *Grouper) updateTopProcs(elapsed time.Duration) {
+	g.topProcs = make(map[string]TopProcs)
+	g.updateTopUnmatched(elapsed)
+	if g.topKPolicy == nil {
+		return
+	}
+
+	for id, tproc := range g.tracker.tracked {
+		if g.topKPolicy.TopK(g.GroupRule(tproc.groupName)) == 0 {
+			continue
+		}
+		top := g.topProcs[tproc.groupName]
+		cmdline := shortCmdline(tproc.static)
+		if elapsed > 0 {
+			cpu := (tproc.lastaccum.CPUUserTime + tproc.lastaccum.CPUSystemTime) / elapsed.Seconds()
+			top.CPU = append(top.CPU, TopProc{id.Pid, cmdline, cpu})
+		}
+		top.Resident = append(top.Resident,
+			TopProc{id.Pid, cmdline, float64(tproc.metrics.ResidentBytes)})
+		g.topProcs[tproc.groupName] = top
+	}
+
+	for gname, top := range g.topProcs {
+		k := g.topKPolicy.TopK(g.GroupRule(gname))
+		top.CPU = topK(top.CPU, k)
+		top.Resident = topK(top.Resident, k)
+		g.topProcs[gname] = top
+	}
+}
+
+// updateTopUnmatched recomputes the comms of unmatched procs using the most
+// resources from the tracker state.
+func (g *Grouper) updateTopUnmatched(elapsed time.Duration) {
+	g.topUnmatched = TopComms{}
+	if g.catchAllTopK == 0 {
+		return
+	}
+
+	cpu, resident := make(map[string]TopComm), make(map[string]TopComm)
+	for _, tproc := range g.tracker.tracked {
+		if !tproc.unmatched {
+			continue
+		}
+		comm := tproc.static.Name
+		if elapsed > 0 {
+			tc := cpu[comm]
+			tc.Procs++
+			tc.Value += (tproc.lastaccum.CPUUserTime + tproc.lastaccum.CPUSystemTime) / elapsed.Seconds()
+			cpu[comm] = tc
+		}
+		tc := resident[comm]
+		tc.Procs++
+		tc.Value += float64(tproc.metrics.ResidentBytes)
+		resident[comm] = tc
+	}
+
+	g.topUnmatched.CPU = topKComms(cpu, g.catchAllTopK)
+	g.topUnmatched.Resident = topKComms(resident, g.catchAllTopK)
+}
+
+// topKComms returns the k comms with the highest values, in descending order.
+func topKComms(comms map[string]TopComm, k int) []TopComm {
+	tcs := make([]TopComm, 0, len(comms))
+	for comm, tc := range comms {
+		tc.Comm = comm
+		tcs = append(tcs, tc)
+	}
+	sort.Slice(tcs, func(i, j int) bool {
+		if tcs[i].Value != tcs[j].Value {
+			return tcs[i].Value > tcs[j].Value
+		}
+		return tcs[i].Comm < tcs[j].Comm
+	})
+	if len(tcs) > k {
+		tcs = tcs[:k]
+	}
+	return tcs
+}
+
+// topK returns the k procs with the highest values, in descending order.
+func topK(procs []TopProc, k int) []TopProc {
+	sort.Slice(procs, func(i, j int) bool {
+		if procs[i].Value != procs[j].Value {
+			return procs[i].Value > procs[j].Value
+		}
+		return procs[i].Pid < procs[j].Pid
+	})
+	if len(procs) > k {
+		procs = procs[:k]
+	}
+	return procs
+}
+
+// shortCmdline returns the cmdline of a proc as valid UTF-8 truncated to
+// maxTopCmdline characters, or its name in brackets if it has no cmdline,
+// e.g. kernel threads.
+func shortCmdline(static Static) string {
+	cmdline := strings.ToValidUTF8(strings.Join(static.Cmdline, " "), "?")
+	if cmdline == "" {
+		return "[" + static.Name + "]"
+	}
+	if runes := []rune(cmdline); len(runes) > maxTopCmdline {
+		cmdline = string(runes[:maxTopCmdline-3]) + "..."
+	}
+	return cmdline
+}
@@ -16,23 +16,110 @@ type (
 	Tracker struct {
 		// namer determines what processes to track and names them
 		namer common.MatchNamer
-		// tracked holds the processes are being monitored.  Processes
-		// may be blacklisted such that they no longer get tracked by
-		// setting their value in the tracked map to nil.
+		// tracked holds the processes are being monitored.
 		tracked map[ID]*trackedProc
+		// ignored holds the processes we've decided not to monitor, mapped
+		// to the last time they were seen so that they can be forgotten
+		// once they exit.
+		ignored map[ID]time.Time
 		// procIds is a map from pid to ProcId.  This is a convenience
 		// to allow finding the Tracked entry of a parent process.
 		procIds map[int]ID
+		// identities holds the identity and program of each tracked and
+		// ignored proc as last seen, to notice when it execs another
+		// program.
+		identities map[ID]procIdentity
+		// execed holds the procs found during the current update to have
+		// exec'd since they were named, mapped to their previous tracked
+		// state, or nil if they were ignored.
+		execed map[ID]*trackedProc
 		// firstUpdateAt is the time the first update was run. It allows to
 		// count first usage of a process started between two Update() calls
 		firstUpdateAt time.Time
 		// trackChildren makes Tracker track descendants of procs the
-		// namer wanted tracked.
-		trackChildren bool
+		// namer wanted tracked, for groups the childrenPolicy has no
+		// opinion on.
+		trackChildren  bool
+		childrenPolicy ChildrenPolicy
 		// never ignore processes, i.e. always re-check untracked processes in case comm has changed
 		alwaysRecheck bool
-		username      map[int]string
-		debug         bool
+		// catchAll, if not empty, is the group name given to procs that
+		// would otherwise be ignored.
+		catchAll string
+		username map[int]string
+		debug    bool
+		// stats describes the cost of the last Update.
+		stats UpdateStats
+		// zombieParents holds the parent pid of each zombie seen during
+		// the current update.
+		zombieParents []int
+		// threadCPUs and threadPolicies make handleProc count the threads
+		// of each proc by CPU and by scheduling policy, for the placement
+		// and scheduling of groups.
+		threadCPUs     bool
+		threadPolicies bool
+		// rematch holds the procs in the catch-all group seen during the
+		// current update, which are named again when rechecking.
+		rematch []ID
+		// unreaped counts zombies by the group of their parent as of the
+		// last Update.
+		unreaped map[string]int
+	}
+
+	// ChildrenPolicy controls how new descendants of the procs of each
+	// rule are tracked.  rule is always that of the matched ancestor, as
+	// returned by a common.RuleMatchNamer, or common.NoRule.
+	ChildrenPolicy interface {
+		// InheritChildren returns whether descendants of procs rule
+		// matched are tracked with them.  ok is false if the policy has no
+		// opinion.
+		InheritChildren(rule int) (inherit bool, ok bool)
+		// MaxChildDepth returns how many generations below a matched proc
+		// may be tracked with it, or 0 if there's no limit.
+		MaxChildDepth(rule int) int
+		// ChildGroupName returns the name of the group in which to track
+		// a descendant named comm of a proc rule matched and named
+		// groupname.
+		ChildGroupName(rule int, groupname, comm string) string
+	}
+
+	// Orphans counts the tracked procs of a group that outlived their
+	// tracked parent and were adopted by another proc.
+	Orphans struct {
+		// Init is the number adopted by pid 1.
+		Init int
+		// Subreaper is the number adopted by another proc, i.e. a
+		// subreaper (see PR_SET_CHILD_SUBREAPER).
+		Subreaper int
+	}
+
+	// GroupAttributes describes what the tracked procs of a group have in
+	// common.  Fields are empty when the procs differ.
+	GroupAttributes struct {
+		// ExecutableName is the comm of the procs.
+		ExecutableName string
+		// Owner is the name of the effective user of the procs.
+		Owner string
+	}
+
+	// UpdateStats describes the work done by the most recent Tracker update.
+	UpdateStats struct {
+		// UpdateTime is how long it took to read all procs and update
+		// the tracked ones.
+		UpdateTime time.Duration
+		// AncestryTime is how long it took to resolve the groups of
+		// new procs based on their parents.
+		AncestryTime time.Duration
+		// ThreadsTime is how long was spent reading threads, which is
+		// included in UpdateTime.
+		ThreadsTime time.Duration
+		// Procs is the number of procs scanned.
+		Procs int
+		// Threads is the number of threads read.
+		Threads int
+		// Execs is the number of known procs found to have exec'd another
+		// program, and so named again.
+		Execs int
 	}
 
 	// Delta is an alias of Counts used to signal that its contents are not
@@ -47,6 +134,13 @@ type (
 		wchan      string
 	}
 
+	// procIdentity is what the tracker remembers of a proc to tell when it
+	// execs.
+	procIdentity struct {
+		Identity
+		Program
+	}
+
 	// trackedProc accumulates metrics for a process, as well as
 	// remembering an optional GroupName tag associated with it.
 	trackedProc struct {
@@ -59,7 +153,47 @@ type (
 		lastaccum Delta
 		// groupName is the tag for this proc given by the namer.
 		groupName string
+		// rule is the rule of the namer that matched this proc or its
+		// matched ancestor, or common.NoRule.
+		rule int
+		// inheritedFrom is the pid of the tracked parent this proc got its
+		// groupName from, or 0 if the namer matched it directly.
+		inheritedFrom int
+		// unmatched is true if the proc is only tracked because it's in
+		// the catch-all group.
+		unmatched bool
+		// baseGroup is the groupName of the ancestor matched by the namer,
+		// and depth is how many generations below it this proc is.
+		baseGroup string
+		depth     int
 		threads   map[ThreadID]trackedThread
+		// childCredit is the CPU time of tracked children that have exited
+		// which hasn't yet shown up in this proc's children CPU times.
+		childCredit cpuCredit
+		// orphaned is true once the tracked parent of this proc has exited,
+		// and adoptedBy is the pid of its new parent, or 0 until known.
+		orphaned  bool
+		adoptedBy int
+	}
+
+	// cpuCredit is CPU time that was already counted and so must be
+	// deducted from children CPU times when they grow to include it.
+	cpuCredit struct {
+		user, system float64
+	}
+
+	// TrackedProc describes a proc being tracked and how it was named.
+	TrackedProc struct {
+		ID
+		Static
+		// GroupName is the name of the group the proc belongs to.
+		GroupName string
+		// InheritedFrom is the pid of the tracked parent whose group this proc
+		// joined, or 0 if the namer matched the proc directly.
+		InheritedFrom int
+		// Unmatched is true if the proc is in the catch-all group because
+		// it wasn't otherwise matched.
+		Unmatched bool
 	}
 
 	// ThreadUpdate describes what's changed for a thread since the last cycle.
@@ -143,7 +277,9 @@ func NewTracker(namer common.MatchNamer, trackChildren bool, alwaysRecheck bool,
 	return &Tracker{
 		namer:         namer,
 		tracked:       make(map[ID]*trackedProc),
+		ignored:       make(map[ID]time.Time),
 		procIds:       make(map[int]ID),
+		identities:    make(map[ID]procIdentity),
 		trackChildren: trackChildren,
 		alwaysRecheck: alwaysRecheck,
 		username:      make(map[int]string),
@@ -151,11 +287,14 @@ func NewTracker(namer common.MatchNamer, trackChildren bool, alwaysRecheck bool,
 	}
 }
 
-func (t *Tracker) track(groupName string, idinfo IDInfo) {
+func (t *Tracker) track(groupName string, rule int, inheritedFrom int, idinfo IDInfo) {
 	tproc := trackedProc{
-		groupName: groupName,
-		static:    idinfo.Static,
-		metrics:   idinfo.Metrics,
+		groupName:     groupName,
+		rule:          rule,
+		inheritedFrom: inheritedFrom,
+		baseGroup:     groupName,
+		static:        idinfo.Static,
+		metrics:       idinfo.Metrics,
 	}
 	if len(idinfo.Threads) > 0 {
 		tproc.threads = make(map[ThreadID]trackedThread)
@@ -165,19 +304,104 @@ func (t *Tracker) track(groupName string, idinfo IDInfo) {
 		}
 	}
 
-	// If the process started while Tracker was running, all current counter happened
-	// between the last Update() and the current Update() and should be counted.
-	if idinfo.StartTime.After(t.firstUpdateAt) {
+	if prev, ok := t.execed[idinfo.ID]; ok {
+		// The proc exec'd another program.  If it was tracked, what it used
+		// before the last Update() was counted in its previous group, so
+		// only count what it used since.  If it was ignored, its usage
+		// until now is unknown.
+		if prev != nil {
+			tproc.lastaccum = tproc.metrics.Counts.Sub(prev.metrics.Counts)
+			prev.childCredit.apply(&tproc.lastaccum)
+			for tid, tt := range tproc.threads {
+				if old, ok := prev.threads[tid]; ok {
+					tt.latest = tt.accum.Sub(old.accum)
+					tproc.threads[tid] = tt
+				}
+			}
+			tproc.orphaned, tproc.adoptedBy = prev.orphaned, prev.adoptedBy
+		}
+	} else if idinfo.StartTime.After(t.firstUpdateAt) {
+		// If the process started while Tracker was running, all current counter happened
+		// between the last Update() and the current Update() and should be counted.
 		tproc.lastaccum = Delta(tproc.metrics.Counts)
 	}
 
 	t.tracked[idinfo.ID] = &tproc
 }
 
-func (t *Tracker) ignore(id ID) {
+// SetChildrenPolicy makes the tracker track descendants of the procs of
+// each group as directed by cp.
+func (t *Tracker) SetChildrenPolicy(cp ChildrenPolicy) {
+	t.childrenPolicy = cp
+}
+
+// checksAncestry returns true if new procs that the namer doesn't want
+// may be tracked because of their ancestry.
+func (t *Tracker) checksAncestry() bool {
+	return t.trackChildren || t.childrenPolicy != nil
+}
+
+// inherit handles a new proc whose parent ptproc is tracked, by tracking
+// it with its parent if the children policy allows.  It returns the name
+// of the group the proc was tracked in, or "" if it wasn't inherited.
+func (t *Tracker) inherit(ptproc *trackedProc, ppid int, idinfo IDInfo, now time.Time) string {
+	if ptproc.unmatched {
+		// We've found a parent that's only tracked because of the catch-all.
+		t.unmatched(idinfo, now)
+		return ""
+	}
+
+	base, depth, rule := ptproc.baseGroup, ptproc.depth+1, ptproc.rule
+	inherit, gname := t.trackChildren, base
+	if cp := t.childrenPolicy; cp != nil {
+		if i, ok := cp.InheritChildren(rule); ok {
+			inherit = i
+		}
+		if max := cp.MaxChildDepth(rule); max > 0 && depth > max {
+			inherit = false
+		}
+		gname = cp.ChildGroupName(rule, base, idinfo.Name)
+	}
+	if !inherit {
+		if t.debug {
+			log.Printf("not inheriting group %q (depth %d) from parent pid %d: %+v",
+				base, depth, ppid, idinfo)
+		}
+		t.unmatched(idinfo, now)
+		return ""
+	}
+
+	if t.debug {
+		log.Printf("matched as %q because child of pid %d: %+v", gname, ppid, idinfo)
+	}
+	t.track(gname, rule, ppid, idinfo)
+	tproc := t.tracked[idinfo.ID]
+	tproc.baseGroup, tproc.depth = base, depth
+	return gname
+}
+
+// SetCatchAll makes the tracker track procs it would otherwise ignore,
+// naming them gname.  If gname is empty such procs are ignored.
+func (t *Tracker) SetCatchAll(gname string) {
+	t.catchAll = gname
+}
+
+// unmatched handles a new proc that neither the namer nor its ancestry
+// assigned to a group, by tracking it in the catch-all group if there is
+// one, or ignoring it otherwise.
+func (t *Tracker) unmatched(idinfo IDInfo, now time.Time) {
+	if t.catchAll == "" {
+		t.ignore(idinfo.ID, now)
+		return
+	}
+	t.track(t.catchAll, common.NoRule, 0, idinfo)
+	t.tracked[idinfo.ID].unmatched = true
+}
+
+func (t *Tracker) ignore(id ID, now time.Time) {
 	// only ignore ID if we didn't set recheck to true
 	if t.alwaysRecheck == false {
-		t.tracked[id] = nil
+		t.ignored[id] = now
 	}
 }
 
@@ -185,6 +409,10 @@ func (tp *trackedProc) update(metrics Metrics, now time.Time, cerrs *CollectErro
 	// newcounts: resource consumption since last cycle
 	newcounts := metrics.Counts
 	tp.lastaccum = newcounts.Sub(tp.metrics.Counts)
+	// Credit left over from last cycle is due to children that exited
+	// between reading this proc and noticing they were gone.
+	tp.childCredit.apply(&tp.lastaccum)
+	tp.childCredit = cpuCredit{}
 	tp.metrics = metrics
 	tp.lastUpdate = now
 	if len(threads) > 1 {
@@ -223,11 +451,41 @@ func (t *Tracker) handleProc(proc Proc, updateTime time.Time) (*IDInfo, CollectE
 		return nil, cerrs
 	}
 
-	// Do nothing if we're ignoring this proc.
-	last, known := t.tracked[procID]
-	if known && last == nil {
+	// Zombies are noted whether or not they're tracked, since it's their
+	// parent that's at fault.  Since they're rare it's ok to read their
+	// static details every time.
+	if states, err := proc.GetStates(); err == nil && states.Zombie > 0 {
+		if static, err := proc.GetStatic(); err == nil {
+			t.zombieParents = append(t.zombieParents, static.ParentPid)
+		}
+	}
+
+	// A known proc that exec'd another program is handled as a new one, so
+	// that it's named again.  Its program is only read if its identity,
+	// from stat which is read anyway, changed.
+	identity, identityErr := proc.GetIdentity()
+	if identityErr == nil {
+		if old, ok := t.identities[procID]; ok && old.Identity != identity {
+			next := procIdentity{Identity: identity}
+			execd := old.relaidOut(identity)
+			if !execd {
+				next.Program, _ = proc.GetProgram()
+				execd = old.Program.replacedBy(next.Program)
+			}
+			if execd {
+				t.reexec(procID, old, next)
+			} else {
+				t.identities[procID] = next
+			}
+		}
+	}
+
+	// Do nothing if we're ignoring this proc, other than noting it's still alive.
+	if _, ignored := t.ignored[procID]; ignored {
+		t.ignored[procID] = updateTime
 		return nil, cerrs
 	}
+	last, known := t.tracked[procID]
 
 	metrics, softerrors, err := proc.GetMetrics()
 	if err != nil {
@@ -243,7 +501,10 @@ func (t *Tracker) handleProc(proc Proc, updateTime time.Time) (*IDInfo, CollectE
 	}
 
 	var threads []Thread
+	start := time.Now()
 	threads, err = proc.GetThreads()
+	t.stats.ThreadsTime += time.Since(start)
+	t.stats.Threads += len(threads)
 	if err != nil {
 		if t.debug {
 			log.Printf("can't read thread metrics for %+v: %v", procID, err)
@@ -252,18 +513,60 @@ func (t *Tracker) handleProc(proc Proc, updateTime time.Time) (*IDInfo, CollectE
 	}
 	cerrs.Partial += softerrors
 
+	if t.threadCPUs {
+		metrics.ThreadCPUs = make(map[int]int)
+	}
+	if t.threadPolicies {
+		metrics.ThreadPolicies = make(map[uint]int)
+	}
+	metrics.MinNice, metrics.MaxNice = metrics.Nice, metrics.Nice
 	if len(threads) > 0 {
 		metrics.Counts.CtxSwitchNonvoluntary, metrics.Counts.CtxSwitchVoluntary = 0, 0
+		metrics.MinNice, metrics.MaxNice = threads[0].Nice, threads[0].Nice
 		for _, thread := range threads {
 			metrics.Counts.CtxSwitchNonvoluntary += thread.Counts.CtxSwitchNonvoluntary
 			metrics.Counts.CtxSwitchVoluntary += thread.Counts.CtxSwitchVoluntary
 			metrics.States.Add(thread.States)
+			if t.threadCPUs {
+				metrics.ThreadCPUs[thread.Processor]++
+			}
+			if t.threadPolicies {
+				metrics.ThreadPolicies[thread.Policy]++
+			}
+			if thread.Nice < metrics.MinNice {
+				metrics.MinNice = thread.Nice
+			}
+			if thread.Nice > metrics.MaxNice {
+				metrics.MaxNice = thread.Nice
+			}
+		}
+	} else {
+		if t.threadCPUs {
+			metrics.ThreadCPUs[metrics.Processor] = 1
+		}
+		if t.threadPolicies {
+			metrics.ThreadPolicies[metrics.Policy] = 1
 		}
 	}
 
 	var newProc *IDInfo
 	if known {
 		last.update(metrics, updateTime, &cerrs, threads)
+		if last.orphaned && last.adoptedBy == 0 {
+			// Static details are only read once, so reread them to find
+			// the new parent.
+			if static, err := proc.GetStatic(); err == nil {
+				last.adoptedBy = static.ParentPid
+			}
+		}
+		if last.unmatched && t.alwaysRecheck {
+			// The name or cmdline of a proc may change without an exec,
+			// e.g. with setproctitle, so reread them to name it again.
+			if static, err := proc.GetStatic(); err == nil {
+				last.static = static
+				t.rematch = append(t.rematch, procID)
+			}
+		}
 	} else {
 		static, err := proc.GetStatic()
 		if err != nil {
@@ -276,27 +579,76 @@ func (t *Tracker) handleProc(proc Proc, updateTime time.Time) (*IDInfo, CollectE
 		if t.debug {
 			log.Printf("found new proc: %s", newProc)
 		}
+		if identityErr == nil {
+			program, _ := proc.GetProgram()
+			t.identities[procID] = procIdentity{identity, program}
+		}
 
 		// Is this a new process with the same pid as one we already know?
 		// Then delete it from the known map, otherwise the cleanup in Update()
 		// will remove the ProcIds entry we're creating here.
-		if oldProcID, ok := t.procIds[procID.Pid]; ok {
+		if oldProcID, ok := t.procIds[procID.Pid]; ok && oldProcID != procID {
 			delete(t.tracked, oldProcID)
+			delete(t.ignored, oldProcID)
+			delete(t.identities, oldProcID)
 		}
 		t.procIds[procID.Pid] = procID
 	}
 	return newProc, cerrs
 }
 
+// relaidOut returns true if the program text or stack of a proc moved
+// between identities id and next, which only happens on exec.
+func (id Identity) relaidOut(next Identity) bool {
+	return id.StartCode != 0 && next.StartCode != 0 && (id.StartCode != next.StartCode ||
+		id.EndCode != next.EndCode || id.StartStack != next.StartStack)
+}
+
+// replacedBy returns true if a proc that ran program p, and whose name
+// changed, which procs may do without exec'ing, now runs next.
+func (p Program) replacedBy(next Program) bool {
+	switch {
+	case p == Program{} && next == Program{}:
+		// There's nothing but the name to go by.
+		return true
+	case p.Exe != "" && next.Exe != "":
+		return p.Exe != next.Exe
+	case p.Exe == "" && next.Exe == "" && p.CmdlineHash != 0 && next.CmdlineHash != 0:
+		return p.CmdlineHash != next.CmdlineHash
+	}
+	// The program was read from different sources, e.g. the exe link became
+	// unreadable, so there's no telling.
+	return false
+}
+
+// reexec forgets what the tracker knows of a proc that exec'd another
+// program, so that handleProc treats it as new, remembering its tracked
+// state for track to carry over.
+func (t *Tracker) reexec(procID ID, old, identity procIdentity) {
+	if t.debug {
+		log.Printf("proc %+v exec'd: identity changed from %+v to %+v", procID, old, identity)
+	}
+	if t.execed == nil {
+		t.execed = make(map[ID]*trackedProc)
+	}
+	t.execed[procID] = t.tracked[procID]
+	delete(t.tracked, procID)
+	delete(t.ignored, procID)
+	delete(t.identities, procID)
+	t.stats.Execs++
+}
+
 // update scans procs and updates metrics for those which are tracked. Processes
 // that have gone away get removed from the Tracked map. New processes are
 // returned, along with the count of nonfatal errors.
-func (t *Tracker) update(procs Iter) ([]IDInfo, CollectErrors, error) {
+func (t *Tracker) update(procs Iter, now time.Time) ([]IDInfo, CollectErrors, error) {
 	var newProcs []IDInfo
 	var colErrs CollectErrors
-	var now = time.Now()
+	t.zombieParents = t.zombieParents[:0]
+	t.rematch = t.rematch[:0]
 
 	for procs.Next() {
+		t.stats.Procs++
 		newProc, cerrs := t.handleProc(procs, now)
 		if newProc != nil {
 			newProcs = append(newProcs, *newProc)
@@ -314,26 +666,94 @@ func (t *Tracker) update(procs Iter) ([]IDInfo, CollectErrors, error) {
 	// disappeared, we bump the last update time on those that are still
 	// present.  Then as a second pass we traverse the map looking for
 	// stale procs and removing them.
+	var exited map[int]ID
 	for procID, pinfo := range t.tracked {
-		if pinfo == nil {
-			// TODO is this a bug? we're not tracking the proc so we don't see it go away so ProcIds
-			// and Tracked are leaking?
-			continue
-		}
 		if pinfo.lastUpdate != now {
+			t.creditParent(procID, pinfo, now)
 			delete(t.tracked, procID)
-			delete(t.procIds, procID.Pid)
+			t.forgetPid(procID)
+			if exited == nil {
+				exited = make(map[int]ID)
+			}
+			exited[procID.Pid] = procID
+		}
+	}
+	if exited != nil {
+		t.markOrphans(exited)
+	}
+	for procID, lastSeen := range t.ignored {
+		if lastSeen != now {
+			delete(t.ignored, procID)
+			t.forgetPid(procID)
 		}
 	}
 
 	return newProcs, colErrs, nil
 }
 
+// markOrphans flags the tracked procs whose parent is among the tracked
+// procs that just exited.
+func (t *Tracker) markOrphans(exited map[int]ID) {
+	for id, tproc := range t.tracked {
+		if tproc.orphaned {
+			continue
+		}
+		if pid, ok := exited[tproc.static.ParentPid]; ok && pid.StartTimeRel <= id.StartTimeRel {
+			tproc.orphaned = true
+		}
+	}
+}
+
+// creditParent ensures that the CPU time of tproc, which has exited, isn't
+// counted a second time as part of its parent's children CPU time once the
+// parent has waited for it.
+func (t *Tracker) creditParent(id ID, tproc *trackedProc, now time.Time) {
+	pid, ok := t.procIds[tproc.static.ParentPid]
+	if !ok || pid.StartTimeRel > id.StartTimeRel {
+		// Parent is unknown, or its pid has been reused.
+		return
+	}
+	parent, ok := t.tracked[pid]
+	if !ok || parent.lastUpdate != now {
+		return
+	}
+
+	// Children times include those of grandchildren waited for by the child.
+	c := tproc.metrics.Counts
+	parent.childCredit.user += c.CPUUserTime + c.CPUChildrenUserTime
+	parent.childCredit.system += c.CPUSystemTime + c.CPUChildrenSystemTime
+	parent.childCredit.apply(&parent.lastaccum)
+}
+
+// apply deducts as much of the credit as possible from the children CPU
+// times in d, keeping the remainder.
+func (c *cpuCredit) apply(d *Delta) {
+	c.user, d.CPUChildrenUserTime = deduct(c.user, d.CPUChildrenUserTime)
+	c.system, d.CPUChildrenSystemTime = deduct(c.system, d.CPUChildrenSystemTime)
+}
+
+// deduct returns what's left of credit and of v after deducting one from
+// the other.
+func deduct(credit, v float64) (float64, float64) {
+	if credit > v {
+		return credit - v, 0
+	}
+	return 0, v - credit
+}
+
+// forgetPid removes the procIds entry for procID's pid, unless it has
+// already been claimed by a newer proc reusing the same pid.
+func (t *Tracker) forgetPid(procID ID) {
+	if t.procIds[procID.Pid] == procID {
+		delete(t.procIds, procID.Pid)
+	}
+}
+
 // checkAncestry walks the process tree recursively towards the root,
 // stopping at pid 1 or upon finding a parent that's already tracked
 // or ignored.  If we find a tracked parent track this one too; if not,
-// ignore this one.
-func (t *Tracker) checkAncestry(idinfo IDInfo, newprocs map[ID]IDInfo) string {
+// ignore this one, or track it in the catch-all group if there is one.
+func (t *Tracker) checkAncestry(idinfo IDInfo, newprocs map[ID]IDInfo, now time.Time) string {
 	ppid := idinfo.ParentPid
 	pProcID := t.procIds[ppid]
 	if pProcID.Pid < 1 {
@@ -341,36 +761,27 @@ func (t *Tracker) checkAncestry(idinfo IDInfo, newprocs map[ID]IDInfo) string {
 			log.Printf("ignoring unmatched proc with no matched parent: %+v", idinfo)
 		}
 		// Reached root of process tree without finding a tracked parent.
-		t.ignore(idinfo.ID)
+		t.unmatched(idinfo, now)
 		return ""
 	}
 
 	// Is the parent already known to the tracker?
 	if ptproc, ok := t.tracked[pProcID]; ok {
-		if ptproc != nil {
-			if t.debug {
-				log.Printf("matched as %q because child of %+v: %+v",
-					ptproc.groupName, pProcID, idinfo)
-			}
-			// We've found a tracked parent.
-			t.track(ptproc.groupName, idinfo)
-			return ptproc.groupName
-		}
+		// We've found a tracked parent.
+		return t.inherit(ptproc, ppid, idinfo, now)
+	}
+	if _, ok := t.ignored[pProcID]; ok {
 		// We've found an untracked parent.
-		t.ignore(idinfo.ID)
+		t.unmatched(idinfo, now)
 		return ""
 	}
 
 	// Is the parent another new process?
 	if pinfoid, ok := newprocs[pProcID]; ok {
-		if name := t.checkAncestry(pinfoid, newprocs); name != "" {
-			if t.debug {
-				log.Printf("matched as %q because child of %+v: %+v",
-					name, pProcID, idinfo)
-			}
-			// We've found a tracked parent, which implies this entire lineage should be tracked.
-			t.track(name, idinfo)
-			return name
+		if name := t.checkAncestry(pinfoid, newprocs, now); name != "" {
+			// We've found a tracked parent, which implies this entire
+			// lineage may be tracked.
+			return t.inherit(t.tracked[pProcID], ppid, idinfo, now)
 		}
 	}
 
@@ -378,10 +789,32 @@ func (t *Tracker) checkAncestry(idinfo IDInfo, newprocs map[ID]IDInfo) string {
 	if t.debug {
 		log.Printf("ignoring unmatched proc with no matched parent: %+v", idinfo)
 	}
-	t.ignore(idinfo.ID)
+	t.unmatched(idinfo, now)
 	return ""
 }
 
+// attributes returns what the namer is given of a proc.
+func (t *Tracker) attributes(id ID, static Static) common.ProcAttributes {
+	return common.ProcAttributes{
+		Name:      static.Name,
+		Cmdline:   static.Cmdline,
+		Cgroups:   static.Cgroups,
+		Username:  t.lookupUid(static.EffectiveUID),
+		PID:       id.Pid,
+		StartTime: static.StartTime,
+	}
+}
+
+// matchAndName names a proc using the namer, also returning the rule that
+// matched if the namer is a common.RuleMatchNamer, or common.NoRule.
+func (t *Tracker) matchAndName(nacl common.ProcAttributes) (bool, string, int) {
+	if rn, ok := t.namer.(common.RuleMatchNamer); ok {
+		return rn.MatchAndNameRule(nacl)
+	}
+	wanted, gname := t.namer.MatchAndName(nacl)
+	return wanted, gname, common.NoRule
+}
+
 func (t *Tracker) lookupUid(uid int) string {
 	if name, ok := t.username[uid]; ok {
 		return name
@@ -404,54 +837,179 @@ func (t *Tracker) lookupUid(uid int) string {
 // its metrics for existing tracked procs.  Returns nonfatal errors
 // and the status of all tracked procs, or an error if fatal.
 func (t *Tracker) Update(iter Iter) (CollectErrors, []Update, error) {
+	start, now := time.Now(), iterTime(iter)
 	if t.firstUpdateAt.IsZero() {
-		t.firstUpdateAt = time.Now()
+		t.firstUpdateAt = now
 	}
 
-	newProcs, colErrs, err := t.update(iter)
+	t.stats = UpdateStats{}
+	t.execed = nil
+	newProcs, colErrs, err := t.update(iter, now)
+	t.stats.UpdateTime = time.Since(start)
 	if err != nil {
 		return colErrs, nil, err
 	}
 
+	// Step 0: when rechecking, move procs in the catch-all group to the
+	// group they're now named in, if any.
+	for _, id := range t.rematch {
+		tproc := t.tracked[id]
+		wanted, gname, rule := t.matchAndName(t.attributes(id, tproc.static))
+		if !wanted {
+			continue
+		}
+		if t.debug {
+			log.Printf("rematched as %q: %+v", gname, id)
+		}
+		tproc.groupName, tproc.baseGroup, tproc.rule = gname, gname, rule
+		tproc.unmatched, tproc.inheritedFrom, tproc.depth = false, 0, 0
+	}
+
 	// Step 1: track any new proc that should be tracked based on its name and cmdline.
 	untracked := make(map[ID]IDInfo)
 	for _, idinfo := range newProcs {
-		nacl := common.ProcAttributes{
-			Name:      idinfo.Name,
-			Cmdline:   idinfo.Cmdline,
-			Cgroups:   idinfo.Cgroups,
-			Username:  t.lookupUid(idinfo.EffectiveUID),
-			PID:       idinfo.Pid,
-			StartTime: idinfo.StartTime,
-		}
-		wanted, gname := t.namer.MatchAndName(nacl)
+		wanted, gname, rule := t.matchAndName(t.attributes(idinfo.ID, idinfo.Static))
 		if wanted {
 			if t.debug {
 				log.Printf("matched as %q: %+v", gname, idinfo)
 			}
-			t.track(gname, idinfo)
-		} else {
+			t.track(gname, rule, 0, idinfo)
+		} else if t.checksAncestry() {
 			untracked[idinfo.ID] = idinfo
+		} else if t.catchAll != "" {
+			t.unmatched(idinfo, now)
 		}
+		// Otherwise the proc is neither tracked nor ignored, so it's named
+		// again next cycle in case it changed its name.
 	}
 
 	// Step 2: track any untracked new proc that should be tracked because its parent is tracked.
-	if t.trackChildren {
+	if t.checksAncestry() {
+		start := time.Now()
 		for _, idinfo := range untracked {
 			if _, ok := t.tracked[idinfo.ID]; ok {
-				// Already tracked or ignored in an earlier iteration
+				// Already tracked in an earlier iteration
+				continue
+			}
+			if _, ok := t.ignored[idinfo.ID]; ok {
+				// Already ignored in an earlier iteration
 				continue
 			}
 
-			t.checkAncestry(idinfo, untracked)
+			t.checkAncestry(idinfo, untracked, now)
+		}
+		t.stats.AncestryTime = time.Since(start)
+	}
+
+	if t.alwaysRecheck || !t.checksAncestry() {
+		// Untracked procs aren't ignored when rechecking, nor when not
+		// checking ancestry, so forget their pids now; they'll be looked at
+		// again as new procs next cycle.
+		for pid, procID := range t.procIds {
+			_, tracked := t.tracked[procID]
+			_, ignored := t.ignored[procID]
+			if !tracked && !ignored {
+				delete(t.procIds, pid)
+			}
+		}
+	}
+
+	// Forget the identities of procs that are neither tracked nor ignored,
+	// e.g. because they exited or when rechecking.
+	for id := range t.identities {
+		_, tracked := t.tracked[id]
+		_, ignored := t.ignored[id]
+		if !tracked && !ignored {
+			delete(t.identities, id)
+		}
+	}
+
+	t.unreaped = make(map[string]int)
+	for _, ppid := range t.zombieParents {
+		if parent, ok := t.tracked[t.procIds[ppid]]; ok {
+			t.unreaped[parent.groupName]++
 		}
 	}
 
 	tp := []Update{}
 	for _, tproc := range t.tracked {
-		if tproc != nil {
-			tp = append(tp, tproc.getUpdate())
-		}
+		tp = append(tp, tproc.getUpdate())
 	}
 	return colErrs, tp, nil
 }
+
+// Unreaped returns the number of zombies whose parent is tracked, by the
+// group of the parent, as of the last Update.
+func (t *Tracker) Unreaped() map[string]int {
+	return t.unreaped
+}
+
+// Orphans returns, for each group, how many of its procs have been adopted
+// by another proc after their tracked parent exited.
+func (t *Tracker) Orphans() map[string]Orphans {
+	orphans := make(map[string]Orphans)
+	for _, tproc := range t.tracked {
+		if !tproc.orphaned || tproc.adoptedBy == 0 {
+			continue
+		}
+		o := orphans[tproc.groupName]
+		if tproc.adoptedBy == 1 {
+			o.Init++
+		} else {
+			o.Subreaper++
+		}
+		orphans[tproc.groupName] = o
+	}
+	return orphans
+}
+
+// Attributes returns, for each group with tracked procs, what its procs
+// have in common.
+func (t *Tracker) Attributes() map[string]GroupAttributes {
+	attrs := make(map[string]GroupAttributes)
+	for _, tproc := range t.tracked {
+		gname, comm, owner := tproc.groupName, tproc.static.Name, t.lookupUid(tproc.static.EffectiveUID)
+		a, ok := attrs[gname]
+		if !ok {
+			attrs[gname] = GroupAttributes{comm, owner}
+			continue
+		}
+		// Once cleared, fields stay empty.
+		if a.ExecutableName != comm {
+			a.ExecutableName = ""
+		}
+		if a.Owner != owner {
+			a.Owner = ""
+		}
+		attrs[gname] = a
+	}
+	return attrs
+}
+
+// Tracked returns a description of each proc currently being tracked.
+func (t *Tracker) Tracked() []TrackedProc {
+	var tps []TrackedProc
+	for id, tproc := range t.tracked {
+		tps = append(tps, TrackedProc{id, tproc.static, tproc.groupName, tproc.inheritedFrom, tproc.unmatched})
+	}
+	return tps
+}
+
+// Ignored returns the IDs of procs the Tracker has decided not to track.
+func (t *Tracker) Ignored() []ID {
+	ids := make([]ID, 0, len(t.ignored))
+	for id := range t.ignored {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// NumEntries returns how many procs are currently tracked and ignored.
+func (t *Tracker) NumEntries() (tracked, ignored int) {
+	return len(t.tracked), len(t.ignored)
+}
+
+// Stats describes the work done by the most recent Update.
+func (t *Tracker) Stats() UpdateStats {
+	return t.stats
+}
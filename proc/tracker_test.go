@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	common "github.com/ncabatoff/process-exporter"
 )
 
 // Verify that the tracker finds and tracks or ignores procs based on the
@@ -99,15 +100,15 @@ func TestTrackerMetrics(t *testing.T) {
 		want Update
 	}{
 		{
-			piinfost(p, n, Counts{1, 2, 3, 4, 5, 6, 0, 0}, Memory{7, 8, 0, 0, 0},
+			piinfost(p, n, Counts{1, 2, 3, 4, 5, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{7, 8, 0, 0, 0},
 				Filedesc{1, 10}, 9, States{Sleeping: 1}),
 			Update{n, Delta{}, Memory{7, 8, 0, 0, 0}, Filedesc{1, 10}, tm,
 				9, States{Sleeping: 1}, msi{}, nil},
 		},
 		{
-			piinfost(p, n, Counts{2, 3, 4, 5, 6, 7, 0, 0}, Memory{1, 2, 0, 0, 0},
+			piinfost(p, n, Counts{2, 3, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{1, 2, 0, 0, 0},
 				Filedesc{2, 20}, 1, States{Running: 1}),
-			Update{n, Delta{1, 1, 1, 1, 1, 1, 0, 0}, Memory{1, 2, 0, 0, 0},
+			Update{n, Delta{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Memory{1, 2, 0, 0, 0},
 				Filedesc{2, 20}, tm, 1, States{Running: 1}, msi{}, nil},
 		},
 	}
@@ -134,8 +135,8 @@ func TestTrackerThreads(t *testing.T) {
 			Update{n, Delta{}, Memory{}, Filedesc{1, 1}, tm, 1, States{}, msi{}, nil},
 		}, {
 			piinfot(p, n, Counts{}, Memory{}, Filedesc{1, 1}, []Thread{
-				{ThreadID(ID{p, 0}), "t1", Counts{1, 2, 3, 4, 5, 6, 0, 0}, "", States{}},
-				{ThreadID(ID{p + 1, 0}), "t2", Counts{1, 1, 1, 1, 1, 1, 0, 0}, "", States{}},
+				{ThreadID(ID{p, 0}), "t1", Counts{1, 2, 3, 4, 5, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 1, 0}), "t2", Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
 			}),
 			Update{n, Delta{}, Memory{}, Filedesc{1, 1}, tm, 2, States{}, msi{},
 				[]ThreadUpdate{
@@ -145,26 +146,26 @@ func TestTrackerThreads(t *testing.T) {
 			},
 		}, {
 			piinfot(p, n, Counts{}, Memory{}, Filedesc{1, 1}, []Thread{
-				{ThreadID(ID{p, 0}), "t1", Counts{2, 3, 4, 5, 6, 7, 0, 0}, "", States{}},
-				{ThreadID(ID{p + 1, 0}), "t2", Counts{2, 2, 2, 2, 2, 2, 0, 0}, "", States{}},
-				{ThreadID(ID{p + 2, 0}), "t2", Counts{1, 1, 1, 1, 1, 1, 0, 0}, "", States{}},
+				{ThreadID(ID{p, 0}), "t1", Counts{2, 3, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 1, 0}), "t2", Counts{2, 2, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 2, 0}), "t2", Counts{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
 			}),
 			Update{n, Delta{}, Memory{}, Filedesc{1, 1}, tm, 3, States{}, msi{},
 				[]ThreadUpdate{
-					{"t1", Delta{1, 1, 1, 1, 1, 1, 0, 0}},
-					{"t2", Delta{1, 1, 1, 1, 1, 1, 0, 0}},
+					{"t1", Delta{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+					{"t2", Delta{1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
 					{"t2", Delta{}},
 				},
 			},
 		}, {
 			piinfot(p, n, Counts{}, Memory{}, Filedesc{1, 1}, []Thread{
-				{ThreadID(ID{p, 0}), "t1", Counts{2, 3, 4, 5, 6, 7, 0, 0}, "", States{}},
-				{ThreadID(ID{p + 2, 0}), "t2", Counts{1, 2, 3, 4, 5, 6, 0, 0}, "", States{}},
+				{ThreadID(ID{p, 0}), "t1", Counts{2, 3, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
+				{ThreadID(ID{p + 2, 0}), "t2", Counts{1, 2, 3, 4, 5, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "", States{}, 0, 0, 0},
 			}),
 			Update{n, Delta{}, Memory{}, Filedesc{1, 1}, tm, 2, States{}, msi{},
 				[]ThreadUpdate{
 					{"t1", Delta{}},
-					{"t2", Delta{0, 1, 2, 3, 4, 5, 0, 0}},
+					{"t2", Delta{0, 1, 2, 3, 4, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
 				},
 			},
 		},
@@ -180,3 +181,611 @@ func TestTrackerThreads(t *testing.T) {
 		}
 	}
 }
+
+// TestTrackerTracked verifies that Tracked reports whether each proc was
+// matched directly or inherited its group from a parent.
+func TestTrackerTracked(t *testing.T) {
+	p1, p2, p3 := 1, 2, 3
+	n1, n2, n3 := "g1", "g2", "g3"
+
+	tr := NewTracker(newNamer(n2), true, false, false)
+	_, _, err := tr.Update(procInfoIter(
+		newProcParent(p1, n1, 0),
+		newProcParent(p2, n2, p1),
+		newProcParent(p3, n3, p2),
+	))
+	noerr(t, err)
+
+	got := make(map[int]TrackedProc)
+	for _, tp := range tr.Tracked() {
+		got[tp.Pid] = tp
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d tracked procs, want 2", len(got))
+	}
+	if tp := got[p2]; tp.GroupName != n2 || tp.InheritedFrom != 0 {
+		t.Errorf("pid %d: got group %q inherited from %d, want %q from 0", p2, tp.GroupName, tp.InheritedFrom, n2)
+	}
+	if tp := got[p3]; tp.GroupName != n2 || tp.InheritedFrom != p2 {
+		t.Errorf("pid %d: got group %q inherited from %d, want %q from %d", p3, tp.GroupName, tp.InheritedFrom, n2, p2)
+	}
+}
+
+// TestTrackerAttributes verifies that group attributes are only set when all
+// the procs of the group agree.
+func TestTrackerAttributes(t *testing.T) {
+	p1, p2, p3, p4 := 1, 2, 3, 4
+	n1, n2 := "g1", "g2"
+	withUID := func(idinfo IDInfo, uid int) IDInfo {
+		idinfo.EffectiveUID = uid
+		return idinfo
+	}
+
+	tr := NewTracker(newNamer(n1, n2), true, false, false)
+	_, _, err := tr.Update(procInfoIter(
+		withUID(newProcParent(p1, n1, 0), 54321),
+		withUID(newProcParent(p2, "other", p1), 54321),
+		withUID(newProcParent(p3, n2, 0), 54321),
+		withUID(newProcParent(p4, n2, 0), 54322),
+	))
+	noerr(t, err)
+
+	want := map[string]GroupAttributes{
+		n1: {Owner: "54321"},
+		n2: {ExecutableName: n2},
+	}
+	if diff := cmp.Diff(tr.Attributes(), want); diff != "" {
+		t.Errorf("attributes differ: (-got +want)\n%s", diff)
+	}
+}
+
+// TestTrackerChildrenCPU verifies that the CPU time of a tracked child isn't
+// counted again as its parent's children time once the parent reaps it, while
+// that of untracked children is.
+func TestTrackerChildrenCPU(t *testing.T) {
+	p1, p2 := 1, 2
+	n1, n2 := "g1", "g2"
+	withCounts := func(idinfo IDInfo, c Counts) IDInfo {
+		idinfo.Counts = c
+		return idinfo
+	}
+
+	tests := []struct {
+		procs []IDInfo
+		want  Delta
+	}{
+		{
+			[]IDInfo{
+				newProcParent(p1, n1, 0),
+				withCounts(newProcParent(p2, n2, p1), Counts{CPUUserTime: 2, CPUSystemTime: 1}),
+			},
+			Delta{},
+		},
+		{
+			// p2 has exited and been reaped: only the time it spent since
+			// the last cycle is new.
+			[]IDInfo{
+				withCounts(newProcParent(p1, n1, 0), Counts{CPUChildrenUserTime: 3, CPUChildrenSystemTime: 1}),
+			},
+			Delta{CPUChildrenUserTime: 1},
+		},
+		{
+			// An untracked child was reaped.
+			[]IDInfo{
+				withCounts(newProcParent(p1, n1, 0), Counts{CPUChildrenUserTime: 8, CPUChildrenSystemTime: 2}),
+			},
+			Delta{CPUChildrenUserTime: 5, CPUChildrenSystemTime: 1},
+		},
+	}
+
+	tr := NewTracker(newNamer(n1), true, false, false)
+	for i, tc := range tests {
+		_, got, err := tr.Update(procInfoIter(tc.procs...))
+		noerr(t, err)
+		var latest Delta
+		for _, u := range got {
+			latest.CPUChildrenUserTime += u.Latest.CPUChildrenUserTime
+			latest.CPUChildrenSystemTime += u.Latest.CPUChildrenSystemTime
+		}
+		if diff := cmp.Diff(latest, tc.want); diff != "" {
+			t.Errorf("%d: children CPU differs: (-got +want)\n%s", i, diff)
+		}
+	}
+}
+
+// TestTrackerTree verifies that tracked procs are arranged by parentage,
+// that procs with an untracked parent are roots, and that tree totals
+// include all descendants.
+func TestTrackerTree(t *testing.T) {
+	p1, p2, p3, p4, p5 := 1, 2, 3, 4, 5
+	n1, n2 := "g1", "g2"
+	withUsage := func(idinfo IDInfo, rss uint64, user float64) IDInfo {
+		idinfo.ResidentBytes = rss
+		idinfo.CPUUserTime = user
+		idinfo.CPUSystemTime = 1
+		return idinfo
+	}
+
+	tr := NewTracker(newNamer(n1, n2), true, false, false)
+	_, _, err := tr.Update(procInfoIter(
+		withUsage(newProcParent(p1, n1, 0), 100, 1),
+		withUsage(newProcParent(p3, "other", p1), 300, 3),
+		withUsage(newProcParent(p2, "other", p1), 200, 2),
+		withUsage(newProcParent(p4, "other", p2), 400, 4),
+		withUsage(newProcParent(p5, n2, 9999), 500, 5),
+	))
+	noerr(t, err)
+
+	want := []*TreeNode{
+		{p1, n1, n1, 100, 2, 1000, 14, []*TreeNode{
+			{p2, "other", n1, 200, 3, 600, 8, []*TreeNode{
+				{p4, "other", n1, 400, 5, 400, 5, []*TreeNode{}},
+			}},
+			{p3, "other", n1, 300, 4, 300, 4, []*TreeNode{}},
+		}},
+		{p5, n2, n2, 500, 6, 500, 6, []*TreeNode{}},
+	}
+	if diff := cmp.Diff(tr.Tree(), want); diff != "" {
+		t.Errorf("tree differs: (-got +want)\n%s", diff)
+	}
+}
+
+// TestTrackerExec verifies that procs which exec another program are named
+// again, and that counts are only attributed to the new group from then on.
+func TestTrackerExec(t *testing.T) {
+	p1, p2 := 1, 2
+	n1, n2 := "g1", "g2"
+	withCPU := func(idinfo IDInfo, user float64) IDInfo {
+		idinfo.CPUUserTime = user
+		return idinfo
+	}
+
+	tests := []struct {
+		procs []IDInfo
+		execs int
+		want  map[string]float64
+	}{
+		{
+			[]IDInfo{withCPU(newProcParent(p1, n1, 0), 1), withCPU(newProcParent(p2, "sh", 0), 1)},
+			0,
+			map[string]float64{n1: 0},
+		},
+		{
+			// p1 exec'd from g1 to g2, p2 from an ignored program to g1.
+			[]IDInfo{withCPU(newProcParent(p1, n2, 0), 3), withCPU(newProcParent(p2, n1, 0), 5)},
+			2,
+			map[string]float64{n1: 0, n2: 2},
+		},
+		{
+			[]IDInfo{withCPU(newProcParent(p1, n2, 0), 4), withCPU(newProcParent(p2, n1, 0), 7)},
+			0,
+			map[string]float64{n1: 2, n2: 1},
+		},
+	}
+
+	// Children tracking makes unmatched procs be ignored rather than named
+	// again every cycle, so that p2 is known when it execs.
+	tr := NewTracker(newNamer(n1, n2), true, false, false)
+	for i, tc := range tests {
+		_, updates, err := tr.Update(procInfoIter(tc.procs...))
+		noerr(t, err)
+		got := make(map[string]float64)
+		for _, u := range updates {
+			got[u.GroupName] += u.Latest.CPUUserTime
+		}
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("%d: CPU by group differs: (-got +want)\n%s", i, diff)
+		}
+		if execs := tr.Stats().Execs; execs != tc.execs {
+			t.Errorf("%d: got %d execs, want %d", i, execs, tc.execs)
+		}
+	}
+}
+
+// programProc is a Proc with a given identity and program, counting how
+// often the program is read.
+type programProc struct {
+	IDInfo
+	identity Identity
+	program  Program
+	reads    *int
+}
+
+func (p programProc) GetIdentity() (Identity, error) {
+	return p.identity, nil
+}
+
+func (p programProc) GetProgram() (Program, error) {
+	*p.reads++
+	return p.program, nil
+}
+
+// programProcs implements procs with programProcs.
+type programProcs []programProc
+
+func (p programProcs) get(i int) Proc {
+	return p[i]
+}
+
+func (p programProcs) length() int {
+	return len(p)
+}
+
+// TestTrackerExecProgram verifies that the program of a known proc is only
+// read when its identity changes, and that a proc is taken to have exec'd
+// when its addresses change or it runs another program, but not when only
+// its name or where its program was read from changes.
+func TestTrackerExecProgram(t *testing.T) {
+	addrs := func(name string, base uint64) Identity {
+		return Identity{Name: name, StartCode: base, EndCode: base + 1, StartStack: base + 2}
+	}
+	tests := []struct {
+		identity Identity
+		program  Program
+		reads    int
+		execs    int
+	}{
+		// New procs have their program read.
+		{addrs("g1", 10), Program{Exe: "/bin/a"}, 1, 0},
+		{addrs("g1", 10), Program{Exe: "/bin/a"}, 0, 0},
+		// A rename, e.g. with prctl.
+		{addrs("g2", 10), Program{Exe: "/bin/a"}, 1, 0},
+		// The exe became unreadable.
+		{addrs("g1", 10), Program{CmdlineHash: 7}, 1, 0},
+		// New addresses are enough to tell an exec, after which the proc
+		// is read as a new one.
+		{addrs("g2", 20), Program{Exe: "/bin/b"}, 1, 1},
+		// Without addresses, the program tells.
+		{Identity{Name: "g1"}, Program{Exe: "/bin/b"}, 1, 0},
+		{Identity{Name: "g2"}, Program{Exe: "/bin/c"}, 2, 1},
+		{Identity{Name: "g2"}, Program{Exe: "/bin/c"}, 0, 0},
+	}
+
+	tr := NewTracker(newNamer("g1", "g2"), false, false, false)
+	for i, tc := range tests {
+		var reads int
+		p := programProc{newProcParent(1, tc.identity.Name, 0), tc.identity, tc.program, &reads}
+		_, _, err := tr.Update(&procIterator{procs: programProcs{p}, idx: -1})
+		noerr(t, err)
+		if reads != tc.reads {
+			t.Errorf("%d: got %d program reads, want %d", i, reads, tc.reads)
+		}
+		if execs := tr.Stats().Execs; execs != tc.execs {
+			t.Errorf("%d: got %d execs, want %d", i, execs, tc.execs)
+		}
+	}
+}
+
+// childrenPolicy implements ChildrenPolicy for testing.
+type childrenPolicy struct {
+	inherit  map[int]bool
+	maxDepth int
+	suffix   bool
+}
+
+func (cp childrenPolicy) InheritChildren(rule int) (bool, bool) {
+	inherit, ok := cp.inherit[rule]
+	return inherit, ok
+}
+
+func (cp childrenPolicy) MaxChildDepth(rule int) int {
+	return cp.maxDepth
+}
+
+func (cp childrenPolicy) ChildGroupName(rule int, groupname, comm string) string {
+	if cp.suffix {
+		return groupname + "/child:" + comm
+	}
+	return groupname
+}
+
+// TestTrackerChildrenPolicy verifies that descendants are tracked per the
+// children policy of their matched ancestor's group, up to the depth limit,
+// optionally in suffixed groups.
+func TestTrackerChildrenPolicy(t *testing.T) {
+	p1, p2, p3, p4, p5, p6 := 1, 2, 3, 4, 5, 6
+	n1, n2, n3 := "g1", "g2", "g3"
+	procs := []IDInfo{
+		newProcParent(p1, n1, 0),
+		newProcParent(p2, "c2", p1),
+		newProcParent(p3, "c3", p2),
+		newProcParent(p4, "c4", p3),
+		newProcParent(p5, n2, 0),
+		newProcParent(p6, "c6", p5),
+		newProcParent(7, n3, 0),
+		newProcParent(8, "c8", 7),
+	}
+
+	tests := []struct {
+		children bool
+		cp       childrenPolicy
+		want     map[int]string
+	}{
+		{
+			false,
+			childrenPolicy{inherit: map[int]bool{0: true}, maxDepth: 2},
+			map[int]string{p1: n1, p2: n1, p3: n1, p5: n2, 7: n3},
+		},
+		{
+			true,
+			childrenPolicy{inherit: map[int]bool{1: false}, suffix: true},
+			map[int]string{p1: n1, p2: "g1/child:c2", p3: "g1/child:c3", p4: "g1/child:c4",
+				p5: n2, 7: n3, 8: "g3/child:c8"},
+		},
+	}
+
+	for i, tc := range tests {
+		tr := NewTracker(ruleNamer{n1, n2, n3}, tc.children, false, false)
+		tr.SetChildrenPolicy(tc.cp)
+		_, _, err := tr.Update(procInfoIter(procs...))
+		noerr(t, err)
+		got := make(map[int]string)
+		for _, tp := range tr.Tracked() {
+			got[tp.Pid] = tp.GroupName
+		}
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("%d: groups differ: (-got +want)\n%s", i, diff)
+		}
+	}
+}
+
+// TestTrackerReaping verifies that zombies are attributed to the group of
+// their parent, and that children of an exited tracked proc are reported as
+// adopted once their new parent is known.
+func TestTrackerReaping(t *testing.T) {
+	p1, p2, p3, p4 := 1000, 1001, 1002, 1003
+	n1, n2 := "g1", "g2"
+	zombie := func(idinfo IDInfo) IDInfo {
+		idinfo.States = States{Zombie: 1}
+		return idinfo
+	}
+
+	tr := NewTracker(newNamer(n1), true, false, false)
+	_, _, err := tr.Update(procInfoIter(
+		newProcParent(p1, n1, 0),
+		newProcParent(p2, n2, p1),
+		zombie(newProcParent(p3, n2, p1)),
+		zombie(newProcParent(p4, n2, 0)),
+	))
+	noerr(t, err)
+	if diff := cmp.Diff(tr.Unreaped(), map[string]int{n1: 1}); diff != "" {
+		t.Errorf("unreaped differs: (-got +want)\n%s", diff)
+	}
+
+	// p1 has exited and p2 has been adopted by init.
+	for i := 0; i < 2; i++ {
+		_, _, err = tr.Update(procInfoIter(newProcParent(p2, n2, 1)))
+		noerr(t, err)
+	}
+	if diff := cmp.Diff(tr.Unreaped(), map[string]int{}); diff != "" {
+		t.Errorf("unreaped differs: (-got +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(tr.Orphans(), map[string]Orphans{n1: {Init: 1}}); diff != "" {
+		t.Errorf("orphans differ: (-got +want)\n%s", diff)
+	}
+}
+
+// TestTrackerCatchAll verifies that with a catch-all group, procs the namer
+// doesn't select are tracked in it, including children of such procs, while
+// children of matched procs still inherit their parent's group.
+func TestTrackerCatchAll(t *testing.T) {
+	p1, p2, p3, p4 := 1, 2, 3, 4
+	n1, n2, n3, n4 := "g1", "g2", "g3", "g4"
+
+	tr := NewTracker(newNamer(n2), true, false, false)
+	tr.SetCatchAll("other")
+	_, _, err := tr.Update(procInfoIter(
+		newProcParent(p1, n1, 0),
+		newProcParent(p2, n2, p1),
+		newProcParent(p3, n3, p2),
+		newProcParent(p4, n4, p1),
+	))
+	noerr(t, err)
+
+	got := make(map[int]TrackedProc)
+	for _, tp := range tr.Tracked() {
+		got[tp.Pid] = tp
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d tracked procs, want 4", len(got))
+	}
+	if len(tr.Ignored()) != 0 {
+		t.Errorf("got ignored procs %v, want none", tr.Ignored())
+	}
+	for _, tc := range []struct {
+		pid       int
+		group     string
+		unmatched bool
+	}{
+		{p1, "other", true},
+		{p2, n2, false},
+		{p3, n2, false},
+		{p4, "other", true},
+	} {
+		if tp := got[tc.pid]; tp.GroupName != tc.group || tp.Unmatched != tc.unmatched {
+			t.Errorf("pid %d: got group %q unmatched=%v, want %q unmatched=%v",
+				tc.pid, tp.GroupName, tp.Unmatched, tc.group, tc.unmatched)
+		}
+	}
+}
+
+// TestTrackerUnmatched verifies that without children tracking unmatched
+// procs aren't ignored, but named again every cycle.
+func TestTrackerUnmatched(t *testing.T) {
+	p1, p2 := 1, 2
+	n1 := "g1"
+
+	tr := NewTracker(newNamer(n1), false, false, false)
+	_, _, err := tr.Update(procInfoIter(newProcParent(p1, n1, 0), newProcParent(p2, "sh", 0)))
+	noerr(t, err)
+	if tracked, ignored := tr.NumEntries(); tracked != 1 || ignored != 0 {
+		t.Errorf("got %d tracked and %d ignored, want 1 and 0", tracked, ignored)
+	}
+	if len(tr.procIds) != 1 {
+		t.Errorf("got %d pids, want 1", len(tr.procIds))
+	}
+
+	// p2 changed its name without exec'ing.
+	_, _, err = tr.Update(procInfoIter(newProcParent(p1, n1, 0), newProcParent(p2, n1, 0)))
+	noerr(t, err)
+	if tracked, ignored := tr.NumEntries(); tracked != 2 || ignored != 0 {
+		t.Errorf("got %d tracked and %d ignored, want 2 and 0", tracked, ignored)
+	}
+}
+
+// TestTrackerThreadCounts verifies that threads are only counted by CPU and
+// by scheduling policy when asked to.
+func TestTrackerThreadCounts(t *testing.T) {
+	for _, count := range []bool{false, true} {
+		tr := NewTracker(newNamer("g1"), false, false, false)
+		tr.threadCPUs, tr.threadPolicies = count, count
+		_, _, err := tr.Update(procInfoIter(newProcParent(1, "g1", 0)))
+		noerr(t, err)
+		for _, tproc := range tr.tracked {
+			m := tproc.metrics
+			if (m.ThreadCPUs != nil) != count || (m.ThreadPolicies != nil) != count {
+				t.Errorf("count=%v: got ThreadCPUs %v and ThreadPolicies %v",
+					count, m.ThreadCPUs, m.ThreadPolicies)
+			}
+		}
+	}
+}
+
+// timedIter is a TimedIter over procs read at time.
+type timedIter struct {
+	Iter
+	time time.Time
+}
+
+func (ti timedIter) Time() time.Time { return ti.time }
+
+// TestTrackerIterTime verifies that the tracker takes the time of updates
+// from a TimedIter, so that replayed procs which started between two
+// snapshots have all they used counted.
+func TestTrackerIterTime(t *testing.T) {
+	t0 := time.Unix(1000, 0).UTC()
+	p2 := newProc(2, "g1", Metrics{Counts: Counts{CPUUserTime: 3}})
+	p2.StartTime = t0.Add(5 * time.Second)
+
+	tr := NewTracker(newNamer("g1"), false, false, false)
+	_, _, err := tr.Update(timedIter{procInfoIter(newProcParent(1, "g1", 0)), t0})
+	noerr(t, err)
+	_, got, err := tr.Update(timedIter{procInfoIter(newProcParent(1, "g1", 0), p2), t0.Add(10 * time.Second)})
+	noerr(t, err)
+
+	var cpu float64
+	for _, u := range got {
+		cpu += u.Latest.CPUUserTime
+	}
+	if cpu != 3 {
+		t.Errorf("got %v CPU seconds, want 3", cpu)
+	}
+}
+
+// cmdlineNamer names procs whose first argument is the string after it.
+type cmdlineNamer string
+
+func (cn cmdlineNamer) String() string { return string(cn) }
+
+func (cn cmdlineNamer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
+	if len(nacl.Cmdline) > 0 && nacl.Cmdline[0] == string(cn) {
+		return true, string(cn)
+	}
+	return false, ""
+}
+
+// TestTrackerRecheckCatchAll verifies that when rechecking, procs in the
+// catch-all group are moved to the group they're named in once their cmdline
+// changes, e.g. with setproctitle, along with what they use from then on.
+func TestTrackerRecheckCatchAll(t *testing.T) {
+	p1 := 1
+	proc := func(cmdline string, cpu float64) IDInfo {
+		id, static := newProcIDStatic(p1, 0, 0, "postgres", []string{cmdline})
+		return IDInfo{id, static, Metrics{Counts: Counts{CPUUserTime: cpu}}, nil}
+	}
+
+	for _, recheck := range []bool{false, true} {
+		tr := NewTracker(cmdlineNamer("postgres: checkpointer"), false, recheck, false)
+		tr.SetCatchAll("other")
+		_, _, err := tr.Update(procInfoIter(proc("postgres", 1)))
+		noerr(t, err)
+		_, got, err := tr.Update(procInfoIter(proc("postgres: checkpointer", 3)))
+		noerr(t, err)
+
+		want := Update{GroupName: "other", Latest: Delta{CPUUserTime: 2}}
+		if recheck {
+			want.GroupName = "postgres: checkpointer"
+		}
+		if len(got) != 1 || got[0].GroupName != want.GroupName || got[0].Latest != want.Latest {
+			t.Errorf("recheck=%v: got %+v, want group %q with latest %+v",
+				recheck, got, want.GroupName, want.Latest)
+		}
+		if tps := tr.Tracked(); len(tps) != 1 || tps[0].Unmatched == recheck {
+			t.Errorf("recheck=%v: got tracked %+v", recheck, tps)
+		}
+	}
+}
+
+// churnSource is a Source whose procs each live for a few cycles before
+// being replaced by new procs with new pids, wrapping around so that pids
+// eventually get reused.
+type churnSource struct {
+	cycle   int
+	perIter int
+	life    int
+	maxPid  int
+}
+
+// AllProcs implements Source.
+func (cs *churnSource) AllProcs() Iter {
+	cs.cycle++
+	// pid 1 is the long-lived parent of all the odd pids.
+	procs := []IDInfo{newProcParent(1, "parent", 0)}
+	for c := cs.cycle - cs.life + 1; c <= cs.cycle; c++ {
+		if c < 1 {
+			continue
+		}
+		for i := 0; i < cs.perIter; i++ {
+			n := c*cs.perIter + i
+			pid := 2 + n%(cs.maxPid-2)
+			ppid := 0
+			if pid%2 == 1 {
+				ppid = 1
+			}
+			id, static := newProcIDStatic(pid, ppid, uint64(c), "child", nil)
+			procs = append(procs, IDInfo{id, static, Metrics{}, nil})
+		}
+	}
+	return procInfoIter(procs...)
+}
+
+// TestTrackerChurn verifies that the tracker forgets about procs, whether
+// tracked or ignored, once they've exited.
+func TestTrackerChurn(t *testing.T) {
+	cycles := 1000
+	if testing.Short() {
+		cycles = 100
+	}
+	src := &churnSource{perIter: 10, life: 3, maxPid: 500}
+
+	tr := NewTracker(newNamer("parent"), true, false, false)
+	for i := 0; i < cycles; i++ {
+		_, _, err := tr.Update(src.AllProcs())
+		noerr(t, err)
+
+		// Half the children (the odd pids) are tracked because of their parent,
+		// the rest are ignored.
+		live := src.perIter * src.life
+		if src.cycle < src.life {
+			live = src.perIter * src.cycle
+		}
+		tracked, ignored := tr.NumEntries()
+		if tracked != 1+live/2 || ignored != live/2 {
+			t.Fatalf("cycle %d: got %d tracked and %d ignored, want %d and %d",
+				src.cycle, tracked, ignored, 1+live/2, live/2)
+		}
+		if len(tr.procIds) != tracked+ignored {
+			t.Fatalf("cycle %d: got %d pids, want %d", src.cycle, len(tr.procIds), tracked+ignored)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package proc
+
+import "sort"
+
+// TreeNode describes a tracked proc and its tracked descendants.
+type TreeNode struct {
+	Pid       int    `json:"pid"`
+	Comm      string `json:"comm"`
+	GroupName string `json:"groupname"`
+	// ResidentBytes is the resident memory of the proc.
+	ResidentBytes uint64 `json:"resident_bytes"`
+	// CPUSeconds is the CPU time (user+system) used by the proc since it
+	// started, excluding that of its children.
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// TreeResidentBytes and TreeCPUSeconds are the sums of ResidentBytes
+	// and CPUSeconds over the proc and all its tracked descendants.
+	TreeResidentBytes uint64      `json:"tree_resident_bytes"`
+	TreeCPUSeconds    float64     `json:"tree_cpu_seconds"`
+	Children          []*TreeNode `json:"children"`
+}
+
+// Tree returns the tracked procs arranged by parentage, as of the last
+// Update.  Procs whose parent isn't tracked are roots.  Roots and the
+// children of each node are sorted by pid.
+func (t *Tracker) Tree() []*TreeNode {
+	nodes := make(map[int]*TreeNode, len(t.tracked))
+	for id, tproc := range t.tracked {
+		nodes[id.Pid] = &TreeNode{
+			Pid:           id.Pid,
+			Comm:          tproc.static.Name,
+			GroupName:     tproc.groupName,
+			ResidentBytes: tproc.metrics.ResidentBytes,
+			CPUSeconds:    tproc.metrics.CPUUserTime + tproc.metrics.CPUSystemTime,
+			Children:      []*TreeNode{},
+		}
+	}
+
+	roots := []*TreeNode{}
+	for id, tproc := range t.tracked {
+		node := nodes[id.Pid]
+		ppid := tproc.static.ParentPid
+		if parent, ok := nodes[ppid]; ok && ppid != id.Pid {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	sortTree(roots)
+	for _, root := range roots {
+		root.sum()
+	}
+	return roots
+}
+
+// sum sets the tree totals of n and its descendants.
+func (n *TreeNode) sum() {
+	n.TreeResidentBytes, n.TreeCPUSeconds = n.ResidentBytes, n.CPUSeconds
+	for _, child := range n.Children {
+		child.sum()
+		n.TreeResidentBytes += child.TreeResidentBytes
+		n.TreeCPUSeconds += child.TreeCPUSeconds
+	}
+}
+
+// sortTree sorts nodes and, recursively, their children by pid.
+func sortTree(nodes []*TreeNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Pid < nodes[j].Pid })
+	for _, n := range nodes {
+		sortTree(n.Children)
+	}
+}
@@ -0,0 +1,123 @@
+package push
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bufferSuffix is the suffix of the files holding buffered requests.
+const bufferSuffix = ".snappy"
+
+// diskBuffer holds the compressed remote-write requests that couldn't be
+// sent, one per file, until they can be.  Files are named after the time
+// they were written, so sorting their names gives the order to send them in.
+type diskBuffer struct {
+	dir string
+	// maxBytes is the size beyond which the oldest requests are dropped; 0
+	// means no limit.
+	maxBytes int64
+	// last is the time in the name of the newest file, to keep names
+	// increasing if the clock doesn't.
+	last int64
+}
+
+func newDiskBuffer(dir string, maxBytes int64) (*diskBuffer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	b := &diskBuffer{dir: dir, maxBytes: maxBytes}
+	names, err := b.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		b.last, _ = strconv.ParseInt(strings.TrimSuffix(names[len(names)-1], bufferSuffix), 10, 64)
+	}
+	return b, nil
+}
+
+// list returns the names of the buffered files, oldest first.
+func (b *diskBuffer) list() ([]string, error) {
+	entries, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Mode().IsRegular() && strings.HasSuffix(e.Name(), bufferSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// read returns the content of the buffered file name.
+func (b *diskBuffer) read(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(b.dir, name))
+}
+
+// remove deletes the buffered file name.
+func (b *diskBuffer) remove(name string) error {
+	return os.Remove(filepath.Join(b.dir, name))
+}
+
+// add buffers data, then drops the oldest files while the buffer is larger
+// than maxBytes.  It returns the number of files dropped.
+func (b *diskBuffer) add(data []byte) (int, error) {
+	ts := time.Now().UnixNano()
+	if ts <= b.last {
+		ts = b.last + 1
+	}
+	b.last = ts
+	name := fmt.Sprintf("%020d%s", ts, bufferSuffix)
+
+	// Write to a temporary file first, so that a crash doesn't leave a
+	// truncated request to be sent later.
+	tmp := filepath.Join(b.dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, filepath.Join(b.dir, name)); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if b.maxBytes <= 0 {
+		return 0, nil
+	}
+
+	entries, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		files []os.FileInfo
+		total int64
+	)
+	for _, e := range entries {
+		if e.Mode().IsRegular() && strings.HasSuffix(e.Name(), bufferSuffix) {
+			files = append(files, e)
+			total += e.Size()
+		}
+	}
+	// ReadDir sorts by name, i.e. oldest first.  Always keep the newest.
+	dropped := 0
+	for _, f := range files[:len(files)-1] {
+		if total <= b.maxBytes {
+			break
+		}
+		if err := b.remove(f.Name()); err != nil {
+			return dropped, err
+		}
+		total -= f.Size()
+		dropped++
+	}
+	return dropped, nil
+}
@@ -0,0 +1,85 @@
+package push
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ncabatoff/process-exporter/collector"
+)
+
+type (
+	// GraphiteOption configures a GraphiteSink.
+	GraphiteOption struct {
+		// Address is the host:port of the Graphite plaintext listener.
+		Address string
+		// Prefix, if not empty, is prepended to all metric paths.
+		Prefix string
+		// Timeout is the timeout for connecting and sending, 0 for none.
+		Timeout time.Duration
+	}
+
+	// GraphiteSink is a collector.Sink sending the group gauges and counter
+	// rates with the Graphite plaintext protocol.
+	GraphiteSink struct {
+		options GraphiteOption
+		conn    net.Conn
+	}
+)
+
+// NewGraphiteSink returns a GraphiteSink sending to options.Address.  The
+// connection is made on the first Send.
+func NewGraphiteSink(options GraphiteOption) (*GraphiteSink, error) {
+	if options.Address == "" {
+		return nil, fmt.Errorf("no Graphite address")
+	}
+	return &GraphiteSink{options: options}, nil
+}
+
+// Send implements collector.Sink, sending a line per sample of u.  If the
+// connection fails it's reopened and the lines sent once more.
+func (s *GraphiteSink) Send(u collector.SinkUpdate) error {
+	var b strings.Builder
+	ts := u.Time.Unix()
+	for _, sample := range u.Samples {
+		fmt.Fprintf(&b, "%s %s %d\n", metricPath(s.options.Prefix, sample), formatFloat(sample.Value), ts)
+	}
+	data := []byte(b.String())
+
+	err := s.write(data)
+	if err != nil && s.conn != nil {
+		s.Close()
+		err = s.write(data)
+	}
+	if err != nil {
+		s.Close()
+	}
+	return err
+}
+
+// write sends data, connecting first if needed.
+func (s *GraphiteSink) write(data []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.options.Address, s.options.Timeout)
+		if err != nil {
+			return fmt.Errorf("error connecting to Graphite: %v", err)
+		}
+		s.conn = conn
+	}
+	if s.options.Timeout > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.options.Timeout))
+	}
+	_, err := s.conn.Write(data)
+	return err
+}
+
+// Close closes the connection, if any.
+func (s *GraphiteSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,425 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ncabatoff/process-exporter/collector"
+	"github.com/ncabatoff/process-exporter/config"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoField describes a field of a message for protoFile.
+type protoField struct {
+	name   string
+	number int32
+	typ    descriptorpb.FieldDescriptorProto_Type
+	// message is the name of the message type of message fields.
+	message  string
+	repeated bool
+	// oneof is the name of the oneof the field belongs to, if any.
+	oneof string
+}
+
+// protoFile returns the descriptor of a proto3 file of package pkg holding
+// messages, each a list of fields, so that the upstream protobuf library
+// can encode and decode them without generated code.
+func protoFile(t *testing.T, pkg string, messages map[string][]protoField) protoreflect.FileDescriptor {
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(pkg + ".proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+	}
+	for name, fields := range messages {
+		dp := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+		for _, f := range fields {
+			label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+			if f.repeated {
+				label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+			}
+			fp := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(f.name),
+				JsonName: proto.String(f.name),
+				Number:   proto.Int32(f.number),
+				Label:    label.Enum(),
+				Type:     f.typ.Enum(),
+			}
+			if f.message != "" {
+				fp.TypeName = proto.String("." + pkg + "." + f.message)
+			}
+			if f.oneof != "" {
+				idx := len(dp.OneofDecl)
+				for i, od := range dp.OneofDecl {
+					if od.GetName() == f.oneof {
+						idx = i
+					}
+				}
+				if idx == len(dp.OneofDecl) {
+					dp.OneofDecl = append(dp.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(f.oneof)})
+				}
+				fp.OneofIndex = proto.Int32(int32(idx))
+			}
+			dp.Field = append(dp.Field, fp)
+		}
+		fdp.MessageType = append(fdp.MessageType, dp)
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	noerr(t, err)
+	return fd
+}
+
+const (
+	protoString  = descriptorpb.FieldDescriptorProto_TYPE_STRING
+	protoDouble  = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	protoInt64   = descriptorpb.FieldDescriptorProto_TYPE_INT64
+	protoInt32   = descriptorpb.FieldDescriptorProto_TYPE_INT32
+	protoUint32  = descriptorpb.FieldDescriptorProto_TYPE_UINT32
+	protoBool    = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	protoFixed64 = descriptorpb.FieldDescriptorProto_TYPE_FIXED64
+	protoMessage = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+)
+
+// remoteWriteProto describes the messages of a remote-write request, as in
+// prometheus/prompb.
+func remoteWriteProto(t *testing.T) protoreflect.FileDescriptor {
+	return protoFile(t, "prometheus", map[string][]protoField{
+		"WriteRequest": {{"timeseries", 1, protoMessage, "TimeSeries", true, ""}},
+		"TimeSeries": {
+			{"labels", 1, protoMessage, "Label", true, ""},
+			{"samples", 2, protoMessage, "Sample", true, ""},
+		},
+		"Label": {
+			{"name", 1, protoString, "", false, ""},
+			{"value", 2, protoString, "", false, ""},
+		},
+		"Sample": {
+			{"value", 1, protoDouble, "", false, ""},
+			{"timestamp", 2, protoInt64, "", false, ""},
+		},
+	})
+}
+
+// TestWriteRequestInterop verifies that the upstream protobuf library
+// decodes remote-write requests to the series they were made of, and
+// encodes the same series to the same bytes.
+func TestWriteRequestInterop(t *testing.T) {
+	ss := []series{
+		{[]label{{"__name__", "c_total"}, {"job", "j"}}, 3, 1500000000000},
+		{[]label{{"__name__", "g"}, {"groupname", "a b"}}, math.Inf(1), -1},
+		{[]label{{"__name__", "empty"}}, 0, 0},
+	}
+	fd := remoteWriteProto(t)
+	md := fd.Messages().ByName("WriteRequest")
+	req := dynamicpb.NewMessage(md)
+	noerr(t, proto.Unmarshal(encodeWriteRequest(ss), req))
+
+	var got []series
+	tss := req.Get(md.Fields().ByName("timeseries")).List()
+	for i := 0; i < tss.Len(); i++ {
+		ts := tss.Get(i).Message()
+		tsd := ts.Descriptor()
+		var s series
+		labels := ts.Get(tsd.Fields().ByName("labels")).List()
+		for j := 0; j < labels.Len(); j++ {
+			l := labels.Get(j).Message()
+			ld := l.Descriptor().Fields()
+			s.labels = append(s.labels, label{l.Get(ld.ByName("name")).String(), l.Get(ld.ByName("value")).String()})
+		}
+		samples := ts.Get(tsd.Fields().ByName("samples")).List()
+		if samples.Len() != 1 {
+			t.Fatalf("series %d: got %d samples, want 1", i, samples.Len())
+		}
+		sample := samples.Get(0).Message()
+		sd := sample.Descriptor().Fields()
+		s.value = sample.Get(sd.ByName("value")).Float()
+		s.timestamp = sample.Get(sd.ByName("timestamp")).Int()
+		got = append(got, s)
+	}
+	if diff := cmp.Diff(got, ss, cmp.AllowUnexported(series{}, label{})); diff != "" {
+		t.Errorf("series differ: (-got +want)\n%s", diff)
+	}
+
+	want, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	noerr(t, err)
+	if diff := cmp.Diff(encodeWriteRequest(ss), want); diff != "" {
+		t.Errorf("encoding differs from upstream: (-got +want)\n%s", diff)
+	}
+}
+
+// TestToSeriesLabels verifies that the job and instance labels of the
+// pusher win over those of metrics, which are kept renamed.
+func TestToSeriesLabels(t *testing.T) {
+	mfs := []*dto.MetricFamily{{
+		Name: proto.String("g"),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{
+				{Name: proto.String("exported_job"), Value: proto.String("old")},
+				{Name: proto.String("groupname"), Value: proto.String("a")},
+				{Name: proto.String("job"), Value: proto.String("mine")},
+			},
+			Gauge: &dto.Gauge{Value: proto.Float64(1)},
+		}},
+	}}
+	got := toSeries(mfs, []label{{"instance", "host"}, {"job", "process-exporter"}}, 0)
+	want := []series{{labels: []label{
+		{"__name__", "g"},
+		{"exported_job", "old"},
+		{"exported_job", "mine"},
+		{"groupname", "a"},
+		{"instance", "host"},
+		{"job", "process-exporter"},
+	}, value: 1}}
+	if diff := cmp.Diff(got, want, cmp.AllowUnexported(series{}, label{})); diff != "" {
+		t.Errorf("series differ: (-got +want)\n%s", diff)
+	}
+}
+
+// otlpProto describes the messages of an OTLP metrics export request that
+// the exporter uses, as in opentelemetry-proto, though all in one package.
+func otlpProto(t *testing.T) protoreflect.FileDescriptor {
+	return protoFile(t, "otlp", map[string][]protoField{
+		"ExportMetricsServiceRequest": {{"resource_metrics", 1, protoMessage, "ResourceMetrics", true, ""}},
+		"ResourceMetrics": {
+			{"resource", 1, protoMessage, "Resource", false, ""},
+			{"scope_metrics", 2, protoMessage, "ScopeMetrics", true, ""},
+			{"schema_url", 3, protoString, "", false, ""},
+		},
+		"Resource": {
+			{"attributes", 1, protoMessage, "KeyValue", true, ""},
+			{"dropped_attributes_count", 2, protoUint32, "", false, ""},
+		},
+		"KeyValue": {
+			{"key", 1, protoString, "", false, ""},
+			{"value", 2, protoMessage, "AnyValue", false, ""},
+		},
+		"AnyValue": {
+			{"string_value", 1, protoString, "", false, "value"},
+			{"bool_value", 2, protoBool, "", false, "value"},
+			{"int_value", 3, protoInt64, "", false, "value"},
+			{"double_value", 4, protoDouble, "", false, "value"},
+		},
+		"ScopeMetrics": {
+			{"scope", 1, protoMessage, "InstrumentationScope", false, ""},
+			{"metrics", 2, protoMessage, "Metric", true, ""},
+			{"schema_url", 3, protoString, "", false, ""},
+		},
+		"InstrumentationScope": {
+			{"name", 1, protoString, "", false, ""},
+			{"version", 2, protoString, "", false, ""},
+		},
+		"Metric": {
+			{"name", 1, protoString, "", false, ""},
+			{"description", 2, protoString, "", false, ""},
+			{"unit", 3, protoString, "", false, ""},
+			{"gauge", 5, protoMessage, "Gauge", false, "data"},
+			{"sum", 7, protoMessage, "Sum", false, "data"},
+		},
+		"Gauge": {{"data_points", 1, protoMessage, "NumberDataPoint", true, ""}},
+		"Sum": {
+			{"data_points", 1, protoMessage, "NumberDataPoint", true, ""},
+			// An enum upstream, which is encoded the same way.
+			{"aggregation_temporality", 2, protoInt32, "", false, ""},
+			{"is_monotonic", 3, protoBool, "", false, ""},
+		},
+		"NumberDataPoint": {
+			{"start_time_unix_nano", 2, protoFixed64, "", false, ""},
+			{"time_unix_nano", 3, protoFixed64, "", false, ""},
+			{"as_double", 4, protoDouble, "", false, "value"},
+			{"attributes", 7, protoMessage, "KeyValue", true, ""},
+			{"flags", 8, protoUint32, "", false, ""},
+		},
+	})
+}
+
+// field returns the value of the named field of m.
+func field(m protoreflect.Message, name string) protoreflect.Value {
+	return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+}
+
+// keyValues formats a list of KeyValue messages with string values as
+// sorted key=value items.
+func keyValues(l protoreflect.List) string {
+	var kvs []string
+	for i := 0; i < l.Len(); i++ {
+		kv := l.Get(i).Message()
+		kvs = append(kvs, field(kv, "key").String()+"="+field(field(kv, "value").Message(), "string_value").String())
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+// noUnknown fails if m, or a message it holds, has fields its descriptor
+// doesn't declare.
+func noUnknown(t *testing.T, m protoreflect.Message) {
+	if len(m.GetUnknown()) > 0 {
+		t.Errorf("%s has unknown fields", m.Descriptor().FullName())
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Kind() != protoreflect.MessageKind:
+		case fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				noUnknown(t, v.List().Get(i).Message())
+			}
+		default:
+			noUnknown(t, v.Message())
+		}
+		return true
+	})
+}
+
+// filteredGroups is a GroupSource with the optional methods of a
+// collector.
+type filteredGroups struct {
+	staticGroups
+	start    time.Time
+	families map[string]bool
+}
+
+func (f filteredGroups) StartTime() time.Time {
+	return f.start
+}
+
+func (f filteredGroups) MetricEnabled(family, gname string) bool {
+	return f.families[family]
+}
+
+// TestOTLPInterop verifies that the upstream protobuf library decodes export
+// requests to the metrics they were made of, without any field the OTLP
+// messages don't declare.
+func TestOTLPInterop(t *testing.T) {
+	src := filteredGroups{testGroups, time.Unix(1500000000, 5),
+		map[string]bool{"cpu_seconds_total": true, "memory_bytes": true}}
+	e, err := NewOTLPExporter(OTLPOption{
+		Endpoint:    "http://localhost:4318",
+		ServiceName: "process-exporter",
+		HostName:    "host",
+	}, src)
+	noerr(t, err)
+	encoded := e.encode(src.groups, src.attrs, 1500000060e9)
+
+	md := otlpProto(t).Messages().ByName("ExportMetricsServiceRequest")
+	req := dynamicpb.NewMessage(md)
+	noerr(t, proto.Unmarshal(encoded, req))
+
+	rms := field(req, "resource_metrics").List()
+	if rms.Len() != 1 {
+		t.Fatalf("got %d resource metrics, want 1", rms.Len())
+	}
+	rm := rms.Get(0).Message()
+	if got := keyValues(field(field(rm, "resource").Message(), "attributes").List()); got != "host.name=host,service.name=process-exporter" {
+		t.Errorf("got resource attributes %q", got)
+	}
+	sms := field(rm, "scope_metrics").List()
+	if sms.Len() != 1 {
+		t.Fatalf("got %d scope metrics, want 1", sms.Len())
+	}
+	sm := sms.Get(0).Message()
+	if got := field(field(sm, "scope").Message(), "name").String(); got != "github.com/ncabatoff/process-exporter" {
+		t.Errorf("got scope %q", got)
+	}
+
+	// Each data point as "metric type{attributes} value start time".
+	var got []string
+	metrics := field(sm, "metrics").List()
+	for i := 0; i < metrics.Len(); i++ {
+		m := metrics.Get(i).Message()
+		name := field(m, "name").String()
+		if field(m, "description").String() == "" || field(m, "unit").String() == "" {
+			t.Errorf("%s: no description or unit", name)
+		}
+		fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("data"))
+		if fd == nil {
+			t.Fatalf("%s: no data", name)
+		}
+		data := m.Get(fd).Message()
+		if fd.Name() == "sum" {
+			if temporality := field(data, "aggregation_temporality").Int(); temporality != 2 {
+				t.Errorf("%s: got temporality %d, want cumulative", name, temporality)
+			}
+			if !field(data, "is_monotonic").Bool() {
+				t.Errorf("%s: not monotonic", name)
+			}
+		}
+		points := field(data, "data_points").List()
+		for j := 0; j < points.Len(); j++ {
+			dp := points.Get(j).Message()
+			if dp.WhichOneof(dp.Descriptor().Oneofs().ByName("value")) == nil {
+				t.Errorf("%s: point %d has no value", name, j)
+			}
+			got = append(got, fmt.Sprintf("%s %s{%s} %g %d %d", name, fd.Name(),
+				keyValues(field(dp, "attributes").List()), field(dp, "as_double").Float(),
+				field(dp, "start_time_unix_nano").Uint(), field(dp, "time_unix_nano").Uint()))
+		}
+	}
+	attrs := "namedprocess.groupname=g1,process.executable.name=bash,process.owner=root"
+	sum := " 1500000000000000005 1500000060000000000"
+	gauge := " 0 1500000060000000000"
+	want := []string{
+		"process.cpu.time sum{cpu.mode=user," + attrs + "} 1.5" + sum,
+		"process.cpu.time sum{cpu.mode=system," + attrs + "} 0.5" + sum,
+		"namedprocess.group.children.cpu.time sum{cpu.mode=user," + attrs + "} 0" + sum,
+		"namedprocess.group.children.cpu.time sum{cpu.mode=system," + attrs + "} 0" + sum,
+		"process.memory.usage gauge{" + attrs + "} 4096" + gauge,
+		"process.memory.virtual gauge{" + attrs + "} 0" + gauge,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("points differ: (-got +want)\n%s", diff)
+	}
+	noUnknown(t, req)
+}
+
+// TestOTLPCollector verifies that the exporter takes the start time and the
+// metric selection of a collector.
+func TestOTLPCollector(t *testing.T) {
+	cfg, err := config.GetConfig(`
+metrics:
+  include:
+  - num_procs
+process_names:
+  - name: "{{.Comm}}"
+    cmdline:
+    - '.+'
+`, false)
+	noerr(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pc, err := collector.New(ctx, collector.ProcessCollectorOption{
+		ProcFSPath: "../fixtures",
+		Namer:      cfg.MatchNamers,
+		Metrics:    cfg,
+	})
+	noerr(t, err)
+
+	e, err := NewOTLPExporter(OTLPOption{
+		Endpoint: "http://localhost:4318",
+		HostName: "host",
+	}, pc)
+	noerr(t, err)
+	if start := pc.StartTime(); start.IsZero() || e.start != uint64(start.UnixNano()) {
+		t.Errorf("got start %d, want the collector's %v", e.start, start)
+	}
+
+	groups, attrs, err := pc.Groups()
+	noerr(t, err)
+	var names []string
+	for _, m := range otlpMetrics {
+		names = append(names, m.name)
+	}
+	got := decodeOTLP(t, e.encode(groups, attrs, uint64(time.Now().UnixNano())), names...)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "namedprocess.group.process.count gauge{namedprocess.groupname=process-exporte,") {
+		t.Errorf("got points %q, want only the process count", got)
+	}
+}
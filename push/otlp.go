@@ -0,0 +1,350 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+const otlpHTTPPath = "/v1/metrics"
+
+type (
+	// GroupSource provides the groups to export, e.g. a
+	// collector.NamedProcessCollector.  If it also has a method
+	// StartTime() time.Time, that's the start time of the cumulative sums;
+	// otherwise they're taken to start when the exporter is created.  If it
+	// has a method MetricEnabled(family, gname string) bool, only the data
+	// points of the metric families it enables for each group are
+	// exported.
+	GroupSource interface {
+		Groups() (proc.GroupByName, map[string]proc.GroupAttributes, error)
+	}
+
+	// startTimer is the optional GroupSource method giving the start time of
+	// the cumulative sums.
+	startTimer interface {
+		StartTime() time.Time
+	}
+
+	// metricEnabler is the optional GroupSource method selecting metric
+	// families, named as in collector.MetricFilter.
+	metricEnabler interface {
+		MetricEnabled(family, gname string) bool
+	}
+
+	// OTLPOption configures an OTLPExporter.
+	OTLPOption struct {
+		// Endpoint is the OTLP/HTTP URL of the OpenTelemetry collector, with
+		// scheme http for plaintext or https for TLS, e.g.
+		// http://localhost:4318.  /v1/metrics is appended if it has no path.
+		Endpoint string
+		// Headers are added to each request, e.g. for authentication.
+		Headers map[string]string
+		// Interval is the time between exports made by Run.
+		Interval time.Duration
+		// Timeout is the timeout of each request, 0 for none.
+		Timeout time.Duration
+		// Retries and RetryBackoff are as in PusherOption.
+		Retries      int
+		RetryBackoff time.Duration
+		// ServiceName and HostName are the service.name and host.name
+		// resource attributes.  HostName defaults to the hostname.
+		ServiceName string
+		HostName    string
+	}
+
+	// OTLPExporter exports the groups of a GroupSource as OpenTelemetry
+	// metrics over OTLP/HTTP: cumulative sums for counters, gauges for the
+	// rest.
+	OTLPExporter struct {
+		options OTLPOption
+		source  GroupSource
+		client  *http.Client
+		url     string
+		// resource is the encoded Resource message.
+		resource []byte
+		// start is the start time of the cumulative sums, in nanoseconds
+		// since the epoch.  It's the same for all groups, since the counts of
+		// a group that appeared later were zero until then.
+		start uint64
+	}
+
+	// otlpMetric describes how to export a group metric.
+	otlpMetric struct {
+		name, description, unit string
+		// sum is true for cumulative monotonic sums, false for gauges.
+		sum    bool
+		points func(g proc.Group) []otlpPoint
+	}
+
+	// otlpPoint is a data point of a group, with an optional attribute
+	// telling it apart from the other points of the group.  family is the
+	// collector metric family it's exported with, for MetricEnabled.
+	otlpPoint struct {
+		family string
+		attr   label
+		value  float64
+	}
+)
+
+// point returns a data point of family without attribute.
+func point(family string, v float64) []otlpPoint {
+	return []otlpPoint{{family: family, value: v}}
+}
+
+// otlpMetrics are the exported metrics, named after the OpenTelemetry
+// semantic conventions for process metrics where there's one.
+var otlpMetrics = []otlpMetric{
+	{"process.cpu.time", "Total CPU seconds broken down by different CPU modes.", "s", true,
+		func(g proc.Group) []otlpPoint {
+			return []otlpPoint{
+				{"cpu_seconds_total", label{"cpu.mode", "user"}, g.CPUUserTime},
+				{"cpu_seconds_total", label{"cpu.mode", "system"}, g.CPUSystemTime},
+			}
+		}},
+	{"namedprocess.group.children.cpu.time", "CPU seconds of waited-for children, broken down by CPU mode.", "s", true,
+		func(g proc.Group) []otlpPoint {
+			return []otlpPoint{
+				{"cpu_seconds_total", label{"cpu.mode", "user"}, g.CPUChildrenUserTime},
+				{"cpu_seconds_total", label{"cpu.mode", "system"}, g.CPUChildrenSystemTime},
+			}
+		}},
+	{"namedprocess.group.guest.cpu.time", "CPU seconds spent running a virtual CPU for a guest.", "s", true,
+		func(g proc.Group) []otlpPoint { return point("guest_cpu_seconds_total", g.CPUGuestTime) }},
+	{"process.disk.io", "Disk bytes transferred.", "By", true,
+		func(g proc.Group) []otlpPoint {
+			return []otlpPoint{
+				{"read_bytes_total", label{"disk.io.direction", "read"}, float64(g.ReadBytes)},
+				{"write_bytes_total", label{"disk.io.direction", "write"}, float64(g.WriteBytes)},
+			}
+		}},
+	{"namedprocess.group.io.chars", "Bytes read and written, including from caches and pipes.", "By", true,
+		func(g proc.Group) []otlpPoint {
+			return []otlpPoint{
+				{"read_chars_total", label{"io.direction", "read"}, float64(g.ReadChars)},
+				{"write_chars_total", label{"io.direction", "write"}, float64(g.WriteChars)},
+			}
+		}},
+	{"namedprocess.group.io.syscalls", "Number of read and write system calls.", "{syscall}", true,
+		func(g proc.Group) []otlpPoint {
+			return []otlpPoint{
+				{"read_syscalls_total", label{"io.direction", "read"}, float64(g.ReadSyscalls)},
+				{"write_syscalls_total", label{"io.direction", "write"}, float64(g.WriteSyscalls)},
+			}
+		}},
+	{"namedprocess.group.io.cancelled_write", "Bytes written to the page cache then truncated before reaching disk.", "By", true,
+		func(g proc.Group) []otlpPoint {
+			return point("cancelled_write_bytes_total", float64(g.CancelledWriteBytes))
+		}},
+	{"namedprocess.group.blkio.delay", "Seconds spent waiting for block I/O.", "s", true,
+		func(g proc.Group) []otlpPoint { return point("blkio_delay_seconds_total", g.BlockIODelay) }},
+	{"process.paging.faults", "Number of page faults the process has made.", "{fault}", true,
+		func(g proc.Group) []otlpPoint {
+			return []otlpPoint{
+				{"major_page_faults_total", label{"process.paging.fault_type", "major"}, float64(g.MajorPageFaults)},
+				{"minor_page_faults_total", label{"process.paging.fault_type", "minor"}, float64(g.MinorPageFaults)},
+			}
+		}},
+	{"process.context_switches", "Number of times the process has been context switched.", "{count}", true,
+		func(g proc.Group) []otlpPoint {
+			return []otlpPoint{
+				{"context_switches_total", label{"process.context_switch_type", "voluntary"}, float64(g.CtxSwitchVoluntary)},
+				{"context_switches_total", label{"process.context_switch_type", "involuntary"}, float64(g.CtxSwitchNonvoluntary)},
+			}
+		}},
+	{"process.memory.usage", "The amount of physical memory in use.", "By", false,
+		func(g proc.Group) []otlpPoint { return point("memory_bytes", float64(g.ResidentBytes)) }},
+	{"process.memory.virtual", "The amount of committed virtual memory.", "By", false,
+		func(g proc.Group) []otlpPoint { return point("memory_bytes", float64(g.VirtualBytes)) }},
+	{"process.open_file_descriptor.count", "Number of file descriptors in use by the process.", "{count}", false,
+		func(g proc.Group) []otlpPoint { return point("open_filedesc", float64(g.OpenFDs)) }},
+	{"process.thread.count", "Process threads count.", "{thread}", false,
+		func(g proc.Group) []otlpPoint { return point("num_threads", float64(g.NumThreads)) }},
+	{"namedprocess.group.process.count", "Number of processes in the group.", "{process}", false,
+		func(g proc.Group) []otlpPoint { return point("num_procs", float64(g.Procs)) }},
+}
+
+// NewOTLPExporter returns an OTLPExporter exporting the groups of source.
+func NewOTLPExporter(options OTLPOption, source GroupSource) (*OTLPExporter, error) {
+	u, err := url.Parse(options.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("bad OTLP endpoint: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("bad OTLP endpoint %q: scheme must be http or https", options.Endpoint)
+	}
+	if options.HostName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error getting hostname for the host.name attribute: %v", err)
+		}
+		options.HostName = hostname
+	}
+
+	start := time.Now()
+	if st, ok := source.(startTimer); ok {
+		start = st.StartTime()
+	}
+	e := &OTLPExporter{
+		options: options,
+		source:  source,
+		start:   uint64(start.UnixNano()),
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpHTTPPath
+	}
+	e.client = &http.Client{Timeout: options.Timeout}
+	e.url = u.String()
+
+	var resource []byte
+	for _, l := range []label{{"service.name", options.ServiceName}, {"host.name", options.HostName}} {
+		if l.value != "" {
+			resource = appendBytes(resource, 1, encodeKeyValue(l))
+		}
+	}
+	e.resource = resource
+	return e, nil
+}
+
+// Run exports every Interval until ctx is done, logging failures.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+	for {
+		if err := e.Export(ctx); err != nil {
+			log.Printf("error exporting metrics to %s: %v", e.url, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Export reads the groups and exports them once, retrying failed requests
+// until ctx is done.
+func (e *OTLPExporter) Export(ctx context.Context) error {
+	groups, attrs, err := e.source.Groups()
+	if err != nil {
+		return err
+	}
+	req := e.encode(groups, attrs, uint64(time.Now().UnixNano()))
+	return retry(ctx, e.options.Retries, e.options.RetryBackoff, func() error { return e.send(ctx, req) })
+}
+
+// encode returns an ExportMetricsServiceRequest message holding the metrics
+// of groups as of now, in nanoseconds since the epoch.
+func (e *OTLPExporter) encode(groups proc.GroupByName, attrs map[string]proc.GroupAttributes, now uint64) []byte {
+	gnames := make([]string, 0, len(groups))
+	for gname := range groups {
+		gnames = append(gnames, gname)
+	}
+	sort.Strings(gnames)
+	enabled := func(family, gname string) bool { return true }
+	if me, ok := e.source.(metricEnabler); ok {
+		enabled = me.MetricEnabled
+	}
+
+	// The attributes identifying each group, encoded as KeyValue fields of
+	// a NumberDataPoint.
+	groupAttrs := make(map[string][]byte, len(gnames))
+	for _, gname := range gnames {
+		a := attrs[gname]
+		var b []byte
+		for _, l := range []label{
+			{"namedprocess.groupname", gname},
+			{"process.executable.name", a.ExecutableName},
+			{"process.owner", a.Owner},
+		} {
+			if l.value != "" {
+				b = appendBytes(b, 7, encodeKeyValue(l))
+			}
+		}
+		groupAttrs[gname] = b
+	}
+
+	scope := appendString(nil, 1, "github.com/ncabatoff/process-exporter")
+	scopeMetrics := appendBytes(nil, 1, scope)
+	var metric, data, dp []byte
+	for _, m := range otlpMetrics {
+		data = data[:0]
+		for _, gname := range gnames {
+			for _, pt := range m.points(groups[gname]) {
+				if !enabled(pt.family, gname) {
+					continue
+				}
+				dp = dp[:0]
+				if m.sum {
+					dp = appendFixed64(dp, 2, e.start)
+				}
+				dp = appendFixed64(dp, 3, now)
+				dp = appendFixed64(dp, 4, math.Float64bits(pt.value))
+				dp = append(dp, groupAttrs[gname]...)
+				if pt.attr.name != "" {
+					dp = appendBytes(dp, 7, encodeKeyValue(pt.attr))
+				}
+				data = appendBytes(data, 1, dp)
+			}
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		metric = appendString(metric[:0], 1, m.name)
+		metric = appendString(metric, 2, m.description)
+		metric = appendString(metric, 3, m.unit)
+		if m.sum {
+			data = appendVarint(data, 2, 2) // AGGREGATION_TEMPORALITY_CUMULATIVE
+			data = appendVarint(data, 3, 1) // is_monotonic
+			metric = appendBytes(metric, 7, data)
+		} else {
+			metric = appendBytes(metric, 5, data)
+		}
+		scopeMetrics = appendBytes(scopeMetrics, 2, metric)
+	}
+
+	rm := appendBytes(nil, 1, e.resource)
+	rm = appendBytes(rm, 2, scopeMetrics)
+	return appendBytes(nil, 1, rm)
+}
+
+// encodeKeyValue returns a KeyValue message with a string value.
+func encodeKeyValue(l label) []byte {
+	kv := appendString(nil, 1, l.name)
+	return appendBytes(kv, 2, appendString(nil, 1, l.value))
+}
+
+// send sends an export request, cancelled once ctx is done.
+func (e *OTLPExporter) send(ctx context.Context, msg []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	for k, v := range e.options.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "process-exporter")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return statusError{resp.StatusCode, strings.TrimSpace(string(body))}
+	}
+	return nil
+}
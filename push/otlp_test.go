@@ -0,0 +1,230 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ncabatoff/process-exporter/proc"
+)
+
+// staticGroups is a GroupSource returning fixed groups.
+type staticGroups struct {
+	groups proc.GroupByName
+	attrs  map[string]proc.GroupAttributes
+}
+
+func (s staticGroups) Groups() (proc.GroupByName, map[string]proc.GroupAttributes, error) {
+	return s.groups, s.attrs, nil
+}
+
+var testGroups = staticGroups{
+	groups: proc.GroupByName{
+		"g1": proc.Group{
+			Counts:     proc.Counts{CPUUserTime: 1.5, CPUSystemTime: 0.5, ReadBytes: 100},
+			Memory:     proc.Memory{ResidentBytes: 4096},
+			Procs:      2,
+			OpenFDs:    7,
+			NumThreads: 3,
+		},
+	},
+	attrs: map[string]proc.GroupAttributes{
+		"g1": {ExecutableName: "bash", Owner: "root"},
+	},
+}
+
+// decodeOTLP returns the data points of an ExportMetricsServiceRequest,
+// formatted as "metric type{attributes} value", for the metrics named in
+// names.
+func decodeOTLP(t *testing.T, req []byte, names ...string) []string {
+	var got []string
+	protoFields(t, req, func(_ int, rm []byte, _ uint64) {
+		protoFields(t, rm, func(field int, sm []byte, _ uint64) {
+			if field != 2 {
+				return
+			}
+			protoFields(t, sm, func(field int, m []byte, _ uint64) {
+				if field != 2 {
+					return
+				}
+				var name, typ string
+				var points [][]byte
+				protoFields(t, m, func(field int, data []byte, _ uint64) {
+					switch field {
+					case 1:
+						name = string(data)
+					case 5, 7:
+						typ = map[int]string{5: "gauge", 7: "sum"}[field]
+						protoFields(t, data, func(field int, dp []byte, _ uint64) {
+							if field == 1 {
+								points = append(points, dp)
+							}
+						})
+					}
+				})
+				for _, n := range names {
+					if n != name {
+						continue
+					}
+					for _, dp := range points {
+						got = append(got, name+" "+typ+decodePoint(t, dp))
+					}
+				}
+			})
+		})
+	})
+	return got
+}
+
+// decodePoint formats a NumberDataPoint as {attributes} value.
+func decodePoint(t *testing.T, dp []byte) string {
+	var (
+		attrs []string
+		value float64
+	)
+	protoFields(t, dp, func(field int, data []byte, v uint64) {
+		switch field {
+		case 4:
+			value = math.Float64frombits(v)
+		case 7:
+			var k, val string
+			protoFields(t, data, func(field int, data []byte, _ uint64) {
+				if field == 1 {
+					k = string(data)
+				} else {
+					protoFields(t, data, func(_ int, s []byte, _ uint64) { val = string(s) })
+				}
+			})
+			attrs = append(attrs, k+"="+val)
+		}
+	})
+	sort.Strings(attrs)
+	return fmt.Sprintf("{%s} %g", strings.Join(attrs, ","), value)
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies [][]byte
+		paths  []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, err := ioutil.ReadAll(r.Body)
+		noerr(t, err)
+		bodies = append(bodies, body)
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("got Content-Type %q, want application/x-protobuf", r.Header.Get("Content-Type"))
+		}
+	}))
+	defer ts.Close()
+
+	e, err := NewOTLPExporter(OTLPOption{
+		Endpoint:    ts.URL,
+		ServiceName: "process-exporter",
+		HostName:    "host",
+	}, testGroups)
+	noerr(t, err)
+	noerr(t, e.Export(context.Background()))
+
+	if diff := cmp.Diff(paths, []string{"/v1/metrics"}); diff != "" {
+		t.Errorf("paths differ: (-got +want)\n%s", diff)
+	}
+	got := decodeOTLP(t, bodies[0], "process.cpu.time", "process.memory.usage", "namedprocess.group.process.count")
+	attrs := "namedprocess.groupname=g1,process.executable.name=bash,process.owner=root"
+	want := []string{
+		"process.cpu.time sum{cpu.mode=user," + attrs + "} 1.5",
+		"process.cpu.time sum{cpu.mode=system," + attrs + "} 0.5",
+		"process.memory.usage gauge{" + attrs + "} 4096",
+		"namedprocess.group.process.count gauge{" + attrs + "} 2",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("points differ: (-got +want)\n%s", diff)
+	}
+}
+
+// TestOTLPRetries verifies that failed exports are retried unless refused
+// with a 4xx status, and that Run stops retrying once its context is done.
+func TestOTLPRetries(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		statuses []int
+		calls    int
+	)
+	called := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		called <- struct{}{}
+		if len(statuses) > 0 {
+			http.Error(w, "not now", statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	defer ts.Close()
+
+	e, err := NewOTLPExporter(OTLPOption{
+		Endpoint:     ts.URL,
+		HostName:     "host",
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+		Interval:     time.Hour,
+	}, testGroups)
+	noerr(t, err)
+
+	for _, tc := range []struct {
+		statuses []int
+		ok       bool
+		calls    int
+	}{
+		{[]int{http.StatusServiceUnavailable}, true, 2},
+		{[]int{http.StatusBadRequest}, false, 1},
+	} {
+		mu.Lock()
+		statuses, calls = tc.statuses, 0
+		mu.Unlock()
+		err := e.Export(context.Background())
+		if (err == nil) != tc.ok {
+			t.Errorf("statuses %v: got error %v", tc.statuses, err)
+		}
+		mu.Lock()
+		if calls != tc.calls {
+			t.Errorf("statuses %v: got %d calls, want %d", tc.statuses, calls, tc.calls)
+		}
+		mu.Unlock()
+	}
+	for len(called) > 0 {
+		<-called
+	}
+
+	// With a long backoff, Run is waiting to retry when cancelled.
+	e.options.RetryBackoff = time.Hour
+	mu.Lock()
+	statuses = []int{http.StatusServiceUnavailable}
+	mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	<-called
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run didn't return once cancelled")
+	}
+}
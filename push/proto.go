@@ -0,0 +1,31 @@
+package push
+
+import "google.golang.org/protobuf/encoding/protowire"
+
+// The protobuf messages of remote-write and OTLP are built field by field
+// with these helpers over protowire, the wire format package of the
+// protobuf library, rather than with the generated code of prompb or the
+// OTLP protos, which would bring in gRPC.
+
+// appendVarint appends a varint field.
+func appendVarint(b []byte, field int, v uint64) []byte {
+	b = protowire.AppendTag(b, protowire.Number(field), protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendFixed64 appends a 64-bit field, e.g. a double.
+func appendFixed64(b []byte, field int, v uint64) []byte {
+	b = protowire.AppendTag(b, protowire.Number(field), protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+// appendBytes appends a length-delimited field.
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = protowire.AppendTag(b, protowire.Number(field), protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	b = protowire.AppendTag(b, protowire.Number(field), protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
@@ -0,0 +1,300 @@
+// Package push periodically sends the metrics of a prometheus.Gatherer to a
+// Prometheus Pushgateway or remote-write endpoint, for hosts that can't be
+// scraped, e.g. because they're behind NAT.  It also exports group metrics
+// over OTLP, and provides collector sinks for StatsD and Graphite.
+package push
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	pushgateway "github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	// ModePushgateway pushes to a Pushgateway, replacing the metrics of the
+	// job and instance on each push.
+	ModePushgateway = "pushgateway"
+	// ModeRemoteWrite sends samples with the Prometheus remote-write
+	// protocol, e.g. to Prometheus with --web.enable-remote-write-receiver.
+	ModeRemoteWrite = "remote-write"
+)
+
+// Modes are the supported values of PusherOption.Mode.
+var Modes = []string{ModePushgateway, ModeRemoteWrite}
+
+type (
+	// PusherOption configures a Pusher.
+	PusherOption struct {
+		// URL is the Pushgateway base URL, without the /metrics/job part, or
+		// the remote-write endpoint.
+		URL string
+		// Mode is one of Modes.
+		Mode string
+		// Job and Instance are the values of the job and instance labels
+		// added to all metrics.  Instance defaults to the hostname.
+		Job      string
+		Instance string
+		// Interval is the time between pushes made by Run.
+		Interval time.Duration
+		// Timeout is the timeout of each request, 0 for none.
+		Timeout time.Duration
+		// Retries is the number of times a failed request is retried, waiting
+		// RetryBackoff before the first retry and twice as long before each
+		// following one.  Requests refused with a 4xx status other than 429
+		// aren't retried.
+		Retries      int
+		RetryBackoff time.Duration
+		// BatchSize is the maximum number of series sent per remote-write
+		// request, 0 for no limit.
+		BatchSize int
+		// BufferDir, if not empty, is the directory where remote-write
+		// requests that couldn't be sent are kept, to be sent in order on
+		// later pushes, including after a restart.  Otherwise they're dropped.
+		//
+		// BatchSize and BufferDir must be unset in ModePushgateway: each push
+		// replaces all the metrics of the job and instance in one request,
+		// so there is nothing to split, nor worth sending late.
+		BufferDir string
+		// BufferMaxBytes is the size beyond which the oldest buffered
+		// requests are dropped, 0 for no limit.
+		BufferMaxBytes int64
+	}
+
+	// Pusher pushes the metrics of a Gatherer as configured by a
+	// PusherOption.
+	Pusher struct {
+		options  PusherOption
+		gatherer prometheus.Gatherer
+		client   *http.Client
+		// pgw is set in ModePushgateway.
+		pgw *pushgateway.Pusher
+		// labels are added to each remote-write series.
+		labels []label
+		buffer *diskBuffer
+	}
+
+	// retryableError is implemented by errors that tell whether the failed
+	// request may succeed if sent again.
+	retryableError interface {
+		retryable() bool
+	}
+
+	// statusError is returned for an HTTP request refused by the server.
+	statusError struct {
+		code int
+		body string
+	}
+)
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %d: %s", e.code, e.body)
+}
+
+// retryable returns true if the request may succeed if sent again.
+func (e statusError) retryable() bool {
+	return e.code/100 == 5 || e.code == http.StatusTooManyRequests
+}
+
+// NewPusher returns a Pusher pushing the metrics of g.
+func NewPusher(options PusherOption, g prometheus.Gatherer) (*Pusher, error) {
+	if options.URL == "" {
+		return nil, fmt.Errorf("no URL to push to")
+	}
+	if options.Job == "" {
+		return nil, fmt.Errorf("no job name")
+	}
+	if options.Instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error getting hostname for the instance label: %v", err)
+		}
+		options.Instance = hostname
+	}
+
+	p := &Pusher{
+		options:  options,
+		gatherer: g,
+		client:   &http.Client{Timeout: options.Timeout},
+	}
+	switch options.Mode {
+	case ModePushgateway:
+		if options.BatchSize != 0 || options.BufferDir != "" {
+			return nil, fmt.Errorf("batching and buffering are only supported in %s mode", ModeRemoteWrite)
+		}
+		p.pgw = pushgateway.New(options.URL, options.Job).
+			Gatherer(g).
+			Grouping("instance", options.Instance).
+			Client(p.client)
+	case ModeRemoteWrite:
+		p.labels = []label{{"instance", options.Instance}, {"job", options.Job}}
+		if options.BufferDir != "" {
+			buffer, err := newDiskBuffer(options.BufferDir, options.BufferMaxBytes)
+			if err != nil {
+				return nil, fmt.Errorf("error opening push buffer: %v", err)
+			}
+			p.buffer = buffer
+		}
+	default:
+		return nil, fmt.Errorf("unknown push mode %q, want one of %s",
+			options.Mode, strings.Join(Modes, ", "))
+	}
+	return p, nil
+}
+
+// Run pushes every Interval until ctx is done, logging failures.
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.options.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Push(ctx); err != nil {
+			log.Printf("error pushing metrics to %s: %v", p.options.URL, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Push gathers the metrics and pushes them once, retrying failed requests
+// until ctx is done.  In ModeRemoteWrite, requests buffered by earlier
+// pushes are sent first, and requests that still can't be sent are
+// buffered.
+func (p *Pusher) Push(ctx context.Context) error {
+	if p.pgw != nil {
+		return p.retry(ctx, p.pgw.Push)
+	}
+
+	mfs, err := p.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("error gathering metrics: %v", err)
+	}
+	ss := toSeries(mfs, p.labels, time.Now().UnixNano()/int64(time.Millisecond))
+	var requests [][]byte
+	for len(ss) > 0 {
+		n := len(ss)
+		if p.options.BatchSize > 0 && n > p.options.BatchSize {
+			n = p.options.BatchSize
+		}
+		requests = append(requests, snappy.Encode(nil, encodeWriteRequest(ss[:n])))
+		ss = ss[n:]
+	}
+
+	// Samples of a series must be sent in order, so while older requests
+	// remain buffered new ones are buffered behind them.
+	var firstErr error
+	if p.buffer != nil {
+		firstErr = p.flush(ctx)
+	}
+	for i, req := range requests {
+		if firstErr == nil {
+			err := p.retry(ctx, func() error { return p.send(ctx, req) })
+			if re, ok := err.(retryableError); ok && !re.retryable() {
+				log.Printf("dropping remote-write request refused by %s: %v", p.options.URL, err)
+				continue
+			}
+			if err == nil {
+				continue
+			}
+			firstErr = err
+		}
+		if p.buffer == nil {
+			log.Printf("dropping %d remote-write requests that couldn't be sent", len(requests)-i)
+			break
+		}
+		dropped, err := p.buffer.add(req)
+		if err != nil {
+			return fmt.Errorf("error buffering remote-write request: %v (after %v)", err, firstErr)
+		}
+		if dropped > 0 {
+			log.Printf("push buffer full, dropped the %d oldest requests", dropped)
+		}
+	}
+	return firstErr
+}
+
+// flush sends the buffered requests, oldest first, stopping at the first
+// that can't be sent.
+func (p *Pusher) flush(ctx context.Context) error {
+	names, err := p.buffer.list()
+	if err != nil {
+		return fmt.Errorf("error reading push buffer: %v", err)
+	}
+	for _, name := range names {
+		req, err := p.buffer.read(name)
+		if err != nil {
+			return fmt.Errorf("error reading push buffer: %v", err)
+		}
+		err = p.retry(ctx, func() error { return p.send(ctx, req) })
+		if re, ok := err.(retryableError); ok && !re.retryable() {
+			log.Printf("dropping buffered remote-write request refused by %s: %v", p.options.URL, err)
+		} else if err != nil {
+			return err
+		}
+		if err := p.buffer.remove(name); err != nil {
+			return fmt.Errorf("error removing sent request from push buffer: %v", err)
+		}
+	}
+	return nil
+}
+
+// retry calls f until it succeeds, fails with an error that isn't worth
+// retrying, has been retried Retries times, or ctx is done.
+func (p *Pusher) retry(ctx context.Context, f func() error) error {
+	return retry(ctx, p.options.Retries, p.options.RetryBackoff, f)
+}
+
+// retry calls f until it succeeds, fails with an error that isn't worth
+// retrying, or has been retried retries times, waiting backoff before the
+// first retry and twice as long before each following one.  It stops
+// waiting once ctx is done, returning the last error of f.
+func retry(ctx context.Context, retries int, backoff time.Duration, f func() error) error {
+	for i := 0; ; i++ {
+		err := f()
+		if re, ok := err.(retryableError); err == nil || i >= retries || ok && !re.retryable() {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// send sends a compressed remote-write request, cancelled once ctx is done.
+func (p *Pusher) send(ctx context.Context, req []byte) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.options.URL, bytes.NewReader(req))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "process-exporter")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return statusError{resp.StatusCode, strings.TrimSpace(string(body))}
+	}
+	return nil
+}
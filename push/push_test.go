@@ -0,0 +1,295 @@
+package push
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// protoFields splits a protobuf message into its fields, calling f with the
+// field number and either the raw value of a length-delimited field or the
+// value of a varint or fixed64 field.
+func protoFields(t *testing.T, b []byte, f func(field int, data []byte, v uint64)) {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			b = b[n:]
+			f(int(key>>3), nil, v)
+		case 1:
+			f(int(key>>3), nil, binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			b = b[n:]
+			f(int(key>>3), b[:l], 0)
+			b = b[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+}
+
+// decodeWriteRequest returns the series of a compressed remote-write
+// request, formatted as name{labels} value, without timestamps.
+func decodeWriteRequest(t *testing.T, req []byte) []string {
+	data, err := snappy.Decode(nil, req)
+	noerr(t, err)
+
+	var got []string
+	protoFields(t, data, func(_ int, ts []byte, _ uint64) {
+		var (
+			name   string
+			labels []string
+			value  float64
+		)
+		protoFields(t, ts, func(field int, msg []byte, _ uint64) {
+			if field == 1 {
+				var l label
+				protoFields(t, msg, func(field int, s []byte, _ uint64) {
+					if field == 1 {
+						l.name = string(s)
+					} else {
+						l.value = string(s)
+					}
+				})
+				if l.name == "__name__" {
+					name = l.value
+				} else {
+					labels = append(labels, l.name+"="+l.value)
+				}
+				return
+			}
+			protoFields(t, msg, func(field int, _ []byte, v uint64) {
+				if field == 1 {
+					value = math.Float64frombits(v)
+				}
+			})
+		})
+		got = append(got, fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), value))
+	})
+	return got
+}
+
+func noerr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+}
+
+// standIn is a stand-in remote-write server that fails the first failures
+// requests with status failCode.
+type standIn struct {
+	sync.Mutex
+	t        *testing.T
+	failures int
+	failCode int
+	requests int
+	series   []string
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests++
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, "unavailable", s.failCode)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "snappy" {
+		s.t.Errorf("got Content-Encoding %q, want snappy", r.Header.Get("Content-Encoding"))
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	noerr(s.t, err)
+	s.series = append(s.series, decodeWriteRequest(s.t, body)...)
+}
+
+func testRegistry() (*prometheus.Registry, prometheus.Counter) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "c_total", Help: "c"})
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "g", Help: "g"}, []string{"groupname"})
+	reg.MustRegister(c, g)
+	c.Add(3)
+	g.WithLabelValues("a").Set(1)
+	g.WithLabelValues("b").Set(2)
+	return reg, c
+}
+
+func TestRemoteWrite(t *testing.T) {
+	reg, _ := testRegistry()
+	srv := &standIn{t: t, failures: 1, failCode: http.StatusServiceUnavailable}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	p, err := NewPusher(PusherOption{
+		URL:       ts.URL,
+		Mode:      ModeRemoteWrite,
+		Job:       "process-exporter",
+		Instance:  "host",
+		Retries:   1,
+		BatchSize: 2,
+	}, reg)
+	noerr(t, err)
+	noerr(t, p.Push(context.Background()))
+
+	// One failed request, retried, then a second batch for the third series.
+	if srv.requests != 3 {
+		t.Errorf("got %d requests, want 3", srv.requests)
+	}
+	want := []string{
+		"c_total{instance=host,job=process-exporter} 3",
+		"g{groupname=a,instance=host,job=process-exporter} 1",
+		"g{groupname=b,instance=host,job=process-exporter} 2",
+	}
+	if diff := cmp.Diff(srv.series, want); diff != "" {
+		t.Errorf("series differ: (-got +want)\n%s", diff)
+	}
+}
+
+func TestRemoteWriteBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push-buffer")
+	noerr(t, err)
+	defer os.RemoveAll(dir)
+
+	reg, c := testRegistry()
+	srv := &standIn{t: t, failures: 2, failCode: http.StatusInternalServerError}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	p, err := NewPusher(PusherOption{
+		URL:       ts.URL,
+		Mode:      ModeRemoteWrite,
+		Job:       "j",
+		Instance:  "i",
+		BufferDir: dir,
+	}, reg)
+	noerr(t, err)
+
+	// Both the first push and the first flush of the buffer fail, so both
+	// requests are buffered.
+	if err := p.Push(context.Background()); err == nil {
+		t.Fatalf("push to failing server succeeded")
+	}
+	c.Add(1)
+	if err := p.Push(context.Background()); err == nil {
+		t.Fatalf("push to failing server succeeded")
+	}
+	names, err := p.buffer.list()
+	noerr(t, err)
+	if len(names) != 2 {
+		t.Fatalf("got %d buffered requests, want 2", len(names))
+	}
+
+	// The buffered requests are sent in order before the new one.
+	c.Add(1)
+	srv.series = nil
+	noerr(t, p.Push(context.Background()))
+	var got []string
+	for _, s := range srv.series {
+		if strings.HasPrefix(s, "c_total") {
+			got = append(got, s)
+		}
+	}
+	want := []string{
+		"c_total{instance=i,job=j} 3",
+		"c_total{instance=i,job=j} 4",
+		"c_total{instance=i,job=j} 5",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("series differ: (-got +want)\n%s", diff)
+	}
+	names, err = p.buffer.list()
+	noerr(t, err)
+	if len(names) != 0 {
+		t.Errorf("got %d buffered requests after flush, want 0", len(names))
+	}
+}
+
+func TestPushgateway(t *testing.T) {
+	reg, _ := testRegistry()
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if len(paths) == 1 {
+			http.Error(w, "unavailable", http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	p, err := NewPusher(PusherOption{
+		URL:      ts.URL,
+		Mode:     ModePushgateway,
+		Job:      "process-exporter",
+		Instance: "host",
+		Retries:  2,
+	}, reg)
+	noerr(t, err)
+	noerr(t, p.Push(context.Background()))
+
+	want := []string{
+		"PUT /metrics/job/process-exporter/instance/host",
+		"PUT /metrics/job/process-exporter/instance/host",
+	}
+	if diff := cmp.Diff(paths, want); diff != "" {
+		t.Errorf("requests differ: (-got +want)\n%s", diff)
+	}
+}
+
+// TestPushgatewayOptions verifies that options only remote-write supports
+// are refused with the Pushgateway.
+func TestPushgatewayOptions(t *testing.T) {
+	for _, options := range []PusherOption{
+		{URL: "http://localhost:9091", Mode: ModePushgateway, Job: "j", BatchSize: 10},
+		{URL: "http://localhost:9091", Mode: ModePushgateway, Job: "j", BufferDir: "/tmp"},
+	} {
+		if _, err := NewPusher(options, prometheus.NewRegistry()); err == nil {
+			t.Errorf("got no error for %+v", options)
+		}
+	}
+}
+
+// TestRetryCancel verifies that retries stop waiting once the context is
+// done, returning the last error.
+func TestRetryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	fail := statusError{http.StatusServiceUnavailable, "unavailable"}
+	done := make(chan error)
+	go func() {
+		done <- retry(ctx, 5, time.Hour, func() error {
+			calls++
+			return fail
+		})
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != fail || calls != 1 {
+			t.Errorf("got error %v after %d calls, want %v after 1", err, calls, fail)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("retry didn't stop when cancelled")
+	}
+}
@@ -0,0 +1,145 @@
+package push
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type (
+	// label is a label of a time series.
+	label struct {
+		name, value string
+	}
+
+	// series is a time series with a single sample, as sent by remote-write.
+	series struct {
+		// labels include __name__ and are sorted by name.
+		labels    []label
+		value     float64
+		timestamp int64
+	}
+)
+
+// toSeries converts metric families to series, each labelled with extra in
+// addition to its own labels.  As when Prometheus scrapes a target, extra
+// labels win over labels of the same name, which are kept prefixed with
+// "exported_".  Samples without a timestamp get nowMs, in milliseconds since
+// the epoch.  Summaries and histograms are split in their _sum, _count and
+// quantile or _bucket series, as Prometheus stores them.
+func toSeries(mfs []*dto.MetricFamily, extra []label, nowMs int64) []series {
+	var ss []series
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			ts := nowMs
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			labels := append([]label{}, extra...)
+			for _, lp := range m.GetLabel() {
+				// Prometheus treats empty labels as absent.
+				if lp.GetValue() != "" {
+					labels = addLabel(labels, len(extra), label{lp.GetName(), lp.GetValue()})
+				}
+			}
+			add := func(name string, value float64, more ...label) {
+				ls := make([]label, 0, len(labels)+len(more)+1)
+				ls = append(ls, label{"__name__", name})
+				ls = append(ls, labels...)
+				ls = append(ls, more...)
+				sort.Slice(ls, func(i, j int) bool { return ls[i].name < ls[j].name })
+				ss = append(ss, series{labels: ls, value: value, timestamp: ts})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add(name+"_bucket", float64(b.GetCumulativeCount()),
+						label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					add(name+"_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			default:
+				add(name, m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return ss
+}
+
+// addLabel appends l to labels, whose first nextra are extra labels,
+// prefixing its name with "exported_" as often as needed to tell it apart
+// from them.
+func addLabel(labels []label, nextra int, l label) []label {
+	for i := 0; i < nextra; i++ {
+		if labels[i].name == l.name {
+			l.name = "exported_" + l.name
+			i = -1
+		}
+	}
+	return append(labels, l)
+}
+
+// formatFloat formats f as Prometheus does in le and quantile labels.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest returns ss encoded as a remote-write WriteRequest
+// protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(ss []series) []byte {
+	var buf, ts, msg []byte
+	for _, s := range ss {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = appendString(msg[:0], 1, l.name)
+			msg = appendString(msg, 2, l.value)
+			ts = appendBytes(ts, 1, msg)
+		}
+		// As in any proto3 message, fields holding their zero value are
+		// left out.
+		msg = msg[:0]
+		if v := math.Float64bits(s.value); v != 0 {
+			msg = appendFixed64(msg, 1, v)
+		}
+		if s.timestamp != 0 {
+			msg = appendVarint(msg, 2, uint64(s.timestamp))
+		}
+		ts = appendBytes(ts, 2, msg)
+		buf = appendBytes(buf, 1, ts)
+	}
+	return buf
+}
@@ -0,0 +1,147 @@
+package push
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ncabatoff/process-exporter/collector"
+)
+
+var testUpdate = collector.SinkUpdate{
+	Time: time.Unix(1500000000, 0),
+	Samples: []collector.SinkSample{
+		{Group: "g1", Name: "cpu_seconds_per_second", Label: "mode", LabelValue: "user", Value: 0.25},
+		{Group: "g1", Name: "num_procs", Value: 2},
+		{Group: "a b.c", Name: "num_procs", Value: 1},
+	},
+}
+
+// readPackets returns the lines of the packets received on conn until none
+// arrive for a while.
+func readPackets(t *testing.T, conn net.PacketConn) (packets int, lines []string) {
+	buf := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return packets, lines
+		}
+		if n > statsdMaxPacket {
+			t.Errorf("got packet of %d bytes, want at most %d", n, statsdMaxPacket)
+		}
+		packets++
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	noerr(t, err)
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		dogStatsD bool
+		want      []string
+	}{
+		{false, []string{
+			"np.g1.cpu_seconds_per_second.user:0.25|g",
+			"np.g1.num_procs:2|g",
+			"np.a_b_c.num_procs:1|g",
+		}},
+		{true, []string{
+			"np.cpu_seconds_per_second:0.25|g|#groupname:g1,mode:user",
+			"np.num_procs:2|g|#groupname:g1",
+			"np.num_procs:1|g|#groupname:a_b.c",
+		}},
+	} {
+		s, err := NewStatsDSink(StatsDOption{
+			Address:   conn.LocalAddr().String(),
+			Prefix:    "np",
+			DogStatsD: tc.dogStatsD,
+		})
+		noerr(t, err)
+		noerr(t, s.Send(testUpdate))
+		_, got := readPackets(t, conn)
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("dogstatsd=%v: lines differ: (-got +want)\n%s", tc.dogStatsD, diff)
+		}
+		s.Close()
+	}
+}
+
+func TestStatsDPackets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	noerr(t, err)
+	defer conn.Close()
+
+	var u collector.SinkUpdate
+	for i := 0; i < 200; i++ {
+		u.Samples = append(u.Samples, collector.SinkSample{Group: "group", Name: "num_procs", Value: float64(i)})
+	}
+	s, err := NewStatsDSink(StatsDOption{Address: conn.LocalAddr().String()})
+	noerr(t, err)
+	defer s.Close()
+	noerr(t, s.Send(u))
+
+	packets, lines := readPackets(t, conn)
+	if packets < 2 {
+		t.Errorf("got %d packets, want several", packets)
+	}
+	if len(lines) != len(u.Samples) {
+		t.Errorf("got %d lines, want %d", len(lines), len(u.Samples))
+	}
+}
+
+func TestGraphite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	noerr(t, err)
+	defer ln.Close()
+
+	lines := make(chan string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Each connection is closed after a line, so the sink has to
+			// reconnect for the next update.
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+			lines <- line
+		}
+	}()
+
+	s, err := NewGraphiteSink(GraphiteOption{Address: ln.Addr().String(), Prefix: "np", Timeout: time.Second})
+	noerr(t, err)
+	defer s.Close()
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		// The first write after the server closed the connection may
+		// succeed; it's the following one that fails and reconnects.
+		for j := 0; j < 3; j++ {
+			if err := s.Send(testUpdate); err != nil {
+				t.Fatalf("send %d: %v", i, err)
+			}
+			select {
+			case line := <-lines:
+				got = append(got, line)
+			case <-time.After(time.Second):
+				continue
+			}
+			break
+		}
+	}
+	want := []string{
+		"np.g1.cpu_seconds_per_second.user 0.25 1500000000\n",
+		"np.g1.cpu_seconds_per_second.user 0.25 1500000000\n",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("lines differ: (-got +want)\n%s", diff)
+	}
+}
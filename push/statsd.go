@@ -0,0 +1,131 @@
+package push
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ncabatoff/process-exporter/collector"
+)
+
+// statsdMaxPacket is the maximum size of a StatsD packet, small enough to fit
+// an Ethernet MTU without fragmentation.
+const statsdMaxPacket = 1432
+
+type (
+	// StatsDOption configures a StatsDSink.
+	StatsDOption struct {
+		// Address is the host:port of the StatsD server, reached over UDP.
+		Address string
+		// Prefix, if not empty, is prepended to all metric names.
+		Prefix string
+		// DogStatsD sends the group name and labels as DogStatsD tags
+		// instead of as parts of the metric names.
+		DogStatsD bool
+	}
+
+	// StatsDSink is a collector.Sink sending the group gauges and counter
+	// rates as StatsD gauges.
+	StatsDSink struct {
+		options StatsDOption
+		conn    net.Conn
+	}
+)
+
+// NewStatsDSink returns a StatsDSink sending to options.Address.
+func NewStatsDSink(options StatsDOption) (*StatsDSink, error) {
+	if options.Address == "" {
+		return nil, fmt.Errorf("no StatsD address")
+	}
+	conn, err := net.Dial("udp", options.Address)
+	if err != nil {
+		return nil, fmt.Errorf("error opening StatsD connection: %v", err)
+	}
+	return &StatsDSink{options: options, conn: conn}, nil
+}
+
+// Send implements collector.Sink, sending the samples of u in packets of
+// newline-separated lines.
+func (s *StatsDSink) Send(u collector.SinkUpdate) error {
+	var packet []byte
+	for _, sample := range u.Samples {
+		line := s.line(sample)
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacket {
+			if _, err := s.conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if _, err := s.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// line returns the StatsD line of sample: prefix.group.name[.labelvalue]:v|g,
+// or with DogStatsD prefix.name:v|g|#groupname:group[,label:labelvalue].
+func (s *StatsDSink) line(sample collector.SinkSample) string {
+	value := formatFloat(sample.Value)
+	if !s.options.DogStatsD {
+		return metricPath(s.options.Prefix, sample) + ":" + value + "|g"
+	}
+	name := sample.Name
+	if s.options.Prefix != "" {
+		name = s.options.Prefix + "." + name
+	}
+	tags := "groupname:" + dogStatsDTag(sample.Group)
+	if sample.Label != "" {
+		tags += "," + sample.Label + ":" + dogStatsDTag(sample.LabelValue)
+	}
+	return name + ":" + value + "|g|#" + tags
+}
+
+// Close closes the connection.
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
+
+// metricPath returns the dotted path of sample, prefix.group.name[.labelvalue],
+// with the group and label value sanitized.
+func metricPath(prefix string, sample collector.SinkSample) string {
+	parts := []string{sanitizePathPart(sample.Group), sample.Name}
+	if prefix != "" {
+		parts = append([]string{prefix}, parts...)
+	}
+	if sample.LabelValue != "" {
+		parts = append(parts, sanitizePathPart(sample.LabelValue))
+	}
+	return strings.Join(parts, ".")
+}
+
+// sanitizePathPart replaces the characters of s that have a meaning in
+// StatsD and Graphite metric paths, or are unsafe in them, with '_'.
+func sanitizePathPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// dogStatsDTag replaces the characters of s that delimit DogStatsD tags with
+// '_'.
+func dogStatsDTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', ':', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}